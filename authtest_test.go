@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/l7mp/stunner-auth-service/pkg/authtest"
+	"github.com/l7mp/stunner-auth-service/pkg/client"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+func TestAuthTestServer(t *testing.T) {
+	s := authtest.NewServer(authtest.StaticAuthConfig())
+	defer s.Close()
+
+	c, err := s.NewClient()
+	assert.NoError(t, err, "create client")
+
+	ctx := context.Background()
+	token, err := c.GetTurnAuthToken(ctx, nil)
+	assert.NoError(t, err, "GetTurnAuthToken")
+	assert.Equal(t, "user1", *token.Username, "username")
+	assert.Equal(t, "pass1", *token.Password, "password")
+	assert.Contains(t, *token.Uris, "turn:1.2.3.4:3478?transport=udp", "UDP URI")
+
+	s.SetConfig(authtest.EphemeralAuthConfig())
+	ns, gw := "testnamespace", "testgateway"
+	iceConfig, err := c.GetIceConfig(ctx, &types.GetIceAuthParams{Namespace: &ns, Gateway: &gw})
+	assert.NoError(t, err, "GetIceConfig")
+	assert.Len(t, *iceConfig.IceServers, 1, "ICE servers len")
+	uris := *(*iceConfig.IceServers)[0].Urls
+	assert.Len(t, uris, 2, "URI len")
+	assert.Contains(t, uris, "turn:1.2.3.5:3478?transport=udp", "UDP URI")
+
+	s.SetConfig()
+	_, err = c.GetIceConfig(ctx, nil)
+	var iceErr *client.IceError
+	assert.True(t, errors.As(err, &iceErr), "ICE error")
+	assert.Equal(t, http.StatusInternalServerError, iceErr.Response.StatusCode(), "status")
+}
+
+func TestAuthTestServerSetConfig(t *testing.T) {
+	s := authtest.NewServer(authtest.StaticAuthConfig())
+	defer s.Close()
+
+	c, err := s.NewClient()
+	assert.NoError(t, err, "create client")
+
+	// replace the configs while requests are served, run with -race
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			_, _ = c.GetIceConfig(context.Background(), nil)
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		s.SetConfig(authtest.StaticAuthConfig(), authtest.EphemeralAuthConfig())
+	}
+	<-done
+
+	iceConfig, err := c.GetIceConfig(context.Background(), nil)
+	assert.NoError(t, err, "GetIceConfig")
+	assert.Len(t, *iceConfig.IceServers, 2, "ICE servers len")
+}
+
+func TestAuthTestFake(t *testing.T) {
+	f := authtest.NewFake()
+	defer f.Close()
+
+	c, err := f.NewClient()
+	assert.NoError(t, err, "create client")
+
+	f.Push(authtest.FakeResponse{Status: http.StatusForbidden, Error: "denied"},
+		authtest.FakeResponse{Latency: 500 * time.Millisecond})
+	f.SetDefault(authtest.NewFakeResponse("user2", "pass2", time.Hour,
+		"turn:5.6.7.8:3478?transport=tcp"))
+
+	ctx := context.Background()
+	_, err = c.GetTurnAuthToken(ctx, nil)
+	var turnErr *client.TurnError
+	assert.True(t, errors.As(err, &turnErr), "TURN error")
+	assert.Equal(t, http.StatusForbidden, turnErr.Response.StatusCode(), "status")
+
+	tctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = c.GetIceConfig(tctx, nil)
+	assert.Error(t, err, "latency timeout")
+
+	token, err := c.GetTurnAuthToken(ctx, nil)
+	assert.NoError(t, err, "GetTurnAuthToken")
+	assert.Equal(t, "user2", *token.Username, "username")
+	assert.Equal(t, int64(3600), *token.Ttl, "TTL")
+	assert.Equal(t, []string{"turn:5.6.7.8:3478?transport=tcp"}, *token.Uris, "URIs")
+
+	assert.Len(t, f.TurnRequests(), 2, "TURN requests")
+	assert.Len(t, f.IceRequests(), 1, "ICE requests")
+}
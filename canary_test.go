@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+	a12n "github.com/l7mp/stunner/pkg/authentication"
+	"github.com/l7mp/stunner/pkg/logger"
+
+	"github.com/l7mp/stunner-auth-service/internal/admin"
+	"github.com/l7mp/stunner-auth-service/internal/canary"
+	"github.com/l7mp/stunner-auth-service/internal/handler"
+	"github.com/l7mp/stunner-auth-service/internal/maintenance"
+	"github.com/l7mp/stunner-auth-service/internal/metrics"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+func TestCanaryParseSplit(t *testing.T) {
+	split, err := canary.ParseSplit("stunner/gw-a: 95%, stunner/gw-b: 5%")
+	assert.NoError(t, err, "parse")
+	assert.Equal(t, "stunner/gw-a,stunner/gw-b", split.Name, "name")
+	assert.Equal(t, []canary.Arm{{Gateway: "stunner/gw-a", Percent: 95}, {Gateway: "stunner/gw-b", Percent: 5}},
+		split.Arms, "arms")
+
+	for _, spec := range []string{
+		"stunner/gw-a: 95%, stunner/gw-b: 10%",
+		"stunner/gw-a: 100%",
+		"stunner/gw-a 95%, stunner/gw-b: 5%",
+		"gw-a: 95%, stunner/gw-b: 5%",
+		"stunner/gw-a: x%, stunner/gw-b: 5%",
+	} {
+		_, err := canary.ParseSplit(spec)
+		assert.Error(t, err, "invalid split %q", spec)
+	}
+}
+
+func TestCanary(t *testing.T) {
+	loggerFactory := logger.NewLoggerFactory(authTestLoglevel)
+	split, err := canary.ParseSplit("testnamespace/testgateway: 50%, dummynamespace/testgateway: 50%")
+	assert.NoError(t, err, "parse")
+	c, err := canary.New([]canary.Split{split}, loggerFactory.NewLogger("canary"))
+	assert.NoError(t, err, "create canary")
+
+	stable, canaryArm := split.Arms[0].Gateway, split.Arms[1].Gateway
+	assigned := map[string]int{}
+	testCases := []iceAuthTestCase{}
+	for i := 0; i < 20; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		arm := c.Assign(split, user)
+		assert.Equal(t, arm, c.Assign(split, user), "sticky assignment")
+		assigned[arm]++
+
+		testCases = append(testCases, iceAuthTestCase{
+			name:   "canary - " + user,
+			config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+			params: "service=turn&username=" + user,
+			status: 200,
+			tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+				uris := *(*iceConfig.IceServers)[0].Urls
+				// the TLS listener is not part of the split
+				assert.Contains(t, uris, "turns:127.0.0.1:3479?transport=tcp", "TLS URI")
+				if arm == stable {
+					assert.Len(t, uris, 3, "URI len")
+					assert.Contains(t, uris, "turn:1.2.3.4:3478?transport=udp", "UDP URI")
+				} else {
+					assert.Len(t, uris, 2, "URI len")
+					assert.Contains(t, uris, "turn:1.2.3.4:3478?transport=tcp", "TCP URI")
+				}
+			},
+		})
+	}
+	assert.Len(t, assigned, 2, "users assigned to both arms")
+
+	// the split does not apply to requests for other Gateways
+	testCases = append(testCases, iceAuthTestCase{
+		name:   "canary - other gateway",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&namespace=testnamespace&gateway=dummygateway",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Len(t, *(*iceConfig.IceServers)[0].Urls, 1, "URI len")
+		},
+	})
+
+	// failed requests are counted with an error result
+	testCases = append(testCases, iceAuthTestCase{
+		name:   "canary - no listener",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&username=user-0&listener=dummylistener",
+		status: http.StatusNotFound,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	})
+
+	// explain requests are not counted
+	explainTestCases := []iceAuthTestCase{{
+		name:   "canary - explain",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&username=user-0&explain=true",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	}}
+
+	counter := func(arm, result string) float64 {
+		return testutil.ToFloat64(metrics.CanaryAssignments.WithLabelValues(split.Name, arm, result))
+	}
+	stableBefore, canaryBefore := counter(stable, "success"), counter(canaryArm, "success")
+	errorBefore := counter(c.Assign(split, "user-0"), "error")
+
+	testICE(t, testCases, handler.WithRequestObserver(c), handler.WithListenerFilter(c))
+
+	assert.Equal(t, float64(assigned[stable]), counter(stable, "success")-stableBefore,
+		"stable arm assignments")
+	assert.Equal(t, float64(assigned[canaryArm]), counter(canaryArm, "success")-canaryBefore,
+		"canary arm assignments")
+	assert.Equal(t, 1.0, counter(c.Assign(split, "user-0"), "error")-errorBefore, "failed assignments")
+
+	explainBefore := counter(c.Assign(split, "user-0"), "success")
+	testICE(t, explainTestCases, handler.WithExplain(), handler.WithRequestObserver(c),
+		handler.WithListenerFilter(c))
+	assert.Equal(t, explainBefore, counter(c.Assign(split, "user-0"), "success"), "explain not counted")
+
+	// metrics are exposed on the admin API
+	w := adminRequest(admin.New(loggerFactory.NewLogger("admin")), "GET", "/metrics", "")
+	assert.Equal(t, http.StatusOK, w.Code, "metrics")
+	assert.Contains(t, w.Body.String(), "stunner_auth_canary_assignments_total", "canary metric")
+	assert.Contains(t, w.Body.String(), `stunner_auth_requests_total{api="ice",code="200"}`, "request metric")
+
+	// requests without a username are spread over the arms by client IP
+	anonymous := map[string]int{}
+	anonymousTestCases := []iceAuthTestCase{}
+	for i := 0; i < 20; i++ {
+		anonymousTestCases = append(anonymousTestCases, iceAuthTestCase{
+			name:   fmt.Sprintf("canary - anonymous %d", i),
+			config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+			params: fmt.Sprintf("service=turn&client-ip=10.0.0.%d", i),
+			status: 200,
+			tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+				uris := *(*iceConfig.IceServers)[0].Urls
+				if slices.Contains(uris, "turn:1.2.3.4:3478?transport=udp") {
+					anonymous[stable]++
+				} else {
+					anonymous[canaryArm]++
+				}
+			},
+		})
+	}
+	testICE(t, anonymousTestCases, handler.WithListenerFilter(c))
+	assert.Len(t, anonymous, 2, "anonymous requests assigned to both arms")
+
+	// a split used as a canary
+	c, err = canary.New([]canary.Split{{Name: "canary", Arms: []canary.Arm{
+		{Gateway: stable, Percent: 0}, {Gateway: canaryArm, Percent: 100}}}},
+		loggerFactory.NewLogger("canary"))
+	assert.NoError(t, err, "create canary")
+	store, err := maintenance.New("", loggerFactory.NewLogger("maintenance"))
+	assert.NoError(t, err, "create store")
+	testICE(t, []iceAuthTestCase{{
+		name:   "canary - canary arm assigned",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&username=user-0&namespace=testnamespace,dummynamespace&gateway=testgateway",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{"turn:1.2.3.4:3478?transport=tcp"}, *(*iceConfig.IceServers)[0].Urls,
+				"URIs")
+		},
+	}}, handler.WithListenerFilter(store), handler.WithListenerFilter(c))
+
+	// the other arm is used while the canary arm is draining
+	assert.NoError(t, store.Set(maintenance.Entry{Target: canaryArm, Mode: maintenance.ModeDraining}),
+		"drain canary arm")
+	testICE(t, []iceAuthTestCase{{
+		name:   "canary - assigned arm draining",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&username=user-0",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			uris := *(*iceConfig.IceServers)[0].Urls
+			assert.Len(t, uris, 3, "URI len")
+			assert.Contains(t, uris, "turn:1.2.3.4:3478?transport=udp", "UDP URI")
+			assert.Contains(t, uris, "turns:127.0.0.1:3479?transport=tcp", "TLS URI")
+		},
+	}}, handler.WithListenerFilter(store), handler.WithListenerFilter(c))
+
+	// a Gateway can be part of a single split only
+	_, err = canary.New([]canary.Split{split, split}, loggerFactory.NewLogger("canary"))
+	assert.Error(t, err, "overlapping splits")
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+	cdsclient "github.com/l7mp/stunner/pkg/config/client"
+	"github.com/l7mp/stunner/pkg/logger"
+
+	"github.com/l7mp/stunner-auth-service/internal/admin"
+	"github.com/l7mp/stunner-auth-service/internal/handler"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+)
+
+func TestConfigDelete(t *testing.T) {
+	loggerFactory := logger.NewLoggerFactory(authTestLoglevel)
+	conf := make(chan *stnrv1.StunnerConfig, 10)
+	h, err := handler.NewHandler(conf, loggerFactory.NewLogger("auth-svc"))
+	assert.NoError(t, err, "create handler")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h.Start(ctx)
+
+	name := staticAuthConfig.Admin.Name
+	conf <- staticAuthConfig.DeepCopy()
+	assert.Eventually(t, func() bool { return h.GetConfig(name) != nil }, time.Second,
+		10*time.Millisecond, "config added")
+
+	// the CDS server signals the deletion of a config with a zero config, which must not be
+	// stored
+	zero := cdsclient.ZeroConfig(name)
+	assert.NoError(t, zero.Validate(), "zero config")
+	conf <- zero
+	assert.Eventually(t, func() bool { return h.NumConfig() == 0 }, time.Second,
+		10*time.Millisecond, "config deleted")
+	assert.Nil(t, h.GetConfig(name), "config deleted")
+}
+
+func newConfigStoreTest(t *testing.T, opts ...handler.Option) (*handler.Handler, *admin.Server) {
+	loggerFactory := logger.NewLoggerFactory(authTestLoglevel)
+	h, err := handler.NewHandler(nil, loggerFactory.NewLogger("auth-svc"), opts...)
+	assert.NoError(t, err, "create handler")
+	h.SetConfig(staticAuthConfig.Admin.Name, staticAuthConfig.DeepCopy())
+	h.SetConfig(ephemeralAuthConfig.Admin.Name, ephemeralAuthConfig.DeepCopy())
+	return h, admin.New(loggerFactory.NewLogger("admin"), h)
+}
+
+func configStatus(t *testing.T, s *admin.Server, name string) handler.ConfigStatus {
+	w := adminRequest(s, "GET", "/status/configs", "")
+	assert.Equal(t, http.StatusOK, w.Code, "HTTP status")
+	status := []handler.ConfigStatus{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&status), "status")
+	for _, s := range status {
+		if s.Name == name {
+			return s
+		}
+	}
+	return handler.ConfigStatus{}
+}
+
+func TestAdminConfigs(t *testing.T) {
+	h, s := newConfigStoreTest(t)
+
+	// list configs
+	w := adminRequest(s, "GET", "/configs", "")
+	assert.Equal(t, http.StatusOK, w.Code, "HTTP status")
+	assert.NotContains(t, w.Body.String(), "pass1", "password redacted")
+	assert.NotContains(t, w.Body.String(), "my-secret", "secret redacted")
+	configs := []*stnrv1.StunnerConfig{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&configs), "configs")
+	assert.Len(t, configs, 2, "configs")
+	assert.Equal(t, ephemeralAuthConfig.Admin.Name, configs[0].Admin.Name, "sorted")
+	assert.Equal(t, "<redacted>", configs[0].Auth.Credentials["secret"], "secret redacted")
+	assert.Equal(t, "user1", configs[1].Auth.Credentials["username"], "username")
+	assert.Equal(t, "<redacted>", configs[1].Auth.Credentials["password"], "password redacted")
+	// the store is not modified
+	assert.Equal(t, "pass1", h.GetConfig(staticAuthConfig.Admin.Name).Auth.Credentials["password"])
+
+	// get a config
+	w = adminRequest(s, "GET", "/configs/"+staticAuthConfig.Admin.Name, "")
+	assert.Equal(t, http.StatusOK, w.Code, "HTTP status")
+	c := stnrv1.StunnerConfig{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&c), "config")
+	assert.Len(t, c.Listeners, 4, "listeners")
+	assert.Equal(t, "<redacted>", c.Auth.Credentials["password"], "password redacted")
+	w = adminRequest(s, "GET", "/configs/testnamespace/dummy", "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "HTTP status")
+
+	// Gateway listeners
+	w = adminRequest(s, "GET", "/gateways/testnamespace/testgateway/listeners", "")
+	assert.Equal(t, http.StatusOK, w.Code, "HTTP status")
+	ls := []handler.GatewayListener{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&ls), "listeners")
+	names := []string{}
+	for _, l := range ls {
+		names = append(names, l.Config+":"+l.Listener.Name)
+	}
+	assert.ElementsMatch(t, []string{
+		"testnamespace/stunnerd-static:testnamespace/testgateway/udp",
+		"testnamespace/stunnerd-static:testnamespace/testgateway/dtls",
+		"testnamespace/stunnerd-ephemeral:testnamespace/testgateway/udp-2",
+		"testnamespace/stunnerd-ephemeral:testnamespace/testgateway/dtls-2",
+	}, names, "listeners")
+	w = adminRequest(s, "GET", "/gateways/testnamespace/dummy/listeners", "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "HTTP status")
+
+	// generations
+	status := configStatus(t, s, staticAuthConfig.Admin.Name)
+	assert.Equal(t, handler.ConfigSourceCDS, status.Source, "source")
+	assert.Equal(t, int64(1), status.Generation, "generation")
+	assert.False(t, status.Updated.IsZero(), "updated")
+
+	h.SetConfig(staticAuthConfig.Admin.Name, staticAuthConfig.DeepCopy())
+	assert.Equal(t, int64(1), configStatus(t, s, staticAuthConfig.Admin.Name).Generation, "no change")
+
+	c2 := staticAuthConfig.DeepCopy()
+	c2.Listeners = c2.Listeners[:1]
+	h.SetConfig(c2.Admin.Name, c2)
+	status2 := configStatus(t, s, staticAuthConfig.Admin.Name)
+	assert.Equal(t, int64(2), status2.Generation, "generation")
+	assert.False(t, status2.Updated.Before(status.Updated), "updated")
+
+	h.DeleteConfig(c2.Admin.Name)
+	assert.Equal(t, 1, h.NumConfig(), "config deleted")
+	assert.Empty(t, configStatus(t, s, staticAuthConfig.Admin.Name).Name, "status deleted")
+
+	// overrides are disabled
+	w = adminRequest(s, "PUT", "/configs/"+staticAuthConfig.Admin.Name, "{}")
+	assert.Equal(t, http.StatusForbidden, w.Code, "HTTP status")
+	w = adminRequest(s, "DELETE", "/configs/"+staticAuthConfig.Admin.Name, "")
+	assert.Equal(t, http.StatusForbidden, w.Code, "HTTP status")
+}
+
+func TestAdminConfigOverride(t *testing.T) {
+	h, s := newConfigStoreTest(t, handler.WithConfigOverride())
+	name := staticAuthConfig.Admin.Name
+
+	override := staticAuthConfig.DeepCopy()
+	override.Admin.Name = ""
+	override.Listeners = override.Listeners[:1]
+	override.Listeners[0].PublicAddr = "5.6.7.8"
+	body, err := json.Marshal(override)
+	assert.NoError(t, err, "marshal")
+
+	w := adminRequest(s, "PUT", "/configs/"+name, string(body))
+	assert.Equal(t, http.StatusOK, w.Code, "HTTP status")
+	assert.NotContains(t, w.Body.String(), "pass1", "password redacted")
+
+	status := configStatus(t, s, name)
+	assert.Equal(t, handler.ConfigSourceOverride, status.Source, "source")
+	assert.True(t, status.Shadowed, "shadowed")
+	assert.Equal(t, int64(2), status.Generation, "generation")
+
+	// the override is used for generating credentials
+	iceConfig, _, err := credentials.GetIceConfig([]*stnrv1.StunnerConfig{h.GetConfig(name)},
+		credentials.Request{}, credentials.Options{})
+	assert.NoError(t, err, "ICE config")
+	assert.Equal(t, []string{"turn:5.6.7.8:3478?transport=udp"}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+
+	// CDS updates are shadowed
+	c := staticAuthConfig.DeepCopy()
+	c.Listeners = c.Listeners[1:]
+	h.SetConfig(name, c)
+	assert.Len(t, h.GetConfig(name).Listeners, 1, "override")
+	assert.Equal(t, "5.6.7.8", h.GetConfig(name).Listeners[0].PublicAddr, "override")
+
+	// delete the override
+	w = adminRequest(s, "DELETE", "/configs/"+name, "")
+	assert.Equal(t, http.StatusNoContent, w.Code, "HTTP status")
+	assert.Len(t, h.GetConfig(name).Listeners, 3, "CDS config")
+	status = configStatus(t, s, name)
+	assert.Equal(t, handler.ConfigSourceCDS, status.Source, "source")
+	assert.Equal(t, int64(4), status.Generation, "generation")
+	w = adminRequest(s, "DELETE", "/configs/"+name, "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "HTTP status")
+
+	// override a config not in the store
+	w = adminRequest(s, "PUT", "/configs/testnamespace/manual", string(body))
+	assert.Equal(t, http.StatusOK, w.Code, "HTTP status")
+	assert.Equal(t, 3, h.NumConfig(), "configs")
+	assert.Equal(t, "testnamespace/manual", h.GetConfig("testnamespace/manual").Admin.Name, "name")
+	w = adminRequest(s, "DELETE", "/configs/testnamespace/manual", "")
+	assert.Equal(t, http.StatusNoContent, w.Code, "HTTP status")
+	assert.Nil(t, h.GetConfig("testnamespace/manual"), "override deleted")
+
+	// invalid overrides
+	override.Admin.Name = "testnamespace/other"
+	body, _ = json.Marshal(override)
+	w = adminRequest(s, "PUT", "/configs/"+name, string(body))
+	assert.Equal(t, http.StatusBadRequest, w.Code, "name mismatch")
+	w = adminRequest(s, "PUT", "/configs/"+name, `{"version":"v0"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "invalid config")
+	w = adminRequest(s, "PUT", "/configs/"+name, `{`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "invalid body")
+	assert.Equal(t, handler.ConfigSourceCDS, configStatus(t, s, name).Source, "no override")
+}
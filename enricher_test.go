@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+	a12n "github.com/l7mp/stunner/pkg/authentication"
+	"github.com/l7mp/stunner/pkg/logger"
+
+	"github.com/l7mp/stunner-auth-service/internal/enricher"
+	"github.com/l7mp/stunner-auth-service/internal/handler"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+func testService(namespace, name string, labels map[string]string, ingress corev1.LoadBalancerIngress, ports ...corev1.ServicePort) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, Ports: ports},
+		Status: corev1.ServiceStatus{
+			LoadBalancer: corev1.LoadBalancerStatus{Ingress: []corev1.LoadBalancerIngress{ingress}},
+		},
+	}
+}
+
+func testGateway(namespace, name, addr string, listeners map[string]int64) *unstructured.Unstructured {
+	ls := []any{}
+	for l, p := range listeners {
+		ls = append(ls, map[string]any{"name": l, "port": p, "protocol": "TURN-UDP"})
+	}
+	return &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "gateway.networking.k8s.io/v1",
+		"kind":       "Gateway",
+		"metadata":   map[string]any{"namespace": namespace, "name": name},
+		"spec":       map[string]any{"gatewayClassName": "stunner-gatewayclass", "listeners": ls},
+		"status":     map[string]any{"addresses": []any{map[string]any{"type": "IPAddress", "value": addr}}},
+	}}
+}
+
+// noPublicAddrPatch removes the public addresses of all listeners
+func noPublicAddrPatch(c *stnrv1.StunnerConfig) {
+	for i := range c.Listeners {
+		c.Listeners[i].PublicAddr = ""
+		c.Listeners[i].PublicPort = 0
+	}
+}
+
+var iceEnricherTestCases = []iceAuthTestCase{
+	{
+		name:   "enricher - addresses from Service and Gateway status",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		patch:  noPublicAddrPatch,
+		params: "service=turn",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				// testnamespace/testgateway: Service labeled with the Gateway name
+				"turn:1.1.1.1:30478?transport=udp",
+				// dummynamespace/testgateway: Service named after the Gateway, hostname
+				"turn:lb.example.com:443?transport=tcp",
+				// testnamespace/dummygateway: Gateway status
+				"turns:2.2.2.2:5349?transport=tcp",
+				// testnamespace/testgateway: port by protocol
+				"turns:1.1.1.1:30479?transport=udp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "enricher - existing public addresses kept",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&namespace=testnamespace&gateway=testgateway",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:1.2.3.4:3478?transport=udp",
+				"turns:1.1.1.1:30479?transport=udp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "enricher - request takes precedence",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		patch:  noPublicAddrPatch,
+		params: "service=turn&namespace=testnamespace&gateway=testgateway&public-port=8443",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:1.1.1.1:8443?transport=udp",
+				"turns:1.1.1.1:8443?transport=udp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+}
+
+func TestAddressEnricher(t *testing.T) {
+	loggerFactory := logger.NewLoggerFactory(authTestLoglevel)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := fake.NewClientset(
+		testService("testnamespace", "stunner-udp", map[string]string{
+			stnrv1.DefaultRelatedGatewayKey:       "testgateway",
+			stnrv1.DefaultRelatedGatewayNamespace: "testnamespace",
+		}, corev1.LoadBalancerIngress{IP: "1.1.1.1"},
+			corev1.ServicePort{Name: "other", Protocol: corev1.ProtocolUDP, Port: 30479},
+			corev1.ServicePort{Name: "udp", Protocol: corev1.ProtocolUDP, Port: 30478}),
+		testService("dummynamespace", "testgateway", nil, corev1.LoadBalancerIngress{Hostname: "lb.example.com"},
+			corev1.ServicePort{Name: "tcp", Protocol: corev1.ProtocolTCP, Port: 443}),
+		// not a LoadBalancer: ignored
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "testnamespace", Name: "dummygateway"},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP},
+		},
+	)
+	dynClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{enricher.GatewayResource: "GatewayList"})
+	gateways := dynClient.Resource(enricher.GatewayResource).Namespace("testnamespace")
+	_, err := gateways.Create(ctx, testGateway("testnamespace", "dummygateway", "2.2.2.2",
+		map[string]int64{"tls": 5349}), metav1.CreateOptions{})
+	assert.NoError(t, err, "create Gateway")
+
+	e := enricher.New(client, enricher.NewGatewayInformerFactory(dynClient), loggerFactory.NewLogger("enricher"))
+	assert.NoError(t, e.Start(ctx), "start")
+
+	runICE(t, iceEnricherTestCases, handler.WithAddressEnricher(e))
+
+	// the informers follow the changes
+	_, err = gateways.Create(ctx, testGateway("testnamespace", "newgateway", "3.3.3.3",
+		map[string]int64{"udp": 3478}), metav1.CreateOptions{})
+	assert.NoError(t, err, "create Gateway")
+	l := &stnrv1.ListenerConfig{Name: "testnamespace/newgateway/udp", Protocol: "TURN-UDP"}
+	assert.Eventually(t, func() bool {
+		return e.OverrideAddress(l) == credentials.AddressOverride{PublicAddr: "3.3.3.3", PublicPort: 3478}
+	}, 5*time.Second, 10*time.Millisecond, "Gateway added")
+
+	assert.NoError(t, client.CoreV1().Services("dummynamespace").Delete(ctx, "testgateway",
+		metav1.DeleteOptions{}), "delete Service")
+	l = &stnrv1.ListenerConfig{Name: "dummynamespace/testgateway/tcp", Protocol: "TURN-TCP"}
+	assert.Eventually(t, func() bool {
+		return e.OverrideAddress(l) == credentials.AddressOverride{}
+	}, 5*time.Second, 10*time.Millisecond, "Service removed")
+}
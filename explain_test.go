@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+	"github.com/l7mp/stunner/pkg/logger"
+
+	"github.com/l7mp/stunner-auth-service/internal/handler"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+	"github.com/l7mp/stunner-auth-service/pkg/server"
+)
+
+// explainRequest calls the ICE or the TURN API of a handler with the given STUNner configs and
+// returns the status and the body of the response.
+func explainRequest(t *testing.T, api, params string, configs []*stnrv1.StunnerConfig, opts ...handler.Option) (int, []byte) {
+	loggerFactory := logger.NewLoggerFactory(authTestLoglevel)
+	h, err := handler.NewHandler(nil, loggerFactory.NewLogger("auth-svc"), opts...)
+	assert.NoError(t, err, "create handler")
+	for _, c := range configs {
+		h.SetConfig(c.Admin.Name, c)
+	}
+
+	serv := server.ServerInterfaceWrapper{Handler: h}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://example.com/"+api+"?"+params, nil)
+	if api == "ice" {
+		serv.GetIceAuth(w, req)
+	} else {
+		serv.GetTurnAuth(w, req)
+	}
+
+	body, err := io.ReadAll(w.Result().Body)
+	assert.NoError(t, err, "read body")
+	return w.Result().StatusCode, body
+}
+
+// listenerExplanation returns the explanation of a listener.
+func listenerExplanation(t *testing.T, e *credentials.Explanation, config, listener string) credentials.ListenerExplanation {
+	for _, c := range e.Configs {
+		for _, l := range c.Listeners {
+			if c.Name == config && l.Name == listener {
+				return l
+			}
+		}
+	}
+	assert.Fail(t, "no explanation for listener", "config: %s, listener: %s", config, listener)
+	return credentials.ListenerExplanation{}
+}
+
+func TestExplainAPI(t *testing.T) {
+	configs := []*stnrv1.StunnerConfig{&staticAuthConfig, &ephemeralAuthConfig}
+
+	t.Run("ICE", func(t *testing.T) {
+		status, body := explainRequest(t, "ice", "service=turn&explain=true&namespace=testnamespace",
+			configs, handler.WithExplain())
+		assert.Equal(t, 200, status, "HTTP status")
+		assert.NotContains(t, string(body), "pass1", "no credentials")
+		assert.NotContains(t, string(body), "my-secret", "no credentials")
+		assert.NotContains(t, string(body), "iceServers", "no ICE config")
+
+		e := credentials.Explanation{}
+		assert.NoError(t, json.Unmarshal(body, &e), "explanation")
+		assert.Equal(t, "testnamespace", e.Request.Namespace, "request")
+		assert.Empty(t, e.Error, "error")
+		assert.Len(t, e.Configs, 2, "configs")
+		authTypes := map[string]string{}
+		for _, c := range e.Configs {
+			authTypes[c.Name] = c.AuthType
+		}
+		assert.Equal(t, map[string]string{
+			staticAuthConfig.Admin.Name:    "static",
+			ephemeralAuthConfig.Admin.Name: "ephemeral",
+		}, authTypes, "auth types")
+		assert.Len(t, e.URIs, 6, "URIs")
+
+		udp := listenerExplanation(t, &e, "testnamespace/stunnerd-static", "testnamespace/testgateway/udp")
+		assert.True(t, udp.Included, "included")
+		assert.Equal(t, credentials.AddressSourceListener, udp.AddressSource, "address source")
+		assert.Equal(t, []string{"turn:1.2.3.4:3478?transport=udp"}, udp.URIs, "URIs")
+
+		tcp := listenerExplanation(t, &e, "testnamespace/stunnerd-static", "dummynamespace/testgateway/tcp")
+		assert.False(t, tcp.Included, "excluded")
+		assert.Len(t, tcp.Excluded, 1, "reasons")
+		assert.Contains(t, tcp.Excluded[0], "gateway namespace mismatch", "reason")
+		assert.Empty(t, tcp.URIs, "URIs")
+	})
+
+	t.Run("TURN", func(t *testing.T) {
+		status, body := explainRequest(t, "turn", "service=turn&explain=true&public-addr=5.6.7.8&transport=udp",
+			configs, handler.WithExplain())
+		assert.Equal(t, 200, status, "HTTP status")
+		assert.NotContains(t, string(body), "pass1", "no credentials")
+		assert.NotContains(t, string(body), "password", "no TURN auth token")
+
+		e := credentials.Explanation{}
+		assert.NoError(t, json.Unmarshal(body, &e), "explanation")
+		// only the TURN server of the first config is returned
+		assert.Equal(t, []string{"turn:5.6.7.8:3478?transport=udp"}, e.URIs, "URIs")
+		udp := listenerExplanation(t, &e, "testnamespace/stunnerd-static", "testnamespace/testgateway/udp")
+		assert.Equal(t, credentials.AddressSourceRequest, udp.AddressSource, "address source")
+		dtls := listenerExplanation(t, &e, "testnamespace/stunnerd-static", "testnamespace/testgateway/dtls")
+		assert.Contains(t, dtls.Excluded[0], "transport mismatch", "reason")
+	})
+
+	t.Run("no listener", func(t *testing.T) {
+		status, body := explainRequest(t, "ice", "service=turn&explain=true&gateway=dummy",
+			configs, handler.WithExplain())
+		assert.Equal(t, 200, status, "HTTP status")
+		e := credentials.Explanation{}
+		assert.NoError(t, json.Unmarshal(body, &e), "explanation")
+		assert.Equal(t, credentials.ErrNoListener.Error(), e.Error, "error")
+		assert.Empty(t, e.URIs, "URIs")
+		for _, c := range e.Configs {
+			for _, l := range c.Listeners {
+				assert.False(t, l.Included, "excluded: %s", l.Name)
+				assert.Contains(t, l.Excluded[0], "gateway name mismatch", "reason: %s", l.Name)
+			}
+		}
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		status, _ := explainRequest(t, "ice", "service=turn&explain=true&transport=sctp",
+			configs, handler.WithExplain())
+		assert.Equal(t, 400, status, "HTTP status")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		status, _ := explainRequest(t, "ice", "service=turn&explain=true", configs)
+		assert.Equal(t, 403, status, "HTTP status")
+		status, _ = explainRequest(t, "turn", "service=turn&explain=true", configs)
+		assert.Equal(t, 403, status, "HTTP status")
+	})
+}
+
+func TestExplainAddressSources(t *testing.T) {
+	c := staticAuthConfig.DeepCopy()
+	c.Listeners[2].PublicAddr = ""
+	c.Listeners[3].PublicAddr = ""
+	opts := credentials.Options{
+		PublicAddr:       "7.7.7.7",
+		AddressOverrider: credentials.AddressOverrides{"turn-dtls": {Hostname: "turn.example.com"}},
+	}
+
+	e := credentials.ExplainIceConfig([]*stnrv1.StunnerConfig{c}, credentials.Request{}, opts)
+	assert.Empty(t, e.Error, "error")
+	for listener, source := range map[string]credentials.AddressSource{
+		"testnamespace/testgateway/udp":  credentials.AddressSourceEnvironment,
+		"testnamespace/dummygateway/tls": credentials.AddressSourceEnvironment,
+		"testnamespace/testgateway/dtls": credentials.AddressSourceHostname,
+	} {
+		l := listenerExplanation(t, e, c.Admin.Name, listener)
+		assert.Equal(t, source, l.AddressSource, "address source: %s", listener)
+		assert.True(t, l.Included, "included: %s", listener)
+	}
+
+	// unroutable addresses
+	opts = credentials.Options{Unroutable: credentials.UnroutablePolicy{credentials.AddressLoopback: credentials.UnroutableDrop}}
+	e = credentials.ExplainIceConfig([]*stnrv1.StunnerConfig{c}, credentials.Request{}, opts)
+	l := listenerExplanation(t, e, c.Admin.Name, "testnamespace/dummygateway/tls")
+	assert.False(t, l.Included, "excluded")
+	assert.Contains(t, l.Excluded[0], "unroutable address: loopback", "reason")
+}
+
+func TestExplainAuthError(t *testing.T) {
+	c := staticAuthConfig.DeepCopy()
+	delete(c.Auth.Credentials, "password")
+
+	e := credentials.ExplainIceConfig([]*stnrv1.StunnerConfig{c}, credentials.Request{}, credentials.Options{})
+	assert.Contains(t, e.Configs[0].AuthError, "no username or password", "auth error")
+	assert.Equal(t, credentials.ErrNoListener.Error(), e.Error, "error")
+	assert.Empty(t, e.URIs, "URIs")
+	// the listeners are still explained
+	l := listenerExplanation(t, e, c.Admin.Name, "testnamespace/testgateway/udp")
+	assert.Equal(t, []string{"turn:1.2.3.4:3478?transport=udp"}, l.URIs, "URIs")
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pion/turn/v4"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/l7mp/stunner"
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+	a12n "github.com/l7mp/stunner/pkg/authentication"
+	"github.com/l7mp/stunner/pkg/logger"
+
+	"github.com/l7mp/stunner-auth-service/internal/admin"
+	"github.com/l7mp/stunner-auth-service/internal/handler"
+	"github.com/l7mp/stunner-auth-service/internal/health"
+	"github.com/l7mp/stunner-auth-service/pkg/authtest"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+// turnTestServer is a pion/turn server with static authentication listening on UDP, TCP and TLS
+// on localhost, standing in for a STUNner Gateway.
+type turnTestServer struct {
+	*turn.Server
+	udpPort, tcpPort, tlsPort int
+}
+
+func startTurnServer(t *testing.T, username, password, realm string) *turnTestServer {
+	t.Helper()
+
+	udpConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "UDP listener")
+	tcpListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert.NoError(t, err, "TCP listener")
+
+	certPem, keyPem, err := stunner.GenerateSelfSignedKey()
+	assert.NoError(t, err, "generate cert")
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	assert.NoError(t, err, "load cert")
+	tlsListener, err := tls.Listen("tcp4", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	assert.NoError(t, err, "TLS listener")
+
+	relay := func() turn.RelayAddressGenerator {
+		return &turn.RelayAddressGeneratorStatic{RelayAddress: net.ParseIP("127.0.0.1"), Address: "127.0.0.1"}
+	}
+	key := turn.GenerateAuthKey(username, realm, password)
+	s, err := turn.NewServer(turn.ServerConfig{
+		Realm: realm,
+		AuthHandler: func(u, _ string, _ net.Addr) ([]byte, bool) {
+			return key, u == username
+		},
+		PacketConnConfigs: []turn.PacketConnConfig{{PacketConn: udpConn, RelayAddressGenerator: relay()}},
+		ListenerConfigs: []turn.ListenerConfig{
+			{Listener: tcpListener, RelayAddressGenerator: relay()},
+			{Listener: tlsListener, RelayAddressGenerator: relay()},
+		},
+		LoggerFactory: logger.NewLoggerFactory(authTestLoglevel),
+	})
+	assert.NoError(t, err, "start TURN server")
+	t.Cleanup(func() { s.Close() }) //nolint:errcheck
+
+	return &turnTestServer{
+		Server:  s,
+		udpPort: udpConn.LocalAddr().(*net.UDPAddr).Port,
+		tcpPort: tcpListener.Addr().(*net.TCPAddr).Port,
+		tlsPort: tlsListener.Addr().(*net.TCPAddr).Port,
+	}
+}
+
+// closedPorts returns a UDP and a TCP port on localhost nobody listens on.
+func closedPorts(t *testing.T) (int, int) {
+	t.Helper()
+	udpConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "UDP listener")
+	tcpListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert.NoError(t, err, "TCP listener")
+	defer udpConn.Close()     //nolint:errcheck
+	defer tcpListener.Close() //nolint:errcheck
+	return udpConn.LocalAddr().(*net.UDPAddr).Port, tcpListener.Addr().(*net.TCPAddr).Port
+}
+
+func healthTestConfig(t *testing.T) *stnrv1.StunnerConfig {
+	s := startTurnServer(t, "user1", "pass1", stnrv1.DefaultRealm)
+	udpDown, tcpDown := closedPorts(t)
+
+	c := testConfig(authtest.NewConfig("testnamespace/stunnerd-health", authtest.StaticAuth("user1", "pass1"),
+		authtest.Listener("testnamespace/testgateway/udp", "turn-udp", "127.0.0.1", s.udpPort, "", 0),
+		authtest.Listener("testnamespace/testgateway/tcp", "turn-tcp", "127.0.0.1", s.tcpPort, "", 0),
+		authtest.Listener("testnamespace/testgateway/tls", "turn-tls", "127.0.0.1", s.tlsPort, "", 0),
+		authtest.Listener("testnamespace/testgateway/udp-down", "turn-udp", "127.0.0.1", udpDown, "", 0),
+		authtest.Listener("testnamespace/testgateway/tcp-down", "turn-tcp", "127.0.0.1", tcpDown, "", 0),
+		authtest.Listener("testnamespace/testgateway/dtls", "turn-dtls", "127.0.0.1", 3479, "", 0),
+	))
+	return &c
+}
+
+func TestHealthProbe(t *testing.T) {
+	loggerFactory := logger.NewLoggerFactory(authTestLoglevel)
+	c := healthTestConfig(t)
+	uri := func(i int) string {
+		u, err := stunner.GetUriFromListener(&c.Listeners[i])
+		assert.NoError(t, err, "URI")
+		return u
+	}
+
+	_, err := health.New(health.Config{Mode: "dummy"}, loggerFactory.NewLogger("health"))
+	assert.Error(t, err, "invalid mode")
+
+	// below the failure threshold all listeners are healthy
+	p, err := health.New(health.Config{Timeout: 500 * time.Millisecond, Threshold: 2},
+		loggerFactory.NewLogger("health"))
+	assert.NoError(t, err, "create prober")
+	p.ProbeAll(context.Background(), []*stnrv1.StunnerConfig{c}, credentials.Options{})
+	status := p.Status()
+	assert.Len(t, status, 5, "DTLS listener not probed")
+	for _, s := range status {
+		assert.True(t, s.Healthy, "%s healthy below threshold", s.Listener)
+	}
+
+	p.ProbeAll(context.Background(), []*stnrv1.StunnerConfig{c}, credentials.Options{})
+	status = p.Status()
+	assert.Len(t, status, 5, "status len")
+	for _, s := range status {
+		switch s.Listener {
+		case "testnamespace/testgateway/udp-down", "testnamespace/testgateway/tcp-down":
+			assert.False(t, s.Healthy, "%s unhealthy", s.Listener)
+			assert.Equal(t, 2, s.Failures, "%s failures", s.Listener)
+			assert.NotEmpty(t, s.Error, "%s error", s.Listener)
+		default:
+			assert.True(t, s.Healthy, "%s healthy", s.Listener)
+			assert.Equal(t, 0, s.Failures, "%s failures", s.Listener)
+			assert.NotEmpty(t, s.RTT, "%s RTT", s.Listener)
+		}
+	}
+
+	// status on the admin API
+	w := adminRequest(admin.New(loggerFactory.NewLogger("admin"), p), "GET", "/health/listeners", "")
+	assert.Equal(t, http.StatusOK, w.Code, "status")
+	status = []health.Status{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &status), "decode")
+	assert.Len(t, status, 5, "status len")
+	assert.Equal(t, "testnamespace/testgateway/tcp", status[0].Listener, "listener")
+	assert.Equal(t, "TURN-TCP", status[0].Protocol, "protocol")
+	assert.True(t, status[0].Healthy, "healthy")
+
+	// exclude mode
+	runICE(t, []iceAuthTestCase{{
+		name:   "health - exclude unhealthy listeners",
+		config: []*stnrv1.StunnerConfig{c},
+		params: "service=turn",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			uris := *(*iceConfig.IceServers)[0].Urls
+			assert.Equal(t, []string{uri(0), uri(1), uri(2), uri(5)}, uris, "URIs")
+		},
+	}, {
+		name:   "health - listener probed at another address",
+		config: []*stnrv1.StunnerConfig{c},
+		params: "service=turn&public-addr=127.0.0.2",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Len(t, *(*iceConfig.IceServers)[0].Urls, 6, "URI len")
+		},
+	}}, handler.WithListenerFilter(p), handler.WithListenerScorer(p))
+
+	// demote mode
+	p, err = health.New(health.Config{Mode: health.ModeDemote, Timeout: 500 * time.Millisecond, Threshold: 1},
+		loggerFactory.NewLogger("health"))
+	assert.NoError(t, err, "create prober")
+	p.ProbeAll(context.Background(), []*stnrv1.StunnerConfig{c}, credentials.Options{})
+
+	runICE(t, []iceAuthTestCase{{
+		name:   "health - demote unhealthy listeners",
+		config: []*stnrv1.StunnerConfig{c},
+		params: "service=turn",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			uris := *(*iceConfig.IceServers)[0].Urls
+			assert.Equal(t, []string{uri(0), uri(1), uri(2), uri(5), uri(3), uri(4)}, uris, "URIs")
+		},
+	}}, handler.WithListenerFilter(p), handler.WithListenerScorer(p))
+
+	// removed listeners are forgotten
+	p.ProbeAll(context.Background(), []*stnrv1.StunnerConfig{}, credentials.Options{})
+	assert.Empty(t, p.Status(), "status after config removal")
+}
+
+func TestHealthProbeCertHostname(t *testing.T) {
+	loggerFactory := logger.NewLoggerFactory(authTestLoglevel)
+	s := startTurnServer(t, "user1", "pass1", stnrv1.DefaultRealm)
+	_, tlsDown := closedPorts(t)
+	cert := newTestCert(t, []string{"localhost"}, nil)
+
+	tlsUp := authtest.Listener("testnamespace/testgateway/tls", "turn-tls", "127.0.0.1", s.tlsPort, "", 0)
+	tlsUp.Cert = cert
+	tlsBroken := authtest.Listener("testnamespace/testgateway/tls-down", "turn-tls", "127.0.0.1", tlsDown, "", 0)
+	tlsBroken.Cert = cert
+	c := testConfig(authtest.NewConfig("testnamespace/stunnerd-health", authtest.StaticAuth("user1", "pass1"),
+		authtest.Listener("testnamespace/testgateway/udp", "turn-udp", "127.0.0.1", s.udpPort, "", 0),
+		tlsUp, tlsBroken))
+
+	// the TLS listeners are probed at the hostname from the certificate, the same as in the URIs
+	p, err := health.New(health.Config{Timeout: 500 * time.Millisecond, Threshold: 1},
+		loggerFactory.NewLogger("health"))
+	assert.NoError(t, err, "create prober")
+	p.ProbeAll(context.Background(), []*stnrv1.StunnerConfig{&c}, credentials.Options{CertHostname: true})
+	status := p.Status()
+	assert.Len(t, status, 3, "status len")
+	for _, s := range status {
+		switch s.Listener {
+		case "testnamespace/testgateway/tls", "testnamespace/testgateway/tls-down":
+			assert.Regexp(t, `^localhost:\d+$`, s.Address, "%s address", s.Listener)
+		}
+		assert.Equal(t, s.Listener != "testnamespace/testgateway/tls-down", s.Healthy, "%s healthy", s.Listener)
+	}
+
+	runICE(t, []iceAuthTestCase{{
+		name:   "health - exclude unhealthy listeners at the certificate hostname",
+		config: []*stnrv1.StunnerConfig{&c},
+		params: "service=turn",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				fmt.Sprintf("turn:127.0.0.1:%d?transport=udp", s.udpPort),
+				fmt.Sprintf("turns:localhost:%d?transport=tcp", s.tlsPort),
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	}}, handler.WithCertHostname(), handler.WithListenerFilter(p))
+}
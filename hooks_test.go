@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+	a12n "github.com/l7mp/stunner/pkg/authentication"
+
+	"github.com/l7mp/stunner-auth-service/internal/handler"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+var iceHookTestCases = []iceAuthTestCase{
+	{
+		name:   "hooks - namespace forced, protocol excluded, policy overridden",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.NotNil(t, iceConfig.IceServers, "ICE servers nil")
+			iceServers := *iceConfig.IceServers
+			assert.Len(t, iceServers, 1, "ICE servers len")
+			uris := *iceServers[0].Urls
+			assert.Len(t, uris, 2, "URI len")
+			assert.Contains(t, uris, "turn:1.2.3.4:3478?transport=udp", "UDP URI")
+			assert.Contains(t, uris, "turns:127.0.0.1:3479?transport=tcp", "TLS URI")
+			assert.NotNil(t, iceConfig.IceTransportPolicy, "ICE transport policy nil")
+			assert.Equal(t, types.Relay, *iceConfig.IceTransportPolicy, "ICE transport policy")
+		},
+	},
+	{
+		name:   "hooks - namespace denied",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&namespace=dummynamespace",
+		status: http.StatusForbidden,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	},
+	{
+		name:   "hooks - custom authorizer rejects invalid request",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&username=invalid",
+		status: http.StatusBadRequest,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	},
+	{
+		name:   "hooks - mutator empties response",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&username=empty",
+		status: http.StatusNotFound,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	},
+}
+
+func TestICEHooks(t *testing.T) {
+	excludeDTLS, err := credentials.ExcludeProtocols("turn-dtls")
+	assert.NoError(t, err, "exclude protocols")
+	_, err = credentials.ExcludeProtocols("turn-udp", "turn-dtsl")
+	assert.Error(t, err, "invalid protocol")
+
+	testICE(t, iceHookTestCases,
+		handler.WithRequestAuthorizer(handler.ForceNamespace("testnamespace")),
+		handler.WithRequestAuthorizer(handler.RequestAuthorizerFunc(
+			func(_ *http.Request, params *types.GetIceAuthParams) error {
+				if params.Username != nil && *params.Username == "invalid" {
+					return errors.Join(credentials.ErrInvalidRequest, errors.New("invalid username"))
+				}
+				return nil
+			})),
+		handler.WithListenerFilter(excludeDTLS),
+		handler.WithResponseMutator(credentials.ForceIceTransportPolicy(types.Relay)),
+		handler.WithResponseMutator(credentials.ResponseMutatorFunc(
+			func(req *credentials.Request, iceConfig *types.IceConfig) error {
+				if req.Username == "empty" {
+					iceConfig.IceServers = &[]types.IceAuthenticationToken{}
+				}
+				return nil
+			})),
+	)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+	a12n "github.com/l7mp/stunner/pkg/authentication"
+
+	"github.com/l7mp/stunner-auth-service/internal/handler"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+// newTestCert returns a base64-encoded PEM certificate with the given DNS names and IP addresses
+// in the SAN.
+func newTestCert(t *testing.T, dnsNames []string, ips []net.IP) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err, "generate key")
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "stunner"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     dnsNames,
+		IPAddresses:  ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	assert.NoError(t, err, "create certificate")
+	return base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+var iceHostnameTestCases = []iceAuthTestCase{
+	{
+		name:   "hostname - from address override",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:1.2.3.4:3478?transport=udp",
+				"turn:1.2.3.4:3478?transport=tcp",
+				"turns:turn.example.com:3479?transport=tcp",
+				"turns:dtls.example.com:443?transport=udp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "hostname - public address from request",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&public-addr=1.1.1.1",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:1.1.1.1:3478?transport=udp",
+				"turn:1.1.1.1:3478?transport=tcp",
+				"turns:1.1.1.1:3479?transport=tcp",
+				"turns:1.1.1.1:443?transport=udp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+}
+
+func TestHostnameOverride(t *testing.T) {
+	o := credentials.AddressOverrides{
+		"turn-tls":                       {Hostname: "turn.example.com"},
+		"turn-udp":                       {Hostname: "ignored.example.com"},
+		"testnamespace/testgateway/dtls": {Hostname: "dtls.example.com", PublicPort: 443},
+	}
+	assert.NoError(t, o.Validate(), "valid overrides")
+	testICE(t, iceHostnameTestCases, handler.WithAddressOverrider(o))
+
+	for _, h := range []string{"1.2.3.4", "2001:db8::1", "bad_host"} {
+		o := credentials.AddressOverrides{"turn-tls": {Hostname: h}}
+		assert.Error(t, o.Validate(), "invalid hostname %q", h)
+	}
+}
+
+func TestHostnameFromCert(t *testing.T) {
+	cert := newTestCert(t, []string{"*.example.com", "turn.example.com"}, []net.IP{net.ParseIP("1.2.3.4")})
+	wildcard := newTestCert(t, []string{"*.example.com"}, nil)
+
+	testICE(t, []iceAuthTestCase{
+		{
+			name:   "hostname - from certificate",
+			config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+			patch: func(c *stnrv1.StunnerConfig) {
+				c.Listeners[2].Cert = cert
+				c.Listeners[3].Cert = wildcard
+			},
+			params: "service=turn&namespace=testnamespace",
+			status: 200,
+			tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+				assert.Equal(t, []string{
+					"turn:1.2.3.4:3478?transport=udp",
+					"turns:turn.example.com:3479?transport=tcp",
+					"turns:127.0.0.1:3479?transport=udp",
+				}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+			},
+		},
+	}, handler.WithCertHostname())
+}
+
+func TestCertificateMismatch(t *testing.T) {
+	cert := newTestCert(t, []string{"turn.example.com"}, []net.IP{net.ParseIP("1.2.3.4")})
+	c := staticAuthConfig.DeepCopy()
+	c.Listeners[2].Cert = cert
+	c.Listeners[3].Cert = cert
+
+	warnings := func(diags credentials.Diagnostics) []string {
+		ret := []string{}
+		for _, d := range diags {
+			if d.Severity == credentials.SeverityWarning {
+				ret = append(ret, d.Listener)
+			}
+		}
+		return ret
+	}
+
+	for _, tc := range []struct {
+		name, publicAddr string
+		warnings         []string
+	}{
+		{"IP in SAN", "1.2.3.4", []string{}},
+		{"hostname in SAN", "turn.example.com", []string{}},
+		{"IP not in SAN", "5.6.7.8", []string{"testnamespace/dummygateway/tls", "testnamespace/testgateway/dtls"}},
+		{"hostname not in SAN", "stun.example.com", []string{"testnamespace/dummygateway/tls", "testnamespace/testgateway/dtls"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := credentials.Request{PublicAddr: tc.publicAddr}
+			_, diags, err := credentials.GetIceConfig([]*stnrv1.StunnerConfig{c}, req, credentials.Options{})
+			assert.NoError(t, err, "generate ICE config")
+			assert.Equal(t, tc.warnings, warnings(diags), "certificate warnings")
+		})
+	}
+
+	// with a cache, each certificate is parsed once and mismatches are warned about once
+	certs := credentials.NewCertificateCache()
+	opts := credentials.Options{Certificates: certs}
+	req := credentials.Request{PublicAddr: "5.6.7.8"}
+	_, diags, err := credentials.GetIceConfig([]*stnrv1.StunnerConfig{c}, req, opts)
+	assert.NoError(t, err, "generate ICE config")
+	assert.Len(t, warnings(diags), 2, "first request warns")
+	_, diags, err = credentials.GetIceConfig([]*stnrv1.StunnerConfig{c}, req, opts)
+	assert.NoError(t, err, "generate ICE config")
+	assert.Empty(t, warnings(diags), "second request does not warn")
+
+	req.PublicAddr = "stun.example.com"
+	_, diags, err = credentials.GetIceConfig([]*stnrv1.StunnerConfig{c}, req, opts)
+	assert.NoError(t, err, "generate ICE config")
+	assert.Len(t, warnings(diags), 2, "new address warns")
+
+	// removing the certificate from the configs drops the cached results
+	certs.Retain([]*stnrv1.StunnerConfig{&staticAuthConfig})
+	_, diags, err = credentials.GetIceConfig([]*stnrv1.StunnerConfig{c}, req, opts)
+	assert.NoError(t, err, "generate ICE config")
+	assert.Len(t, warnings(diags), 2, "warns again after the config update")
+}
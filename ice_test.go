@@ -643,25 +643,141 @@ var iceAuthTestCases = []iceAuthTestCase{
 				stnrv1.DefaultRealm, *iceAuth.Credential), "auth handler ok")
 		},
 	},
+	// IPv6 and dual-stack
+	{
+		name:   "IPv6 public address",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		patch: func(c *stnrv1.StunnerConfig) {
+			c.Listeners[0].PublicAddr = "2001:db8::1"
+		},
+		params: "service=turn&namespace=testnamespace&gateway=testgateway&listener=udp",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{"turn:[2001:db8::1]:3478?transport=udp"},
+				*(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "IPv6 public address from request",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&namespace=testnamespace&gateway=testgateway&listener=udp&public-addr=2001:db8::2",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{"turn:[2001:db8::2]:3478?transport=udp"},
+				*(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "dual-stack public address from request",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&namespace=testnamespace&gateway=testgateway&listener=udp&public-addr=1.2.3.5,2001:db8::2",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:1.2.3.5:3478?transport=udp",
+				"turn:[2001:db8::2]:3478?transport=udp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:          "dual-stack public address from environment - IPv6 only",
+		config:        []*stnrv1.StunnerConfig{&staticAuthConfig},
+		envPublicAddr: "5.6.7.8,2001:db8::3",
+		params:        "service=turn&addressFamily=ipv6",
+		status:        200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:[2001:db8::3]:3478?transport=udp",
+				"turn:[2001:db8::3]:3478?transport=tcp",
+				"turns:[2001:db8::3]:3479?transport=tcp",
+				"turns:[2001:db8::3]:3479?transport=udp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "address family IPv4 - hostnames kept",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		patch: func(c *stnrv1.StunnerConfig) {
+			c.Listeners[0].PublicAddr = "2001:db8::1"
+			c.Listeners[1].PublicAddr = "turn.example.com"
+		},
+		params: "service=turn&addressFamily=ipv4",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:turn.example.com:3478?transport=tcp",
+				"turns:127.0.0.1:3479?transport=tcp",
+				"turns:127.0.0.1:3479?transport=udp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "address family - no matching listener",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&namespace=testnamespace&gateway=testgateway&listener=udp&addressFamily=ipv6",
+		status: 404,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	},
+	{
+		name:   "address family - invalid",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&addressFamily=ipv5",
+		status: 400,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	},
 }
 
 func TestICEAuth(t *testing.T) { testICE(t, iceAuthTestCases) }
 func TestICECDS(t *testing.T)  { testICECDS(t, iceAuthTestCases) }
 
+func TestICEErrorBody(t *testing.T) {
+	loggerFactory := logger.NewLoggerFactory(authTestLoglevel)
+	h, err := handler.NewHandler(nil, loggerFactory.NewLogger("auth-svc"))
+	assert.NoError(t, err, "create handler")
+	serv := server.ServerInterfaceWrapper{Handler: h}
+
+	get := func(params string) (int, string) {
+		req := httptest.NewRequest("GET", "http://example.com/ice?"+params, nil)
+		w := httptest.NewRecorder()
+		serv.GetIceAuth(w, req)
+		return w.Code, w.Body.String()
+	}
+
+	status, body := get("service=turn")
+	assert.Equal(t, http.StatusInternalServerError, status, "no config: HTTP status")
+	assert.Equal(t, "no STUNner configuration available\n", body, "no config: body")
+
+	h.SetConfig(staticAuthConfig.Admin.Name, &staticAuthConfig)
+	status, body = get("service=turn&namespace=dummy")
+	assert.Equal(t, http.StatusNotFound, status, "no listener: HTTP status")
+	assert.Equal(t, "could not generate ICE config: no valid listener found\n", body, "no listener: body")
+
+	status, body = get("service=turn&public-addr=,")
+	assert.Equal(t, http.StatusBadRequest, status, "invalid request: HTTP status")
+	assert.Equal(t, `could not generate ICE auth token: "invalid request: invalid \"public-addr\": \",\""`+"\n",
+		body, "invalid request: body")
+}
+
 // test with manually injected configs
-func testICE(t *testing.T, tests []iceAuthTestCase) {
-	// <setup>
+func testICE(t *testing.T, tests []iceAuthTestCase, opts ...handler.Option) {
 	lim := test.TimeOut(time.Second * 120)
 	defer lim.Stop()
 
 	report := test.CheckRoutines(t)
 	defer report()
 
+	runICE(t, tests, opts...)
+}
+
+// same as testICE but without checking for leaked goroutines, for tests that run auxiliary
+// servers
+func runICE(t *testing.T, tests []iceAuthTestCase, opts ...handler.Option) {
+	// <setup>
 	loggerFactory := logger.NewLoggerFactory(authTestLoglevel)
 	log := loggerFactory.NewLogger("auth-test")
 
 	// we don't Start() the handler so a nil channel should not be a problem
-	handler, err := handler.NewHandler(nil, loggerFactory.NewLogger("auth-svc"))
+	handler, err := handler.NewHandler(nil, loggerFactory.NewLogger("auth-svc"), opts...)
 	assert.NoError(t, err, "create handler")
 
 	serv := server.ServerInterfaceWrapper{Handler: handler}
@@ -0,0 +1,104 @@
+// Package admin implements the administrative HTTP API of the authentication service. The admin
+// API is served on a separate address so that it can be kept away from the clients requesting
+// credentials.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pion/logging"
+
+	"github.com/l7mp/stunner-auth-service/internal/metrics"
+)
+
+// Registrar is implemented by the components that expose admin endpoints.
+type Registrar interface {
+	// RegisterAdminRoutes registers the admin endpoints of the component.
+	RegisterAdminRoutes(r *mux.Router)
+}
+
+// Server is the admin HTTP API request router.
+type Server struct {
+	*mux.Router
+	log logging.LeveledLogger
+}
+
+// New creates a new admin request router with the admin endpoints of the given components. The
+// Prometheus metrics are served at /metrics.
+func New(log logging.LeveledLogger, components ...Registrar) *Server {
+	s := &Server{Router: mux.NewRouter(), log: log}
+	s.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
+	for _, c := range components {
+		c.RegisterAdminRoutes(s.Router)
+	}
+	s.Use(s.logRequest)
+	return s
+}
+
+// RequireToken requires the admin API requests, including the requests for the Prometheus
+// metrics, to present one of the given tokens in an "Authorization: Bearer <token>" header.
+// Requests without a valid token are rejected with status 401.
+func (s *Server) RequireToken(tokens ...string) {
+	s.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || !validToken(tokens, token) {
+				s.log.Warnf("Unauthorized admin API request: %s %s from %s", r.Method,
+					r.URL.Path, r.RemoteAddr)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+}
+
+func validToken(tokens []string, token string) bool {
+	valid := false
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			valid = true
+		}
+	}
+	return valid && token != ""
+}
+
+// LoadTokens reads the admin API tokens from a file, one token per line. Empty lines and lines
+// starting with "#" are ignored.
+func LoadTokens(file string) ([]string, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	tokens := []string{}
+	for _, l := range strings.Split(string(b), "\n") {
+		if l = strings.TrimSpace(l); l != "" && !strings.HasPrefix(l, "#") {
+			tokens = append(tokens, l)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("no tokens found")
+	}
+	return tokens, nil
+}
+
+func (s *Server) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.log.Infof("Admin API request: %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// WriteJSON writes a JSON response.
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
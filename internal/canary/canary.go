@@ -0,0 +1,209 @@
+// Package canary implements traffic splitting between Gateways: each user is assigned to one arm
+// of a traffic split and obtains TURN URIs only for the Gateway of that arm, unless the Gateway
+// has no usable listener for the request.
+package canary
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pion/logging"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+
+	"github.com/l7mp/stunner-auth-service/internal/metrics"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+)
+
+// Arm is a Gateway receiving a share of the users of a traffic split.
+type Arm struct {
+	// Gateway is the Gateway in the form "namespace/gateway".
+	Gateway string
+	// Percent is the share of the users assigned to the arm.
+	Percent float64
+}
+
+// Split is a traffic split between Gateways.
+type Split struct {
+	// Name identifies the split in the metrics.
+	Name string
+	// Arms lists the arms, with percentages summing to 100.
+	Arms []Arm
+}
+
+// ParseSplit parses a traffic split of the form "namespace/gateway-a: 95%, namespace/gateway-b:
+// 5%". The split is named after the Gateways of its arms.
+func ParseSplit(spec string) (Split, error) {
+	split := Split{}
+	names := []string{}
+	for _, a := range strings.Split(spec, ",") {
+		gw, pct, ok := strings.Cut(a, ":")
+		if !ok {
+			return Split{}, fmt.Errorf(`invalid traffic split arm %q: should be "namespace/gateway: percent%%"`,
+				strings.TrimSpace(a))
+		}
+		gw = strings.TrimSpace(gw)
+		p, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(pct), "%"), 64)
+		if err != nil {
+			return Split{}, fmt.Errorf("invalid percentage in traffic split arm %q: %w",
+				strings.TrimSpace(a), err)
+		}
+		split.Arms = append(split.Arms, Arm{Gateway: gw, Percent: p})
+		names = append(names, gw)
+	}
+	split.Name = strings.Join(names, ",")
+
+	return split, validate(split)
+}
+
+func validate(split Split) error {
+	if len(split.Arms) < 2 {
+		return fmt.Errorf("invalid traffic split %q: at least two arms required", split.Name)
+	}
+
+	total := 0.0
+	for _, a := range split.Arms {
+		tokens := strings.Split(a.Gateway, "/")
+		if len(tokens) != 2 || tokens[0] == "" || tokens[1] == "" {
+			return fmt.Errorf("invalid traffic split %q: Gateway %q should be in the form "+
+				`"namespace/gateway"`, split.Name, a.Gateway)
+		}
+		if a.Percent < 0 {
+			return fmt.Errorf("invalid traffic split %q: negative percentage for %s", split.Name,
+				a.Gateway)
+		}
+		total += a.Percent
+	}
+	if math.Abs(total-100) > 1e-6 {
+		return fmt.Errorf("invalid traffic split %q: percentages sum to %g instead of 100",
+			split.Name, total)
+	}
+
+	return nil
+}
+
+// Canary applies traffic splits. It implements the credentials.ListenerFilter interface to
+// exclude the listeners of the arms a user is not assigned to, and the handler.RequestObserver
+// interface to record the assignments in the metrics.
+type Canary struct {
+	splits []Split
+	// arms maps the Gateways to the index of their split
+	arms map[string]int
+	log  logging.LeveledLogger
+}
+
+// New creates a canary from the given traffic splits.
+func New(splits []Split, log logging.LeveledLogger) (*Canary, error) {
+	c := &Canary{arms: map[string]int{}, log: log}
+	for i, s := range splits {
+		if err := validate(s); err != nil {
+			return nil, err
+		}
+		for _, a := range s.Arms {
+			if _, ok := c.arms[a.Gateway]; ok {
+				return nil, fmt.Errorf("invalid traffic split %q: Gateway %s is already part "+
+					"of a traffic split", s.Name, a.Gateway)
+			}
+			c.arms[a.Gateway] = i
+		}
+		c.splits = append(c.splits, s)
+	}
+	return c, nil
+}
+
+// Assign returns the Gateway of the arm of a split the given user is assigned to. The assignment
+// depends only on the user and the split, so users stick to the same arm.
+func (c *Canary) Assign(split Split, user string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(split.Name))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(user))
+	bucket := float64(h.Sum64()%10000) / 100
+
+	for _, a := range split.Arms {
+		if bucket < a.Percent {
+			return a.Gateway
+		}
+		bucket -= a.Percent
+	}
+	return split.Arms[len(split.Arms)-1].Gateway
+}
+
+// user returns the user a request is assigned to an arm by: the username or, for anonymous
+// requests, the client IP or the API key, so that anonymous traffic is spread over the arms.
+func user(req *credentials.Request) string {
+	switch {
+	case req.Username != "":
+		return req.Username
+	case req.ClientIP != "":
+		return "ip:" + req.ClientIP
+	case req.Identity != nil && req.Identity.Key != "":
+		return "key:" + req.Identity.Key
+	}
+	return ""
+}
+
+// FilterListener excludes the listeners of the arms the user is not assigned to. The listeners
+// are excluded with a credentials.FallbackError, so that they are still used if the assigned
+// Gateway yields no TURN URIs for the request, e.g., because it is draining or unhealthy.
+func (c *Canary) FilterListener(req *credentials.Request, _ *stnrv1.StunnerConfig, l *stnrv1.ListenerConfig) error {
+	tokens := strings.Split(l.Name, "/")
+	if len(tokens) != 3 {
+		return nil
+	}
+	gw := tokens[0] + "/" + tokens[1]
+
+	i, ok := c.arms[gw]
+	if !ok {
+		return nil
+	}
+
+	split := c.splits[i]
+	if assigned := c.Assign(split, user(req)); assigned != gw {
+		return &credentials.FallbackError{Reason: fmt.Sprintf("traffic split %s: user assigned to %s",
+			split.Name, assigned), Preferred: assigned}
+	}
+
+	return nil
+}
+
+// ObserveRequest records the arm assignments of a request in the metrics, along with the result
+// of credential generation, for the splits with at least one arm matching the namespace and
+// gateway selectors of the request.
+func (c *Canary) ObserveRequest(req *credentials.Request, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+
+	for _, s := range c.splits {
+		if !matches(s, req) {
+			continue
+		}
+		arm := c.Assign(s, user(req))
+		metrics.CanaryAssignments.WithLabelValues(s.Name, arm, result).Inc()
+		c.log.Debugf("Request assigned to %s in traffic split %s: %s", arm, s.Name, result)
+	}
+}
+
+func matches(s Split, req *credentials.Request) bool {
+	nsSelector, err := credentials.NewNameSelector(req.Namespace)
+	if err != nil {
+		return false
+	}
+	gwSelector, err := credentials.NewNameSelector(req.Gateway)
+	if err != nil {
+		return false
+	}
+
+	for _, a := range s.Arms {
+		ns, gw, _ := strings.Cut(a.Gateway, "/")
+		if nsSelector.Matches(ns) && gwSelector.Matches(gw) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,10 +1,5 @@
 package config
 
-import "time"
-
-const (
-	// DefaultTimeout is the default TURN credential timeout. Default is one day, in seconds.
-	DefaultTimeout = 24 * time.Hour
-)
-
+// PublicAddr is the public address to use for all listeners, set from the STUNNER_PUBLIC_ADDR
+// environment variable.
 var PublicAddr string
@@ -0,0 +1,229 @@
+// Package enricher fills in the missing public addresses of the STUNner listeners from the
+// Kubernetes API: the LoadBalancer Service of the Gateway, or the addresses in the Gateway status.
+package enricher
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pion/logging"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+)
+
+// DefaultResyncPeriod is the default resync period of the informers.
+const DefaultResyncPeriod = 10 * time.Minute
+
+// GatewayResource is the Gateway API Gateway resource.
+var GatewayResource = schema.GroupVersionResource{
+	Group:    "gateway.networking.k8s.io",
+	Version:  "v1",
+	Resource: "gateways",
+}
+
+// Enricher implements the credentials.AddressOverrider interface with the public addresses of
+// the Gateways, for the listeners that have no public address. The Gateway of a listener is
+// taken from the listener name, in the form "namespace/gateway/listener". The public address
+// and port are looked up, in order, from:
+//   - the LoadBalancer Service of the Gateway, i.e., the Service in the Gateway namespace with
+//     the related Gateway label set to the Gateway name, or the Service named after the Gateway:
+//     the ingress address and the port named after the listener, or the first port with the
+//     protocol of the listener;
+//   - the status of the Gateway: the address and the port of the listener in the Gateway spec.
+//
+// Gateways with both IPv4 and IPv6 addresses yield a dual-stack public address, the first address
+// of each family separated by a comma.
+type Enricher struct {
+	factory    informers.SharedInformerFactory
+	services   corelisters.ServiceLister
+	dynFactory dynamicinformer.DynamicSharedInformerFactory
+	gateways   cache.GenericLister
+	synced     []cache.InformerSynced
+	log        logging.LeveledLogger
+}
+
+// New creates an enricher using the given Kubernetes client, and the given dynamic informer
+// factory for watching the Gateways. The informer factory may be shared with other components.
+// If the informer factory is nil, the Gateway status is not considered.
+func New(client kubernetes.Interface, dynFactory dynamicinformer.DynamicSharedInformerFactory, log logging.LeveledLogger) *Enricher {
+	factory := informers.NewSharedInformerFactory(client, DefaultResyncPeriod)
+	services := factory.Core().V1().Services()
+	e := &Enricher{
+		factory:    factory,
+		services:   services.Lister(),
+		dynFactory: dynFactory,
+		synced:     []cache.InformerSynced{services.Informer().HasSynced},
+		log:        log,
+	}
+
+	if dynFactory != nil {
+		gateways := dynFactory.ForResource(GatewayResource)
+		e.gateways = gateways.Lister()
+		e.synced = append(e.synced, gateways.Informer().HasSynced)
+	}
+
+	return e
+}
+
+// NewGatewayInformerFactory creates a dynamic informer factory for watching the Gateways, to be
+// shared between the components that watch the Gateways.
+func NewGatewayInformerFactory(dynClient dynamic.Interface) dynamicinformer.DynamicSharedInformerFactory {
+	return dynamicinformer.NewDynamicSharedInformerFactory(dynClient, DefaultResyncPeriod)
+}
+
+// Start starts watching the Services and the Gateways until the context is canceled, and waits
+// for the initial lists. Starting a shared informer factory again is a no-op.
+func (e *Enricher) Start(ctx context.Context) error {
+	e.factory.Start(ctx.Done())
+	if e.dynFactory != nil {
+		e.dynFactory.Start(ctx.Done())
+	}
+	if !cache.WaitForCacheSync(ctx.Done(), e.synced...) {
+		return fmt.Errorf("cannot sync Service and Gateway informers: %w", ctx.Err())
+	}
+	return nil
+}
+
+// OverrideAddress returns the public address and port of the Gateway of a listener, or an empty
+// override if the address is unknown.
+func (e *Enricher) OverrideAddress(l *stnrv1.ListenerConfig) credentials.AddressOverride {
+	tokens := strings.Split(l.Name, "/")
+	if len(tokens) != 3 {
+		return credentials.AddressOverride{}
+	}
+	namespace, gateway, listener := tokens[0], tokens[1], tokens[2]
+
+	if o, ok := e.fromService(namespace, gateway, listener, l.Protocol); ok {
+		return o
+	}
+	if o, ok := e.fromGateway(namespace, gateway, listener); ok {
+		return o
+	}
+	return credentials.AddressOverride{}
+}
+
+// fromService looks up the public address and port of a listener from the LoadBalancer Service
+// of the Gateway.
+func (e *Enricher) fromService(namespace, gateway, listener, proto string) (credentials.AddressOverride, bool) {
+	svc := e.service(namespace, gateway)
+	if svc == nil || svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return credentials.AddressOverride{}, false
+	}
+
+	addrs := []string{}
+	for _, i := range svc.Status.LoadBalancer.Ingress {
+		addrs = append(addrs, i.IP, i.Hostname)
+	}
+	o := credentials.AddressOverride{PublicAddr: credentials.DualStackAddr(addrs)}
+	if o.PublicAddr == "" {
+		return credentials.AddressOverride{}, false
+	}
+
+	o.PublicPort = servicePort(svc, listener, proto)
+	return o, true
+}
+
+// service returns the Service of a Gateway, if any.
+func (e *Enricher) service(namespace, gateway string) *corev1.Service {
+	selector := labels.SelectorFromSet(labels.Set{stnrv1.DefaultRelatedGatewayKey: gateway})
+	svcs, err := e.services.Services(namespace).List(selector)
+	if err != nil {
+		e.log.Errorf("Cannot list Services in namespace %s: %s", namespace, err.Error())
+		return nil
+	}
+	for _, svc := range svcs {
+		if ns, ok := svc.Labels[stnrv1.DefaultRelatedGatewayNamespace]; !ok || ns == namespace {
+			return svc
+		}
+	}
+
+	svc, err := e.services.Services(namespace).Get(gateway)
+	if err != nil {
+		return nil
+	}
+	return svc
+}
+
+// servicePort returns the Service port named after the listener, or the first port with the
+// transport protocol of the listener, or zero if there is no such port.
+func servicePort(svc *corev1.Service, listener, proto string) int {
+	for _, p := range svc.Spec.Ports {
+		if p.Name == listener {
+			return int(p.Port)
+		}
+	}
+
+	transport := corev1.ProtocolUDP
+	if p, err := stnrv1.NewListenerProtocol(proto); err == nil &&
+		(p == stnrv1.ListenerProtocolTURNTCP || p == stnrv1.ListenerProtocolTURNTLS) {
+		transport = corev1.ProtocolTCP
+	}
+	for _, p := range svc.Spec.Ports {
+		protocol := p.Protocol
+		if protocol == "" {
+			protocol = corev1.ProtocolTCP
+		}
+		if protocol == transport {
+			return int(p.Port)
+		}
+	}
+	return 0
+}
+
+// fromGateway looks up the public address and port of a listener from the Gateway status.
+func (e *Enricher) fromGateway(namespace, gateway, listener string) (credentials.AddressOverride, bool) {
+	if e.gateways == nil {
+		return credentials.AddressOverride{}, false
+	}
+	obj, err := e.gateways.ByNamespace(namespace).Get(gateway)
+	if err != nil {
+		return credentials.AddressOverride{}, false
+	}
+	gw, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return credentials.AddressOverride{}, false
+	}
+
+	addrs := []string{}
+	status, _, _ := unstructured.NestedSlice(gw.Object, "status", "addresses")
+	for _, a := range status {
+		if m, ok := a.(map[string]any); ok {
+			if v, ok := m["value"].(string); ok {
+				addrs = append(addrs, v)
+			}
+		}
+	}
+	o := credentials.AddressOverride{PublicAddr: credentials.DualStackAddr(addrs)}
+	if o.PublicAddr == "" {
+		return credentials.AddressOverride{}, false
+	}
+
+	listeners, _, _ := unstructured.NestedSlice(gw.Object, "spec", "listeners")
+	for _, l := range listeners {
+		m, ok := l.(map[string]any)
+		if !ok || m["name"] != listener {
+			continue
+		}
+		switch port := m["port"].(type) {
+		case int64:
+			o.PublicPort = int(port)
+		case float64:
+			o.PublicPort = int(port)
+		}
+	}
+	return o, true
+}
@@ -0,0 +1,87 @@
+// Package filewatch loads a file, e.g., a mounted ConfigMap, and reloads it whenever its content
+// changes.
+package filewatch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pion/logging"
+)
+
+// DefaultReloadInterval is the default interval for checking the file for changes.
+const DefaultReloadInterval = 5 * time.Second
+
+// LoadFunc parses the content of the file and puts it in effect. If it returns an error, the
+// previous content remains in effect.
+type LoadFunc func(content []byte) error
+
+// Watcher loads a file with a LoadFunc, and calls the LoadFunc again whenever the content of the
+// file changes.
+type Watcher struct {
+	file string
+	// kind describes the file in errors and logs, e.g., "policy file"
+	kind    string
+	load    LoadFunc
+	content []byte
+	loaded  bool
+	lock    sync.Mutex
+	log     logging.LeveledLogger
+}
+
+// New creates a watcher for the given file and loads the file.
+func New(file, kind string, load LoadFunc, log logging.LeveledLogger) (*Watcher, error) {
+	w := &Watcher{file: file, kind: kind, load: load, log: log}
+	if err := w.Reload(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Start reloads the file whenever its content changes, until the context is canceled. If the new
+// content is invalid, the last valid content remains in effect.
+func (w *Watcher) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := w.Reload(); err != nil {
+					w.log.Errorf("Could not reload %s %s, keeping the previous content: %s",
+						w.kind, w.file, err.Error())
+				}
+			}
+		}
+	}()
+}
+
+// Reload reloads the file if its content has changed.
+func (w *Watcher) Reload() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	b, err := os.ReadFile(w.file)
+	if err != nil {
+		return fmt.Errorf("cannot read %s: %w", w.kind, err)
+	}
+	if w.loaded && string(b) == string(w.content) {
+		return nil
+	}
+
+	if err := w.load(b); err != nil {
+		return err
+	}
+	w.content, w.loaded = b, true
+
+	return nil
+}
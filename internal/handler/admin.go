@@ -0,0 +1,162 @@
+// package handler implements the actual functions to generate TURN credentials
+
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+
+	"github.com/l7mp/stunner-auth-service/internal/admin"
+)
+
+// redacted replaces the secrets in the STUNner configs returned on the admin API.
+const redacted = "<redacted>"
+
+// WithConfigOverride allows setting and deleting manual override configs on the admin API.
+func WithConfigOverride() Option {
+	return func(h *Handler) { h.configOverride = true }
+}
+
+// GatewayListener is a listener of a Gateway, as returned on the admin API.
+type GatewayListener struct {
+	// Config is the name of the STUNner config the listener belongs to.
+	Config string `json:"config"`
+	// Listener is the listener, with the private key redacted.
+	Listener stnrv1.ListenerConfig `json:"listener"`
+}
+
+// redact returns a copy of a STUNner config with the auth credentials except the username, the
+// private keys of the listeners and the license key removed.
+func redact(c *stnrv1.StunnerConfig) *stnrv1.StunnerConfig {
+	c = c.DeepCopy()
+	for k, v := range c.Auth.Credentials {
+		if k != "username" && v != "" {
+			c.Auth.Credentials[k] = redacted
+		}
+	}
+	for i := range c.Listeners {
+		redactListener(&c.Listeners[i])
+	}
+	if l := c.Admin.LicenseConfig; l != nil {
+		if l.Key != "" {
+			l.Key = redacted
+		}
+		if l.HMAC != "" {
+			l.HMAC = redacted
+		}
+	}
+	return c
+}
+
+func redactListener(l *stnrv1.ListenerConfig) {
+	if l.Key != "" {
+		l.Key = redacted
+	}
+}
+
+// GatewayListeners returns the listeners of a Gateway in all STUNner configs.
+func (h *Handler) GatewayListeners(namespace, gateway string) []GatewayListener {
+	ret := []GatewayListener{}
+	prefix := namespace + "/" + gateway + "/"
+	for _, c := range h.Configs() {
+		for _, l := range c.Listeners {
+			if !strings.HasPrefix(l.Name, prefix) || strings.Contains(l.Name[len(prefix):], "/") {
+				continue
+			}
+			redactListener(&l)
+			ret = append(ret, GatewayListener{Config: c.Admin.Name, Listener: l})
+		}
+	}
+	return ret
+}
+
+// RegisterAdminRoutes registers the admin endpoints of the config store:
+//   - GET /configs lists the STUNner configs, with the secrets redacted,
+//   - GET /configs/{name} returns a STUNner config, with the secrets redacted,
+//   - PUT /configs/{name} sets a manual override config, if enabled,
+//   - DELETE /configs/{name} deletes a manual override config, if enabled,
+//   - GET /status/configs lists the update time and the generation of the STUNner configs,
+//   - GET /gateways/{namespace}/{gateway}/listeners lists the listeners of a Gateway.
+func (h *Handler) RegisterAdminRoutes(r *mux.Router) {
+	r.HandleFunc("/configs", func(w http.ResponseWriter, _ *http.Request) {
+		configs := h.Configs()
+		for i, c := range configs {
+			configs[i] = redact(c)
+		}
+		admin.WriteJSON(w, http.StatusOK, configs)
+	}).Methods(http.MethodGet)
+
+	r.HandleFunc("/configs/{name:.+}", func(w http.ResponseWriter, r *http.Request) {
+		c := h.GetConfig(mux.Vars(r)["name"])
+		if c == nil {
+			http.Error(w, "no such config", http.StatusNotFound)
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, redact(c))
+	}).Methods(http.MethodGet)
+
+	r.HandleFunc("/configs/{name:.+}", func(w http.ResponseWriter, r *http.Request) {
+		if !h.configOverride {
+			http.Error(w, "config overrides are disabled", http.StatusForbidden)
+			return
+		}
+
+		name := mux.Vars(r)["name"]
+		c := &stnrv1.StunnerConfig{}
+		if err := json.NewDecoder(r.Body).Decode(c); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request body: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		if c.Admin.Name == "" {
+			c.Admin.Name = name
+		}
+		if c.Admin.Name != name {
+			http.Error(w, fmt.Sprintf("config name %q does not match %q", c.Admin.Name, name),
+				http.StatusBadRequest)
+			return
+		}
+		if err := c.Validate(); err != nil {
+			http.Error(w, fmt.Sprintf("invalid config: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		h.SetOverride(name, c)
+		h.log.Infof("Manual override set for config %q: %s", name, c.String())
+		admin.WriteJSON(w, http.StatusOK, redact(c))
+	}).Methods(http.MethodPut)
+
+	r.HandleFunc("/configs/{name:.+}", func(w http.ResponseWriter, r *http.Request) {
+		if !h.configOverride {
+			http.Error(w, "config overrides are disabled", http.StatusForbidden)
+			return
+		}
+
+		name := mux.Vars(r)["name"]
+		if !h.DeleteOverride(name) {
+			http.Error(w, "no manual override for config", http.StatusNotFound)
+			return
+		}
+		h.log.Infof("Manual override deleted for config %q", name)
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods(http.MethodDelete)
+
+	r.HandleFunc("/status/configs", func(w http.ResponseWriter, _ *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, h.ConfigStatus())
+	}).Methods(http.MethodGet)
+
+	r.HandleFunc("/gateways/{namespace}/{gateway}/listeners", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		ls := h.GatewayListeners(vars["namespace"], vars["gateway"])
+		if len(ls) == 0 {
+			http.Error(w, "no listeners for gateway", http.StatusNotFound)
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, ls)
+	}).Methods(http.MethodGet)
+}
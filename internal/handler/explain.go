@@ -0,0 +1,42 @@
+// package handler implements the actual functions to generate TURN credentials
+
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+)
+
+// WithExplain enables the explain mode: requests with the "explain" parameter set return an
+// explanation of the decisions made while generating the response instead of credentials. The
+// explanation reveals the STUNner configs, so explain mode is disabled by default.
+func WithExplain() Option {
+	return func(h *Handler) { h.explainEnabled = true }
+}
+
+// explainFunc explains a request, see credentials.ExplainIceConfig.
+type explainFunc func(configs []*stnrv1.StunnerConfig, req credentials.Request, opts credentials.Options) *credentials.Explanation
+
+// checkExplain checks whether explain mode is enabled.
+func (h *Handler) checkExplain() error {
+	if !h.explainEnabled {
+		return fmt.Errorf("%w: explain mode is disabled", credentials.ErrForbidden)
+	}
+	return nil
+}
+
+// explain writes the explanation of a request. No credentials are generated.
+func (h *Handler) explain(w http.ResponseWriter, op string, explain explainFunc, req credentials.Request) {
+	e := explain(h.Configs(), req, h.Options())
+	h.logDiagnostics(e.Diagnostics)
+
+	h.log.Infof("%s: explained request %s: URIs: %v, error: %q", op, req.String(), e.URIs, e.Error)
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_ = json.NewEncoder(w).Encode(e)
+}
@@ -5,6 +5,8 @@ package handler
 import (
 	"context"
 	"fmt"
+	"net/netip"
+	"slices"
 	"strings"
 	"sync"
 
@@ -12,26 +14,53 @@ import (
 
 	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
 	cdsclient "github.com/l7mp/stunner/pkg/config/client"
-)
 
-type hErr struct {
-	error
-	status int
-}
+	"github.com/l7mp/stunner-auth-service/internal/config"
+	"github.com/l7mp/stunner-auth-service/internal/webhook"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+)
 
 // Handler Implements server.ServerInterface
 type Handler struct {
-	store *sync.Map
-	conf  chan *stnrv1.StunnerConfig
-	log   logging.LeveledLogger
+	store            map[string]*configEntry
+	lock             sync.RWMutex
+	conf             chan *stnrv1.StunnerConfig
+	authorizers      []RequestAuthorizer
+	observers        []RequestObserver
+	listenerFilters  []ListenerFilter
+	listenerScorers  []ListenerScorer
+	responseMutators []ResponseMutator
+	webhook          *webhook.Webhook
+	selector         *credentials.Selector
+	trustedProxies   []netip.Prefix
+	addressOverrider credentials.AddressOverrider
+	addressEnricher  credentials.AddressOverrider
+	unroutable       credentials.UnroutablePolicy
+	fallbackAddr     string
+	publicAddrPolicy *PublicAddrPolicy
+	nodeAddresser    credentials.NodeAddresser
+	nodeSelector     *credentials.Selector
+	certHostname     bool
+	certs            *credentials.CertificateCache
+	taggers          []credentials.Tagger
+	explainEnabled   bool
+	configOverride   bool
+	log              logging.LeveledLogger
 }
 
-func NewHandler(conf chan *stnrv1.StunnerConfig, log logging.LeveledLogger) (*Handler, error) {
-	return &Handler{
-		store: &sync.Map{},
+func NewHandler(conf chan *stnrv1.StunnerConfig, log logging.LeveledLogger, opts ...Option) (*Handler, error) {
+	h := &Handler{
+		store: map[string]*configEntry{},
 		conf:  conf,
+		certs: credentials.NewCertificateCache(),
 		log:   log,
-	}, nil
+	}
+
+	for _, o := range opts {
+		o(h)
+	}
+
+	return h, nil
 }
 
 func (h *Handler) Start(ctx context.Context) {
@@ -48,12 +77,13 @@ func (h *Handler) Start(ctx context.Context) {
 
 				if cdsclient.IsConfigDeleted(c) {
 					h.log.Debugf("Config deleted for gateway %q", c.Admin.Name)
-					h.store.Delete(c.Admin.Name)
+					h.DeleteConfig(c.Admin.Name)
+					continue
 				}
 
 				h.log.Debugf("New config available for gateway %q: %s",
 					c.Admin.Name, c.String())
-				h.store.Store(c.Admin.Name, c)
+				h.SetConfig(c.Admin.Name, c)
 			}
 		}
 	}()
@@ -61,44 +91,89 @@ func (h *Handler) Start(ctx context.Context) {
 
 // config API
 func (h *Handler) SetConfig(id string, conf *stnrv1.StunnerConfig) {
-	h.store.Store(id, conf)
+	h.update(id, func(e *configEntry) bool {
+		if e.config != nil && e.config.DeepEqual(conf) {
+			return false
+		}
+		e.config = conf
+		return true
+	})
+}
+
+// DeleteConfig removes a STUNner config from the store. The manual override for the config, if
+// any, is kept.
+func (h *Handler) DeleteConfig(id string) {
+	h.update(id, func(e *configEntry) bool {
+		ok := e.config != nil
+		e.config = nil
+		return ok
+	})
 }
 
 func (h *Handler) GetConfig(id string) *stnrv1.StunnerConfig {
-	value, ok := h.store.Load(id)
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	e, ok := h.store[id]
 	if !ok {
 		return nil
 	}
-	c, ok := value.(*stnrv1.StunnerConfig)
-	if !ok {
-		return nil
+	return e.effective()
+}
+
+// Configs returns the STUNner configs in the store, sorted by name. Manual overrides shadow the
+// STUNner configs with the same name.
+func (h *Handler) Configs() []*stnrv1.StunnerConfig {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	ret := make([]*stnrv1.StunnerConfig, 0, len(h.store))
+	for _, e := range h.store {
+		ret = append(ret, e.effective())
+	}
+	slices.SortFunc(ret, func(a, b *stnrv1.StunnerConfig) int {
+		return strings.Compare(a.Admin.Name, b.Admin.Name)
+	})
+	return ret
+}
+
+// Options returns the credential generation options.
+func (h *Handler) Options() credentials.Options {
+	return credentials.Options{
+		PublicAddr:       config.PublicAddr,
+		AddressOverrider: h.addressOverrider,
+		AddressEnricher:  h.addressEnricher,
+		ListenerFilters:  h.listenerFilters,
+		ResponseMutators: h.responseMutators,
+		ListenerScorers:  h.listenerScorers,
+		Selector:         h.selector,
+		Unroutable:       h.unroutable,
+		FallbackAddr:     h.fallbackAddr,
+		NodeAddresser:    h.nodeAddresser,
+		NodeSelector:     h.nodeSelector,
+		CertHostname:     h.certHostname,
+		Certificates:     h.certs,
+		Taggers:          h.taggers,
 	}
-	return c
 }
 
 func (h *Handler) NumConfig() int {
-	num := 0
-	h.store.Range(func(key, value any) bool { num++; return true })
-	return num
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return len(h.store)
 }
 
 func (h *Handler) DumpConfig() string {
-	ret := []string{}
-	num := 0
-
-	h.store.Range(func(key, value any) bool {
-		c, ok := value.(*stnrv1.StunnerConfig)
-		if !ok {
-			return false
-		}
-		ret = append(ret, c.String())
-		num++
-		return true
-	})
+	configs := h.Configs()
+	ret := make([]string, len(configs))
+	for i, c := range configs {
+		ret[i] = c.String()
+	}
 
-	return fmt.Sprintf("store (%d objects): %s", num, strings.Join(ret, ", "))
+	return fmt.Sprintf("store (%d objects): %s", len(configs), strings.Join(ret, ", "))
 }
 
 func (h *Handler) Reset() {
-	h.store = &sync.Map{}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.store = map[string]*configEntry{}
+	h.certs.Retain(nil)
 }
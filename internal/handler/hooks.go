@@ -0,0 +1,164 @@
+// package handler implements the actual functions to generate TURN credentials
+
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+// RequestAuthorizer authorizes credential requests. Returning a non-nil error rejects the
+// request; errors wrapping credentials.ErrInvalidRequest are reported with status 400, all
+// other errors with status 403. The authorizer may also rewrite the request parameters, e.g., to
+// force a namespace. Requests to the TURN REST API endpoint are converted to ICE config API
+// requests before authorization.
+type RequestAuthorizer interface {
+	Authorize(r *http.Request, params *types.GetIceAuthParams) error
+}
+
+// RequestAuthorizerFunc is an adapter to allow the use of ordinary functions as request
+// authorizers.
+type RequestAuthorizerFunc func(r *http.Request, params *types.GetIceAuthParams) error
+
+// Authorize calls f(r, params).
+func (f RequestAuthorizerFunc) Authorize(r *http.Request, params *types.GetIceAuthParams) error {
+	return f(r, params)
+}
+
+// RequestObserver is notified of the outcome of credential requests, after credential generation
+// succeeded or failed. The observer receives the final request, after the request authorizers and
+// the webhook; requests rejected before credential generation and explain requests are not
+// observed.
+type RequestObserver interface {
+	ObserveRequest(req *credentials.Request, err error)
+}
+
+// ListenerFilter decides whether TURN URIs should be generated for a listener.
+type ListenerFilter = credentials.ListenerFilter
+
+// ListenerScorer ranks the listeners that pass the listener filters.
+type ListenerScorer = credentials.ListenerScorer
+
+// ResponseMutator edits the final ICE config before it is returned.
+type ResponseMutator = credentials.ResponseMutator
+
+// Option is a Handler option.
+type Option func(h *Handler)
+
+// WithRequestAuthorizer registers a request authorizer. Authorizers are called in the order of
+// registration.
+func WithRequestAuthorizer(a RequestAuthorizer) Option {
+	return func(h *Handler) { h.authorizers = append(h.authorizers, a) }
+}
+
+// WithRequestObserver registers a request observer. Observers are called in the order of
+// registration.
+func WithRequestObserver(o RequestObserver) Option {
+	return func(h *Handler) { h.observers = append(h.observers, o) }
+}
+
+// WithListenerFilter registers a listener filter. Filters are called in the order of
+// registration.
+func WithListenerFilter(f ListenerFilter) Option {
+	return func(h *Handler) { h.listenerFilters = append(h.listenerFilters, f) }
+}
+
+// WithListenerScorer registers a listener scorer. The scores of all scorers are summed.
+func WithListenerScorer(s ListenerScorer) Option {
+	return func(h *Handler) { h.listenerScorers = append(h.listenerScorers, s) }
+}
+
+// WithResponseMutator registers a response mutator. Mutators are called in the order of
+// registration.
+func WithResponseMutator(m ResponseMutator) Option {
+	return func(h *Handler) { h.responseMutators = append(h.responseMutators, m) }
+}
+
+// WithSelector sets the selector used to choose the TURN server for TURN REST API requests when
+// multiple STUNner configs match the request.
+func WithSelector(s *credentials.Selector) Option {
+	return func(h *Handler) { h.selector = s }
+}
+
+// WithAddressOverrider sets the address overrider that overrides the public address and port of
+// individual listeners.
+func WithAddressOverrider(o credentials.AddressOverrider) Option {
+	return func(h *Handler) { h.addressOverrider = o }
+}
+
+// WithAddressEnricher sets the address enricher that fills in the public address and port of
+// the listeners that have no public address from any other source.
+func WithAddressEnricher(e credentials.AddressOverrider) Option {
+	return func(h *Handler) { h.addressEnricher = e }
+}
+
+// WithTagger registers a tagger that returns the tags of the listeners, matched against the label
+// selector in the "selector" request parameter. Can be repeated; later taggers override the same
+// tags of the earlier ones.
+func WithTagger(t credentials.Tagger) Option {
+	return func(h *Handler) { h.taggers = append(h.taggers, t) }
+}
+
+// WithCertHostname sets the public address of the TLS and DTLS listeners with no hostname in the
+// address overrides to the first DNS name in the SAN of the listener certificate.
+func WithCertHostname() Option {
+	return func(h *Handler) { h.certHostname = true }
+}
+
+// WithUnroutablePolicy sets the handling of the TURN URIs with an unroutable address, and the
+// fallback address substituted by the fallback action.
+func WithUnroutablePolicy(p credentials.UnroutablePolicy, fallbackAddr string) Option {
+	return func(h *Handler) {
+		h.unroutable = p
+		h.fallbackAddr = fallbackAddr
+	}
+}
+
+// WithNodeAddresser sets the node addresser that resolves the node address placeholder of the
+// listeners with relay address discovery to the addresses of the Kubernetes nodes. If the
+// selector is nil a TURN URI is generated for each node, otherwise the selector chooses a single
+// node.
+func WithNodeAddresser(a credentials.NodeAddresser, s *credentials.Selector) Option {
+	return func(h *Handler) {
+		h.nodeAddresser = a
+		h.nodeSelector = s
+	}
+}
+
+// ForceNamespace is a built-in request authorizer that restricts all requests to the given
+// namespace. Requests that do not specify a namespace are rewritten to the given namespace,
+// requests for another namespace are rejected.
+func ForceNamespace(namespace string) RequestAuthorizer {
+	return RequestAuthorizerFunc(func(_ *http.Request, params *types.GetIceAuthParams) error {
+		if params.Namespace != nil && *params.Namespace != namespace {
+			return fmt.Errorf("access to namespace %q denied", *params.Namespace)
+		}
+		ns := namespace
+		params.Namespace = &ns
+		return nil
+	})
+}
+
+// authorize calls the registered request authorizers in order.
+func (h *Handler) authorize(r *http.Request, params *types.GetIceAuthParams) error {
+	for _, a := range h.authorizers {
+		if err := a.Authorize(r, params); err != nil {
+			if errors.Is(err, credentials.ErrInvalidRequest) || errors.Is(err, credentials.ErrForbidden) {
+				return err
+			}
+			return fmt.Errorf("%w: %s", credentials.ErrForbidden, err.Error())
+		}
+	}
+	return nil
+}
+
+// observe notifies the registered request observers of the outcome of a request.
+func (h *Handler) observe(req *credentials.Request, err error) {
+	for _, o := range h.observers {
+		o.ObserveRequest(req, err)
+	}
+}
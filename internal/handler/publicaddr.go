@@ -0,0 +1,124 @@
+// package handler implements the actual functions to generate TURN credentials
+
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/pion/logging"
+
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+// PublicAddrPolicy restricts the public address override in the "public-addr" request parameter.
+// An override is accepted if the caller is authorized for it, i.e., the API key in the "key"
+// request parameter is listed, or if the public address matches the allowlist, i.e., it is an IP
+// address in one of the CIDRs, or a hostname equal to, or a subdomain of, one of the domains. All
+// other overrides are rejected.
+type PublicAddrPolicy struct {
+	// Keys is the list of API keys authorized to override the public address.
+	Keys []string
+	// CIDRs is the list of network prefixes the public address may be overridden to.
+	CIDRs []netip.Prefix
+	// Domains is the list of domains the public address may be overridden to.
+	Domains []string
+	// Audit is the logger to record the accepted and the rejected overrides in. Default is the
+	// logger of the handler.
+	Audit logging.LeveledLogger
+}
+
+// WithPublicAddrPolicy restricts the public address override in the "public-addr" request
+// parameter. Without a policy, any valid IP address or hostname is accepted.
+func WithPublicAddrPolicy(p PublicAddrPolicy) Option {
+	return func(h *Handler) { h.publicAddrPolicy = &p }
+}
+
+// allowed checks whether a public address override is accepted, and returns the reason.
+func (p *PublicAddrPolicy) allowed(addr string, id *credentials.Identity) (bool, string) {
+	if id.Key != "" && slices.Contains(p.Keys, id.Key) {
+		return true, "authorized API key"
+	}
+
+	if ip, err := netip.ParseAddr(addr); err == nil {
+		ip = ip.Unmap()
+		for _, c := range p.CIDRs {
+			if c.Contains(ip) {
+				return true, fmt.Sprintf("address in allowed CIDR %s", c)
+			}
+		}
+		return false, "caller not authorized and address not in the allowed CIDRs"
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(addr, "."))
+	for _, d := range p.Domains {
+		d = strings.ToLower(strings.Trim(d, "."))
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true, fmt.Sprintf("hostname in allowed domain %s", d)
+		}
+	}
+	return false, "caller not authorized and hostname not in the allowed domains"
+}
+
+// checkPublicAddr validates the public address override in the request parameters and enforces
+// the public address policy. Rejected overrides are recorded in the audit log, and so are the
+// accepted ones if a policy is set.
+func (h *Handler) checkPublicAddr(r *http.Request, params types.GetIceAuthParams) error {
+	if params.PublicAddr == nil || *params.PublicAddr == "" {
+		return nil
+	}
+	addr := *params.PublicAddr
+	id := identityFromRequest(r, params)
+
+	audit := h.log
+	if h.publicAddrPolicy != nil && h.publicAddrPolicy.Audit != nil {
+		audit = h.publicAddrPolicy.Audit
+	}
+	record := func(status int, reason string) {
+		// never log the API key itself
+		msg := fmt.Sprintf("public address override: status=%d, public-addr=%q, reason=%q, "+
+			"unverified-user=%q, key=%t, remote-addr=%s", status, addr, reason, id.UnverifiedUser, id.Key != "",
+			id.RemoteAddr)
+		if status == http.StatusOK {
+			audit.Info(msg)
+		} else {
+			audit.Warn(msg)
+		}
+	}
+
+	// dual-stack public addresses are checked address by address
+	addrs := credentials.SplitPublicAddr(addr)
+	if len(addrs) == 0 {
+		record(http.StatusBadRequest, "empty address list")
+		return fmt.Errorf(`%w: invalid "public-addr": %q`, credentials.ErrInvalidRequest, addr)
+	}
+	for _, a := range addrs {
+		if err := credentials.ValidatePublicAddr(a); err != nil {
+			record(http.StatusBadRequest, err.Error())
+			return fmt.Errorf(`%w: invalid "public-addr": %s`, credentials.ErrInvalidRequest, err.Error())
+		}
+	}
+
+	if h.publicAddrPolicy == nil {
+		return nil
+	}
+
+	reasons := []string{}
+	for _, a := range addrs {
+		ok, reason := h.publicAddrPolicy.allowed(a, id)
+		if !ok {
+			record(http.StatusForbidden, reason)
+			return fmt.Errorf(`%w: public address override %q not allowed`, credentials.ErrForbidden, a)
+		}
+		if !slices.Contains(reasons, reason) {
+			reasons = append(reasons, reason)
+		}
+	}
+	record(http.StatusOK, strings.Join(reasons, "; "))
+
+	return nil
+}
@@ -0,0 +1,120 @@
+// package handler implements the actual functions to generate TURN credentials
+
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/l7mp/stunner-auth-service/internal/webhook"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+// WithWebhook registers an external authorization webhook. The webhook is called after the
+// request authorizers.
+func WithWebhook(w *webhook.Webhook) Option {
+	return func(h *Handler) { h.webhook = w }
+}
+
+// request authorizes an HTTP request and converts the request parameters into a typed request.
+func (h *Handler) request(r *http.Request, params types.GetIceAuthParams) (credentials.Request, error) {
+	if err := h.authorize(r, &params); err != nil {
+		return credentials.Request{}, err
+	}
+
+	if err := h.checkPublicAddr(r, params); err != nil {
+		return credentials.Request{}, err
+	}
+
+	req, err := credentials.NewRequestFromIceParams(params)
+	if err != nil {
+		return credentials.Request{}, err
+	}
+	req.Identity = identityFromRequest(r, params)
+	if req.ClientIP == "" {
+		req.ClientIP = h.clientIP(r)
+	}
+
+	if h.webhook != nil {
+		candidates := credentials.CandidateGateways(h.Configs(), req)
+		if err := h.webhook.Authorize(r.Context(), &req, candidates); err != nil {
+			if errors.Is(err, webhook.ErrUnavailable) {
+				return credentials.Request{}, fmt.Errorf("%w: %s", credentials.ErrUnavailable,
+					err.Error())
+			}
+			return credentials.Request{}, fmt.Errorf("%w: %s", credentials.ErrForbidden, err.Error())
+		}
+	}
+
+	return req, nil
+}
+
+// WithTrustedProxies sets the network prefixes of the proxies trusted to set the X-Forwarded-For
+// header. The end-client IP is taken from the last address in the header that is not a trusted
+// proxy, unless given in the request parameters.
+func WithTrustedProxies(prefixes ...netip.Prefix) Option {
+	return func(h *Handler) { h.trustedProxies = append(h.trustedProxies, prefixes...) }
+}
+
+// clientIP obtains the end-client IP from an HTTP request.
+func (h *Handler) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+
+	if !h.trustedProxy(addr) {
+		return addr.String()
+	}
+
+	hops := []string{}
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		a, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = a.Unmap()
+		if !h.trustedProxy(addr) {
+			break
+		}
+	}
+
+	return addr.String()
+}
+
+func (h *Handler) trustedProxy(addr netip.Addr) bool {
+	for _, p := range h.trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// identityFromRequest obtains the caller identity from an HTTP request.
+func identityFromRequest(r *http.Request, params types.GetIceAuthParams) *credentials.Identity {
+	id := &credentials.Identity{RemoteAddr: r.RemoteAddr}
+
+	if params.Key != nil {
+		id.Key = *params.Key
+	}
+
+	if user, _, ok := r.BasicAuth(); ok {
+		id.UnverifiedUser = user
+	}
+
+	return id
+}
@@ -0,0 +1,139 @@
+// package handler implements the actual functions to generate TURN credentials
+
+package handler
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+)
+
+// ConfigSource is the source of a STUNner config in the store.
+type ConfigSource string
+
+const (
+	// ConfigSourceCDS is a STUNner config received from the CDS server, or set with SetConfig.
+	ConfigSourceCDS ConfigSource = "cds"
+	// ConfigSourceOverride is a manual override config set on the admin API.
+	ConfigSourceOverride ConfigSource = "override"
+)
+
+// ConfigStatus is the status of a STUNner config in the store.
+type ConfigStatus struct {
+	// Name is the name of the STUNner config.
+	Name string `json:"name"`
+	// Source is the source of the STUNner config used for generating credentials.
+	Source ConfigSource `json:"source"`
+	// Shadowed is true if a manual override shadows the STUNner config from the CDS server.
+	Shadowed bool `json:"shadowed,omitempty"`
+	// Generation is incremented on each update of the STUNner config or its manual override,
+	// starting from 1. Generations restart when the STUNner config is removed from the store.
+	Generation int64 `json:"generation"`
+	// Updated is the time of the last update.
+	Updated time.Time `json:"updated"`
+}
+
+// configEntry is a STUNner config in the store.
+type configEntry struct {
+	// config is the STUNner config from the CDS server.
+	config *stnrv1.StunnerConfig
+	// override is the manual override config, which shadows config.
+	override   *stnrv1.StunnerConfig
+	generation int64
+	updated    time.Time
+}
+
+// effective returns the STUNner config used for generating credentials.
+func (e *configEntry) effective() *stnrv1.StunnerConfig {
+	if e.override != nil {
+		return e.override
+	}
+	return e.config
+}
+
+// update updates the store entry of a STUNner config. The update function returns false if it
+// did not change the entry. Entries with neither a STUNner config nor a manual override are
+// removed from the store.
+func (h *Handler) update(id string, update func(e *configEntry) bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	e, ok := h.store[id]
+	if !ok {
+		e = &configEntry{}
+	}
+	if !update(e) {
+		return
+	}
+
+	// drop the certificates of the replaced STUNner configs from the cache
+	defer h.retainCertificates()
+
+	if e.config == nil && e.override == nil {
+		delete(h.store, id)
+		return
+	}
+	e.generation++
+	e.updated = time.Now()
+	h.store[id] = e
+}
+
+// retainCertificates drops the certificates no longer used by the STUNner configs in the store
+// from the certificate cache, must be called with the lock held.
+func (h *Handler) retainCertificates() {
+	configs := make([]*stnrv1.StunnerConfig, 0, 2*len(h.store))
+	for _, e := range h.store {
+		if e.config != nil {
+			configs = append(configs, e.config)
+		}
+		if e.override != nil {
+			configs = append(configs, e.override)
+		}
+	}
+	h.certs.Retain(configs)
+}
+
+// SetOverride sets a manual override for a STUNner config, which shadows the STUNner config with
+// the same name from the CDS server until the override is deleted.
+func (h *Handler) SetOverride(id string, conf *stnrv1.StunnerConfig) {
+	h.update(id, func(e *configEntry) bool {
+		e.override = conf
+		return true
+	})
+}
+
+// DeleteOverride deletes the manual override for a STUNner config. Returns false if there was no
+// override for the config.
+func (h *Handler) DeleteOverride(id string) bool {
+	ok := false
+	h.update(id, func(e *configEntry) bool {
+		ok = e.override != nil
+		e.override = nil
+		return ok
+	})
+	return ok
+}
+
+// ConfigStatus returns the status of the STUNner configs in the store, sorted by name.
+func (h *Handler) ConfigStatus() []ConfigStatus {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	ret := make([]ConfigStatus, 0, len(h.store))
+	for id, e := range h.store {
+		s := ConfigStatus{
+			Name:       id,
+			Source:     ConfigSourceCDS,
+			Generation: e.generation,
+			Updated:    e.updated,
+		}
+		if e.override != nil {
+			s.Source = ConfigSourceOverride
+			s.Shadowed = e.config != nil
+		}
+		ret = append(ret, s)
+	}
+	slices.SortFunc(ret, func(a, b ConfigStatus) int { return strings.Compare(a.Name, b.Name) })
+	return ret
+}
@@ -0,0 +1,315 @@
+// Package health implements active health probing of the STUNner listeners: a background prober
+// periodically sends STUN Binding requests to the public address of each listener, and the
+// listeners that stop responding are excluded from, or moved to the end of, the TURN URI lists.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pion/logging"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+
+	"github.com/l7mp/stunner-auth-service/internal/admin"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+)
+
+// Mode specifies how unhealthy listeners are treated.
+type Mode string
+
+const (
+	// ModeExclude excludes unhealthy listeners.
+	ModeExclude Mode = "exclude"
+	// ModeDemote moves the TURN URIs of unhealthy listeners to the end of the list.
+	ModeDemote Mode = "demote"
+)
+
+const (
+	// DefaultInterval is the default time between two probes of a listener.
+	DefaultInterval = 30 * time.Second
+	// DefaultTimeout is the default time to wait for a Binding response.
+	DefaultTimeout = 2 * time.Second
+	// DefaultThreshold is the default number of consecutive failed probes after which a
+	// listener is considered unhealthy.
+	DefaultThreshold = 3
+	// UnhealthyPenalty is subtracted from the score of unhealthy listeners in demote mode.
+	UnhealthyPenalty = 1000
+)
+
+// Config is the configuration of the prober.
+type Config struct {
+	// Mode specifies how unhealthy listeners are treated. Default is ModeExclude.
+	Mode Mode
+	// Interval is the time between two probes of a listener. Default is DefaultInterval.
+	Interval time.Duration
+	// Timeout is the time to wait for a Binding response. Default is DefaultTimeout.
+	Timeout time.Duration
+	// Threshold is the number of consecutive failed probes after which a listener is
+	// considered unhealthy. Default is DefaultThreshold.
+	Threshold int
+}
+
+// Status is the health status of a listener at a probed address.
+type Status struct {
+	// Listener is the name of the listener in the form "namespace/gateway/listener".
+	Listener string `json:"listener"`
+	// Config is the name of the STUNner config of the listener.
+	Config string `json:"config"`
+	// Protocol is the listener protocol.
+	Protocol string `json:"protocol"`
+	// Address is the probed address in the form "host:port".
+	Address string `json:"address"`
+	// Healthy reports whether the listener is considered healthy.
+	Healthy bool `json:"healthy"`
+	// Failures is the number of consecutive failed probes.
+	Failures int `json:"failures"`
+	// LastProbe is the time of the last probe.
+	LastProbe time.Time `json:"lastProbe"`
+	// RTT is the round-trip time of the last successful probe.
+	RTT string `json:"rtt,omitempty"`
+	// Error is the error of the last failed probe.
+	Error string `json:"error,omitempty"`
+}
+
+// Prober probes the listeners of the STUNner configs. The prober implements the
+// credentials.ListenerFilter interface to exclude unhealthy listeners in exclude mode, and the
+// credentials.ListenerScorer interface to demote unhealthy listeners in demote mode. Listeners
+// that have not been probed yet are considered healthy.
+type Prober struct {
+	config Config
+	// status maps the listener names and the probed addresses to the health status
+	status map[statusKey]Status
+	lock   sync.RWMutex
+	log    logging.LeveledLogger
+}
+
+// statusKey identifies the health status of a listener at a probed address.
+type statusKey struct{ listener, address string }
+
+// New creates a prober.
+func New(config Config, log logging.LeveledLogger) (*Prober, error) {
+	switch config.Mode {
+	case "":
+		config.Mode = ModeExclude
+	case ModeExclude, ModeDemote:
+	default:
+		return nil, fmt.Errorf("invalid health probe mode %q: should be %q or %q", config.Mode,
+			ModeExclude, ModeDemote)
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.Threshold <= 0 {
+		config.Threshold = DefaultThreshold
+	}
+
+	return &Prober{config: config, status: map[statusKey]Status{}, log: log}, nil
+}
+
+// Start probes the listeners of the STUNner configs returned by the given function periodically,
+// until the context is canceled. The addresses to probe are resolved with the credential
+// generation options returned by the options function.
+func (p *Prober) Start(ctx context.Context, configs func() []*stnrv1.StunnerConfig, options func() credentials.Options) {
+	go func() {
+		ticker := time.NewTicker(p.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			p.ProbeAll(ctx, configs(), options())
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// ProbeAll probes the listeners of the given STUNner configs in parallel and updates their
+// health status. The public addresses of the listeners are resolved with the given credential
+// generation options the same way as for credential generation, and each resolved address is
+// probed. The status of the listeners and addresses not present in the configs is removed.
+func (p *Prober) ProbeAll(ctx context.Context, configs []*stnrv1.StunnerConfig, opts credentials.Options) {
+	var wg sync.WaitGroup
+	seen := map[statusKey]bool{}
+	for _, c := range configs {
+		for _, l := range c.Listeners {
+			for _, r := range credentials.ResolveListener(l, opts) {
+				proto, addr, ok := target(&r)
+				if !ok {
+					continue
+				}
+				key := statusKey{listener: l.Name, address: addr}
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+
+				wg.Add(1)
+				go func(config, listener string) {
+					defer wg.Done()
+					rtt, err := probe(ctx, proto, addr, p.config.Timeout)
+					if errors.Is(err, errNotProbed) || ctx.Err() != nil {
+						return
+					}
+					p.update(Status{Listener: listener, Config: config, Protocol: proto.String(),
+						Address: addr}, rtt, err)
+				}(c.Admin.Name, l.Name)
+			}
+		}
+	}
+	wg.Wait()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for k := range p.status {
+		if !seen[k] {
+			delete(p.status, k)
+		}
+	}
+}
+
+// update records the result of a probe.
+func (p *Prober) update(s Status, rtt time.Duration, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	key := statusKey{listener: s.Listener, address: s.Address}
+	prev, ok := p.status[key]
+	if ok {
+		s.Failures = prev.Failures
+	} else {
+		// a new listener or a new address is healthy until proven otherwise
+		prev.Healthy = true
+	}
+
+	s.LastProbe = time.Now()
+	if err != nil {
+		s.Failures++
+		s.Error = err.Error()
+	} else {
+		s.Failures = 0
+		s.RTT = rtt.String()
+	}
+	s.Healthy = s.Failures < p.config.Threshold
+
+	switch {
+	case prev.Healthy && !s.Healthy:
+		p.log.Warnf("Listener %s at %s unhealthy: %s", s.Listener, s.Address, s.Error)
+	case !prev.Healthy && s.Healthy:
+		p.log.Infof("Listener %s at %s healthy again", s.Listener, s.Address)
+	default:
+		p.log.Tracef("Probed listener %s at %s: failures: %d", s.Listener, s.Address, s.Failures)
+	}
+
+	p.status[key] = s
+}
+
+// Status returns the health status of the probed listeners, sorted by listener name and address.
+func (p *Prober) Status() []Status {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	ret := []Status{}
+	for _, s := range p.status {
+		ret = append(ret, s)
+	}
+	slices.SortFunc(ret, func(a, b Status) int {
+		if c := strings.Compare(a.Listener, b.Listener); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Address, b.Address)
+	})
+	return ret
+}
+
+// unhealthy returns the status of a resolved listener if it is unhealthy at the address of the
+// listener. Listeners not probed at the address, e.g., when the public address is overridden in
+// the request, are considered healthy.
+func (p *Prober) unhealthy(l *stnrv1.ListenerConfig) (Status, bool) {
+	_, addr, ok := target(l)
+	if !ok {
+		return Status{}, false
+	}
+
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	s, ok := p.status[statusKey{listener: l.Name, address: addr}]
+	if !ok || s.Healthy {
+		return Status{}, false
+	}
+	return s, true
+}
+
+// FilterListener excludes the unhealthy listeners in exclude mode.
+func (p *Prober) FilterListener(_ *credentials.Request, _ *stnrv1.StunnerConfig, l *stnrv1.ListenerConfig) error {
+	if p.config.Mode != ModeExclude {
+		return nil
+	}
+	if s, ok := p.unhealthy(l); ok {
+		return fmt.Errorf("listener unhealthy: %s", s.Error)
+	}
+	return nil
+}
+
+// ScoreListener demotes the unhealthy listeners in demote mode.
+func (p *Prober) ScoreListener(_ *credentials.Request, _ *stnrv1.StunnerConfig, l *stnrv1.ListenerConfig) int {
+	if p.config.Mode != ModeDemote {
+		return 0
+	}
+	if _, ok := p.unhealthy(l); ok {
+		return -UnhealthyPenalty
+	}
+	return 0
+}
+
+// RegisterAdminRoutes registers the admin endpoints:
+//   - GET /health/listeners lists the health status of the probed listeners.
+func (p *Prober) RegisterAdminRoutes(r *mux.Router) {
+	r.HandleFunc("/health/listeners", func(w http.ResponseWriter, _ *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, p.Status())
+	}).Methods(http.MethodGet)
+}
+
+// target returns the protocol and the address to probe for a resolved listener, the same as the
+// address in the TURN URI generated for the listener. Returns false if the listener has no usable
+// address.
+func target(l *stnrv1.ListenerConfig) (stnrv1.ListenerProtocol, string, bool) {
+	proto, err := stnrv1.NewListenerProtocol(l.Protocol)
+	if err != nil {
+		return proto, "", false
+	}
+
+	addr := l.PublicAddr
+	if addr == "" {
+		addr = l.Addr
+	}
+	port := l.PublicPort
+	if port == 0 {
+		port = l.Port
+	}
+
+	if addr == "" || addr == stnrv1.DefaultNodeAddressPlaceholder || port == 0 {
+		return proto, "", false
+	}
+	if ip := net.ParseIP(addr); ip != nil && ip.IsUnspecified() {
+		return proto, "", false
+	}
+
+	return proto, net.JoinHostPort(addr, strconv.Itoa(port)), true
+}
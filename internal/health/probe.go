@@ -0,0 +1,108 @@
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/pion/stun/v3"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+)
+
+// stunHeaderSize is the size of the STUN message header.
+const stunHeaderSize = 20
+
+// errNotProbed is returned for listeners that cannot be probed, e.g., DTLS listeners.
+var errNotProbed = errors.New("listener protocol not probed")
+
+// probe sends a STUN Binding request to the given address over the transport of the listener
+// protocol and waits for the Binding success response. Returns the round-trip time.
+func probe(ctx context.Context, proto stnrv1.ListenerProtocol, addr string, timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := dial(ctx, proto, addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close() //nolint:errcheck
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return 0, err
+		}
+	}
+
+	req, err := stun.Build(stun.TransactionID, stun.BindingRequest, stun.Fingerprint)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := conn.Write(req.Raw); err != nil {
+		return 0, fmt.Errorf("cannot send Binding request: %w", err)
+	}
+
+	raw, err := readMessage(conn, proto == stnrv1.ListenerProtocolTURNUDP)
+	if err != nil {
+		return 0, fmt.Errorf("no Binding response: %w", err)
+	}
+	rtt := time.Since(start)
+
+	res := &stun.Message{Raw: raw}
+	if err := res.Decode(); err != nil {
+		return 0, fmt.Errorf("invalid Binding response: %w", err)
+	}
+	if res.TransactionID != req.TransactionID {
+		return 0, errors.New("invalid Binding response: transaction ID mismatch")
+	}
+	if res.Type != stun.BindingSuccess {
+		return 0, fmt.Errorf("unexpected response to Binding request: %s", res.Type)
+	}
+
+	return rtt, nil
+}
+
+func dial(ctx context.Context, proto stnrv1.ListenerProtocol, addr string) (net.Conn, error) {
+	d := &net.Dialer{}
+	switch proto {
+	case stnrv1.ListenerProtocolTURNUDP:
+		return d.DialContext(ctx, "udp", addr)
+	case stnrv1.ListenerProtocolTURNTCP:
+		return d.DialContext(ctx, "tcp", addr)
+	case stnrv1.ListenerProtocolTURNTLS:
+		// the probe checks reachability only, the certificate is verified by the clients
+		td := &tls.Dialer{NetDialer: d, Config: &tls.Config{InsecureSkipVerify: true}} //nolint:gosec
+		return td.DialContext(ctx, "tcp", addr)
+	}
+	return nil, errNotProbed
+}
+
+// readMessage reads a single STUN message from the connection: a datagram for packet
+// connections, or the header followed by the message body for stream connections.
+func readMessage(conn net.Conn, packet bool) ([]byte, error) {
+	if packet {
+		buf := make([]byte, 1500)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+
+	header := make([]byte, stunHeaderSize)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	raw := make([]byte, stunHeaderSize+int(binary.BigEndian.Uint16(header[2:4])))
+	copy(raw, header)
+	if _, err := io.ReadFull(conn, raw[stunHeaderSize:]); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
@@ -0,0 +1,319 @@
+// Package maintenance implements the drain and maintenance mode of Gateways: no new credentials
+// are issued for the Gateways and listeners marked as draining or disabled, while existing
+// sessions continue. Draining targets are still served on explicit request, disabled targets are
+// never served.
+package maintenance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pion/logging"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+
+	"github.com/l7mp/stunner-auth-service/internal/admin"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+)
+
+// Mode is the maintenance mode of a target.
+type Mode string
+
+const (
+	// ModeDraining marks a target that is about to be taken out of service: the target is
+	// served only if the request selects it explicitly by name.
+	ModeDraining Mode = "draining"
+	// ModeDisabled marks a target that is out of service: requests for the target are refused.
+	ModeDisabled Mode = "disabled"
+)
+
+// Entry marks a target as draining or disabled.
+type Entry struct {
+	// Target is either a namespace, a Gateway in the form "namespace/gateway" or a listener in
+	// the form "namespace/gateway/listener".
+	Target string `json:"target"`
+	// Mode is the maintenance mode.
+	Mode Mode `json:"mode"`
+	// Reason is a human-readable explanation (optional).
+	Reason string `json:"reason,omitempty"`
+	// Created is the time the entry was created.
+	Created time.Time `json:"created"`
+	// Expiry is the time the entry expires (optional).
+	Expiry *time.Time `json:"expiry,omitempty"`
+}
+
+// active reports whether the entry has not expired yet.
+func (e *Entry) active(now time.Time) bool {
+	return e.Expiry == nil || now.Before(*e.Expiry)
+}
+
+// Store keeps track of the targets in maintenance mode. The store implements the
+// credentials.ListenerFilter interface to exclude the listeners of such targets.
+type Store struct {
+	file    string
+	entries map[string]Entry
+	lock    sync.RWMutex
+	log     logging.LeveledLogger
+}
+
+// New creates a store. If file is not empty, the state is loaded from and persisted to the file.
+func New(file string, log logging.LeveledLogger) (*Store, error) {
+	s := &Store{file: file, entries: map[string]Entry{}, log: log}
+	if file == "" {
+		return s, nil
+	}
+
+	b, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read maintenance state file: %w", err)
+	}
+
+	entries := []Entry{}
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("cannot parse maintenance state file: %w", err)
+	}
+	for _, e := range entries {
+		s.entries[e.Target] = e
+	}
+
+	log.Infof("Loaded %d maintenance entries from %s", len(entries), file)
+
+	return s, nil
+}
+
+// Set marks a target as draining or disabled, replacing the previous entry for the target.
+func (s *Store) Set(e Entry) error {
+	if err := validate(e); err != nil {
+		return err
+	}
+	if e.Created.IsZero() {
+		e.Created = time.Now()
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.entries[e.Target] = e
+	s.log.Infof("Target %s marked as %s", e.Target, e.Mode)
+
+	return s.save()
+}
+
+// Delete removes the entry for a target. Returns false if there is no entry for the target.
+func (s *Store) Delete(target string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.entries[target]; !ok {
+		return false, nil
+	}
+	delete(s.entries, target)
+	s.log.Infof("Target %s back in service", target)
+
+	return true, s.save()
+}
+
+// List returns the active entries, sorted by target.
+func (s *Store) List() []Entry {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	now := time.Now()
+	ret := []Entry{}
+	for _, e := range s.entries {
+		if e.active(now) {
+			ret = append(ret, e)
+		}
+	}
+	slices.SortFunc(ret, func(a, b Entry) int { return strings.Compare(a.Target, b.Target) })
+	return ret
+}
+
+// Lookup returns the active entry that applies to a listener, if any. Listener entries take
+// precedence over Gateway entries, which take precedence over namespace entries.
+func (s *Store) Lookup(listener string) (Entry, bool) {
+	tokens := strings.Split(listener, "/")
+	if len(tokens) != 3 {
+		return Entry{}, false
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	now := time.Now()
+	for _, t := range []string{listener, tokens[0] + "/" + tokens[1], tokens[0]} {
+		if e, ok := s.entries[t]; ok && e.active(now) {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// FilterListener excludes the listeners of the targets in maintenance mode. Draining listeners
+// are still served if the request selects the target explicitly by name, see explicit. Disabled
+// listeners are denied, so that requests for them alone are refused.
+func (s *Store) FilterListener(req *credentials.Request, _ *stnrv1.StunnerConfig, l *stnrv1.ListenerConfig) error {
+	e, ok := s.Lookup(l.Name)
+	if !ok {
+		return nil
+	}
+	reason := fmt.Sprintf("%s is %s", e.Target, e.Mode)
+	if e.Reason != "" {
+		reason = fmt.Sprintf("%s: %s", reason, e.Reason)
+	}
+	if e.Mode == ModeDisabled {
+		return &credentials.DenialError{Reason: reason}
+	}
+	if explicit(req, e.Target) {
+		return nil
+	}
+	return errors.New(reason)
+}
+
+// explicit reports whether the request selects a target by name: the namespace, Gateway or
+// listener selector of the request, whichever corresponds to the target, must list the name of
+// the target literally, not only via a glob pattern.
+func explicit(req *credentials.Request, target string) bool {
+	tokens := strings.Split(target, "/")
+	selector := []string{req.Namespace, req.Gateway, req.Listener}[len(tokens)-1]
+	for _, p := range strings.Split(selector, ",") {
+		if strings.TrimSpace(p) == tokens[len(tokens)-1] {
+			return true
+		}
+	}
+	return false
+}
+
+// save persists the active entries, must be called with the lock held.
+func (s *Store) save() error {
+	now := time.Now()
+	entries := []Entry{}
+	for t, e := range s.entries {
+		if !e.active(now) {
+			delete(s.entries, t)
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	if s.file == "" {
+		return nil
+	}
+
+	slices.SortFunc(entries, func(a, b Entry) int { return strings.Compare(a.Target, b.Target) })
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write atomically
+	tmp, err := os.CreateTemp(filepath.Dir(s.file), ".maintenance-*")
+	if err != nil {
+		return fmt.Errorf("cannot persist maintenance state: %w", err)
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close() //nolint:errcheck
+		return fmt.Errorf("cannot persist maintenance state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot persist maintenance state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.file); err != nil {
+		return fmt.Errorf("cannot persist maintenance state: %w", err)
+	}
+
+	return nil
+}
+
+func validate(e Entry) error {
+	tokens := strings.Split(e.Target, "/")
+	if len(tokens) > 3 || slices.Contains(tokens, "") {
+		return fmt.Errorf(`invalid target %q: should be "namespace", "namespace/gateway" or `+
+			`"namespace/gateway/listener"`, e.Target)
+	}
+	if e.Mode != ModeDraining && e.Mode != ModeDisabled {
+		return fmt.Errorf("invalid mode %q: should be %q or %q", e.Mode, ModeDraining, ModeDisabled)
+	}
+	if e.Expiry != nil && !e.Expiry.After(time.Now()) {
+		return errors.New("expiry is in the past")
+	}
+	return nil
+}
+
+// entryRequest is the body of the admin API request to mark a target.
+type entryRequest struct {
+	Mode   Mode       `json:"mode"`
+	Reason string     `json:"reason,omitempty"`
+	Expiry *time.Time `json:"expiry,omitempty"`
+	// TTL sets the expiry relative to the current time, in seconds.
+	TTL *int `json:"ttl,omitempty"`
+}
+
+// RegisterAdminRoutes registers the admin endpoints:
+//   - GET /maintenance lists the active entries,
+//   - PUT /maintenance/{target} marks a target as draining or disabled,
+//   - DELETE /maintenance/{target} puts a target back into service.
+func (s *Store) RegisterAdminRoutes(r *mux.Router) {
+	r.HandleFunc("/maintenance", func(w http.ResponseWriter, _ *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, s.List())
+	}).Methods(http.MethodGet)
+
+	r.HandleFunc("/maintenance/{target:.+}", func(w http.ResponseWriter, r *http.Request) {
+		req := entryRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request body: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		e := Entry{Target: mux.Vars(r)["target"], Mode: req.Mode, Reason: req.Reason, Expiry: req.Expiry}
+		if req.TTL != nil {
+			expiry := time.Now().Add(time.Duration(*req.TTL) * time.Second)
+			e.Expiry = &expiry
+		}
+
+		if err := validate(e); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.Set(e); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		e, _ = s.get(e.Target)
+		admin.WriteJSON(w, http.StatusOK, e)
+	}).Methods(http.MethodPut)
+
+	r.HandleFunc("/maintenance/{target:.+}", func(w http.ResponseWriter, r *http.Request) {
+		ok, err := s.Delete(mux.Vars(r)["target"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "no maintenance entry for target", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods(http.MethodDelete)
+}
+
+func (s *Store) get(target string) (Entry, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	e, ok := s.entries[target]
+	return e, ok
+}
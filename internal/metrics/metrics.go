@@ -0,0 +1,37 @@
+// Package metrics defines the Prometheus metrics of the authentication service.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "stunner_auth"
+
+var (
+	// Registry is the registry of the metrics of the authentication service.
+	Registry = prometheus.NewRegistry()
+
+	// Requests counts the credential requests by API and HTTP status code.
+	Requests = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Number of credential requests by API and HTTP status code.",
+	}, []string{"api", "code"})
+
+	// CanaryAssignments counts the credential requests assigned to the arms of traffic splits, by
+	// the result of credential generation.
+	CanaryAssignments = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "canary_assignments_total",
+		Help:      "Number of credential requests assigned to each arm of a traffic split by result.",
+	}, []string{"split", "arm", "result"})
+)
+
+// Handler returns the HTTP handler exposing the metrics.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
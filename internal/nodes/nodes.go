@@ -0,0 +1,116 @@
+// Package nodes watches the Kubernetes nodes to resolve the node address placeholder STUNner sets
+// as the address of the listeners with relay address discovery.
+package nodes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pion/logging"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/l7mp/stunner-auth-service/internal/admin"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+)
+
+// DefaultResyncPeriod is the default resync period of the node informer.
+const DefaultResyncPeriod = 10 * time.Minute
+
+// Nodes implements the credentials.NodeAddresser interface with the addresses of the Kubernetes
+// nodes, watched with an informer. The address of a node is its ExternalIP, or its InternalIP if
+// the node has no ExternalIP. Nodes that are not ready or have no address are skipped.
+type Nodes struct {
+	factory informers.SharedInformerFactory
+	lister  corelisters.NodeLister
+	synced  cache.InformerSynced
+	log     logging.LeveledLogger
+}
+
+// New creates a node watcher using the given Kubernetes client.
+func New(client kubernetes.Interface, log logging.LeveledLogger) *Nodes {
+	factory := informers.NewSharedInformerFactory(client, DefaultResyncPeriod)
+	informer := factory.Core().V1().Nodes()
+	return &Nodes{
+		factory: factory,
+		lister:  informer.Lister(),
+		synced:  informer.Informer().HasSynced,
+		log:     log,
+	}
+}
+
+// Start starts watching the nodes until the context is canceled, and waits for the initial list
+// of the nodes.
+func (n *Nodes) Start(ctx context.Context) error {
+	n.factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), n.synced) {
+		return fmt.Errorf("cannot sync node informer: %w", ctx.Err())
+	}
+	n.log.Infof("Watching Kubernetes nodes: %d node addresses available", len(n.NodeAddresses()))
+	return nil
+}
+
+// NodeAddresses returns the addresses of the ready nodes, sorted by node name.
+func (n *Nodes) NodeAddresses() []credentials.NodeAddress {
+	nodes, err := n.lister.List(labels.Everything())
+	if err != nil {
+		n.log.Errorf("Cannot list nodes: %s", err.Error())
+		return nil
+	}
+
+	ret := []credentials.NodeAddress{}
+	for _, node := range nodes {
+		if !ready(node) {
+			continue
+		}
+		if addr := nodeAddress(node); addr != "" {
+			ret = append(ret, credentials.NodeAddress{Node: node.Name, Address: addr})
+		}
+	}
+	slices.SortFunc(ret, func(a, b credentials.NodeAddress) int { return strings.Compare(a.Node, b.Node) })
+	return ret
+}
+
+// RegisterAdminRoutes registers the admin endpoints:
+//   - GET /nodes lists the node addresses.
+func (n *Nodes) RegisterAdminRoutes(r *mux.Router) {
+	r.HandleFunc("/nodes", func(w http.ResponseWriter, _ *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, n.NodeAddresses())
+	}).Methods(http.MethodGet)
+}
+
+// nodeAddress returns the ExternalIP of a node, or the InternalIP if the node has no ExternalIP.
+// Dual-stack nodes yield the first address of each address family, separated by a comma.
+func nodeAddress(node *corev1.Node) string {
+	for _, t := range []corev1.NodeAddressType{corev1.NodeExternalIP, corev1.NodeInternalIP} {
+		addrs := []string{}
+		for _, a := range node.Status.Addresses {
+			if a.Type == t {
+				addrs = append(addrs, a.Address)
+			}
+		}
+		if addr := credentials.DualStackAddr(addrs); addr != "" {
+			return addr
+		}
+	}
+	return ""
+}
+
+// ready checks whether a node is ready. Nodes with no Ready condition are considered ready.
+func ready(node *corev1.Node) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status != corev1.ConditionFalse
+		}
+	}
+	return true
+}
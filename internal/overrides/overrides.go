@@ -0,0 +1,89 @@
+// Package overrides loads the per-listener, per-Gateway and per-protocol public address overrides
+// from a file, e.g., a mounted ConfigMap, and reloads them whenever the file changes.
+package overrides
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pion/logging"
+	"sigs.k8s.io/yaml"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+
+	"github.com/l7mp/stunner-auth-service/internal/filewatch"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+)
+
+// DefaultReloadInterval is the default interval for checking the override file for changes.
+const DefaultReloadInterval = filewatch.DefaultReloadInterval
+
+// Overrides implements the credentials.AddressOverrider interface with the address overrides
+// loaded from a file. The file is a YAML or JSON map from listeners in the form
+// "namespace/gateway/listener", Gateways in the form "namespace/gateway" or listener protocols to
+// the public address, port and/or, for TLS and DTLS listeners, hostname to use, e.g.:
+//
+//	stunner/udp-gateway:
+//	  publicAddr: 1.2.3.4
+//	turn-tcp:
+//	  publicAddr: 5.6.7.8
+//	  publicPort: 443
+//	turn-tls:
+//	  hostname: turn.example.com
+type Overrides struct {
+	file      string
+	watcher   *filewatch.Watcher
+	overrides credentials.AddressOverrides
+	lock      sync.RWMutex
+	log       logging.LeveledLogger
+}
+
+// New loads the address overrides from the given file.
+func New(file string, log logging.LeveledLogger) (*Overrides, error) {
+	o := &Overrides{file: file, log: log}
+	w, err := filewatch.New(file, "address override file", o.load, log)
+	if err != nil {
+		return nil, err
+	}
+	o.watcher = w
+	return o, nil
+}
+
+// Start reloads the override file whenever its content changes, until the context is canceled.
+// If the new overrides are invalid, the last valid overrides remain in effect.
+func (o *Overrides) Start(ctx context.Context, interval time.Duration) {
+	o.watcher.Start(ctx, interval)
+}
+
+// Reload reloads the override file if its content has changed.
+func (o *Overrides) Reload() error {
+	return o.watcher.Reload()
+}
+
+// load parses and validates the content of the override file and puts the overrides in effect.
+func (o *Overrides) load(b []byte) error {
+	overrides := credentials.AddressOverrides{}
+	if err := yaml.UnmarshalStrict(b, &overrides); err != nil {
+		return fmt.Errorf("cannot parse address override file: %w", err)
+	}
+	if err := overrides.Validate(); err != nil {
+		return err
+	}
+
+	o.lock.Lock()
+	o.overrides = overrides
+	o.lock.Unlock()
+
+	o.log.Infof("Loaded address override file %s: %d overrides", o.file, len(overrides))
+
+	return nil
+}
+
+// OverrideAddress returns the address override for a listener.
+func (o *Overrides) OverrideAddress(l *stnrv1.ListenerConfig) credentials.AddressOverride {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	return o.overrides.OverrideAddress(l)
+}
@@ -0,0 +1,271 @@
+// Package policy implements a CEL policy engine for authorizing credential requests and
+// selecting the listeners TURN URIs are generated for.
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/pion/logging"
+	"sigs.k8s.io/yaml"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+
+	"github.com/l7mp/stunner-auth-service/internal/filewatch"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+)
+
+// DefaultReloadInterval is the default interval for checking the policy file for changes.
+const DefaultReloadInterval = filewatch.DefaultReloadInterval
+
+// Identity assigns a set of namespaces to callers identified by an API key. Callers are not
+// identified by the user name in HTTP basic authentication, as the password is not verified.
+type Identity struct {
+	// Key is the API key of the caller.
+	Key string `json:"key"`
+	// Namespaces is the list of namespaces exposed as `identity.namespaces` to the rules.
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// Rule is a named CEL expression. The expression must evaluate to a boolean: if false, the
+// listener is denied.
+type Rule struct {
+	// Name identifies the rule in error responses and logs.
+	Name string `json:"name"`
+	// Expression is the CEL expression.
+	Expression string `json:"expression"`
+}
+
+// Spec is the content of a policy file.
+type Spec struct {
+	// Identities lists the known callers.
+	Identities []Identity `json:"identities,omitempty"`
+	// Rules lists the rules, all of which must hold for a listener to be selected.
+	Rules []Rule `json:"rules,omitempty"`
+}
+
+type program struct {
+	name    string
+	program cel.Program
+}
+
+// compiled is a parsed and compiled policy.
+type compiled struct {
+	identities []Identity
+	programs   []program
+}
+
+// Policy is a CEL policy engine that implements the credentials.ListenerFilter interface. The
+// rules can refer to the following variables:
+//   - request: the credential request (username, ttl in seconds, namespace, gateway, listener,
+//     publicAddr, iceTransportPolicy),
+//   - identity: the caller (key, remoteAddr, namespaces),
+//   - listener: the candidate listener (name, namespace, gateway, listener, protocol in lower
+//     case, addr, port, publicAddr, publicPort).
+type Policy struct {
+	file string
+	// watcher is nil for static policies
+	watcher  *filewatch.Watcher
+	compiled *compiled
+	lock     sync.RWMutex
+	log      logging.LeveledLogger
+}
+
+// New loads the policy from the given file.
+func New(file string, log logging.LeveledLogger) (*Policy, error) {
+	p := &Policy{file: file, log: log}
+	w, err := filewatch.New(file, "policy file", p.load, log)
+	if err != nil {
+		return nil, err
+	}
+	p.watcher = w
+	return p, nil
+}
+
+// NewFromSpec creates a static policy from a policy spec.
+func NewFromSpec(spec Spec, log logging.LeveledLogger) (*Policy, error) {
+	c, err := compile(spec)
+	if err != nil {
+		return nil, err
+	}
+	return &Policy{compiled: c, log: log}, nil
+}
+
+// Start reloads the policy file whenever its content changes, until the context is canceled. If
+// the new policy is invalid, the last valid policy remains in effect.
+func (p *Policy) Start(ctx context.Context, interval time.Duration) {
+	if p.watcher == nil {
+		return
+	}
+	p.watcher.Start(ctx, interval)
+}
+
+// Reload reloads the policy file if its content has changed.
+func (p *Policy) Reload() error {
+	if p.watcher == nil {
+		return errors.New("static policy cannot be reloaded")
+	}
+	return p.watcher.Reload()
+}
+
+// load parses and compiles the content of the policy file and puts the policy in effect.
+func (p *Policy) load(b []byte) error {
+	spec := Spec{}
+	if err := yaml.UnmarshalStrict(b, &spec); err != nil {
+		return fmt.Errorf("cannot parse policy file: %w", err)
+	}
+
+	c, err := compile(spec)
+	if err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	p.compiled = c
+	p.lock.Unlock()
+
+	p.log.Infof("Loaded policy file %s: %d identities, %d rules", p.file, len(c.identities),
+		len(c.programs))
+
+	return nil
+}
+
+// FilterListener evaluates the rules for a listener and returns a credentials.DenialError naming
+// the rules that do not hold.
+func (p *Policy) FilterListener(req *credentials.Request, _ *stnrv1.StunnerConfig, l *stnrv1.ListenerConfig) error {
+	p.lock.RLock()
+	c := p.compiled
+	p.lock.RUnlock()
+
+	vars := map[string]any{
+		"request":  requestVar(req),
+		"identity": c.identityVar(req.Identity),
+		"listener": listenerVar(l),
+	}
+
+	denied := []string{}
+	for _, prog := range c.programs {
+		out, _, err := prog.program.Eval(vars)
+		if err != nil {
+			p.log.Warnf("Policy rule %q failed to evaluate on listener %s, denying: %s",
+				prog.name, l.Name, err.Error())
+			denied = append(denied, prog.name)
+			continue
+		}
+		if allowed, ok := out.Value().(bool); !ok || !allowed {
+			denied = append(denied, prog.name)
+		}
+	}
+
+	if len(denied) == 0 {
+		return nil
+	}
+
+	reason := "policy rules violated: " + strings.Join(denied, ", ")
+	p.log.Infof("Listener %s denied for request %s: %s", l.Name, req.String(), reason)
+	return &credentials.DenialError{Reason: reason}
+}
+
+func compile(spec Spec) (*compiled, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("request", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("identity", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("listener", cel.MapType(cel.StringType, cel.DynType)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create CEL environment: %w", err)
+	}
+
+	for i, id := range spec.Identities {
+		if id.Key == "" {
+			return nil, fmt.Errorf("invalid policy: identity %d: key must be set", i)
+		}
+	}
+
+	c := &compiled{identities: spec.Identities}
+	names := map[string]bool{}
+	for _, r := range spec.Rules {
+		if r.Name == "" {
+			return nil, errors.New("invalid policy: rule name must be set")
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("invalid policy: duplicate rule name %q", r.Name)
+		}
+		names[r.Name] = true
+
+		ast, iss := env.Compile(r.Expression)
+		if iss.Err() != nil {
+			return nil, fmt.Errorf("invalid policy rule %q: %w", r.Name, iss.Err())
+		}
+		if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+			return nil, fmt.Errorf("invalid policy rule %q: expression must evaluate to a "+
+				"bool, got %s", r.Name, ast.OutputType())
+		}
+
+		prg, err := env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("invalid policy rule %q: %w", r.Name, err)
+		}
+		c.programs = append(c.programs, program{name: r.Name, program: prg})
+	}
+
+	return c, nil
+}
+
+func requestVar(req *credentials.Request) map[string]any {
+	ttl := credentials.DefaultTTL
+	if req.TTL > 0 {
+		ttl = req.TTL
+	}
+	return map[string]any{
+		"username":           req.Username,
+		"ttl":                int64(ttl.Seconds()),
+		"namespace":          req.Namespace,
+		"gateway":            req.Gateway,
+		"listener":           req.Listener,
+		"publicAddr":         req.PublicAddr,
+		"iceTransportPolicy": string(req.IceTransportPolicy),
+	}
+}
+
+// identityVar returns the caller identity, with the namespaces of the first matching identity in
+// the policy.
+func (c *compiled) identityVar(id *credentials.Identity) map[string]any {
+	ret := map[string]any{"key": "", "remoteAddr": "", "namespaces": []string{}}
+	if id == nil {
+		return ret
+	}
+
+	ret["key"], ret["remoteAddr"] = id.Key, id.RemoteAddr
+	for _, i := range c.identities {
+		if id.Key != "" && i.Key == id.Key {
+			ret["namespaces"] = i.Namespaces
+			break
+		}
+	}
+
+	return ret
+}
+
+func listenerVar(l *stnrv1.ListenerConfig) map[string]any {
+	ret := map[string]any{
+		"name":       l.Name,
+		"namespace":  "",
+		"gateway":    "",
+		"listener":   "",
+		"protocol":   strings.ToLower(l.Protocol),
+		"addr":       l.Addr,
+		"port":       int64(l.Port),
+		"publicAddr": l.PublicAddr,
+		"publicPort": int64(l.PublicPort),
+	}
+	if tokens := strings.Split(l.Name, "/"); len(tokens) == 3 {
+		ret["namespace"], ret["gateway"], ret["listener"] = tokens[0], tokens[1], tokens[2]
+	}
+	return ret
+}
@@ -0,0 +1,454 @@
+// Package selftest implements an end-to-end credential self-test: credentials are issued through
+// the normal request path for a given filter, and a TURN allocation is performed with the
+// credentials against each returned TURN URI.
+package selftest
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pion/dtls/v3"
+	"github.com/pion/logging"
+	"github.com/pion/stun/v3"
+	"github.com/pion/turn/v4"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+	a12n "github.com/l7mp/stunner/pkg/authentication"
+
+	"github.com/l7mp/stunner-auth-service/internal/admin"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+// DefaultTimeout is the default time to wait for the allocation for a single TURN URI.
+const DefaultTimeout = 5 * time.Second
+
+// Outcome is the outcome of the self-test for a TURN URI.
+type Outcome string
+
+const (
+	// OutcomeSuccess means that the allocation succeeded.
+	OutcomeSuccess Outcome = "success"
+	// OutcomeUnreachable means that the TURN server did not respond.
+	OutcomeUnreachable Outcome = "unreachable"
+	// OutcomeUnauthorized means that the TURN server rejected the credentials (error 401, or
+	// error 400 for a failed message integrity check).
+	OutcomeUnauthorized Outcome = "unauthorized"
+	// OutcomeStaleNonce means that the TURN server rejected the nonce (error 438).
+	OutcomeStaleNonce Outcome = "stale-nonce"
+	// OutcomeError means that the allocation failed for another reason.
+	OutcomeError Outcome = "error"
+)
+
+// Result is the result of the self-test for a TURN URI.
+type Result struct {
+	// URI is the TURN URI.
+	URI string `json:"uri"`
+	// Username is the TURN username issued for the URI.
+	Username string `json:"username"`
+	// Outcome is the outcome of the allocation.
+	Outcome Outcome `json:"outcome"`
+	// Reachable reports whether the TURN server responded to a STUN Binding request.
+	Reachable bool `json:"reachable"`
+	// Realm is the realm announced by the TURN server.
+	Realm string `json:"realm,omitempty"`
+	// ExpectedRealm is the realm in the STUNner config the credentials were issued from.
+	ExpectedRealm string `json:"expectedRealm,omitempty"`
+	// RealmMismatch reports whether the realm announced by the TURN server differs from the
+	// expected realm.
+	RealmMismatch bool `json:"realmMismatch,omitempty"`
+	// ErrorCode is the STUN error code returned by the TURN server, if any.
+	ErrorCode int `json:"errorCode,omitempty"`
+	// RelayedAddress is the relayed transport address of a successful allocation.
+	RelayedAddress string `json:"relayedAddress,omitempty"`
+	// Error is a human-readable description of the failure.
+	Error string `json:"error,omitempty"`
+}
+
+// Report is the result of a self-test.
+type Report struct {
+	// Query is the query of the credential request.
+	Query string `json:"query"`
+	// Status is the HTTP status code of the credential request.
+	Status int `json:"status"`
+	// Error is the error returned for the credential request, if any.
+	Error string `json:"error,omitempty"`
+	// Results lists the results for each TURN URI.
+	Results []Result `json:"results"`
+	// Success reports whether credentials were issued and the allocation succeeded for all
+	// TURN URIs.
+	Success bool `json:"success"`
+}
+
+// SelfTest runs end-to-end credential self-tests.
+type SelfTest struct {
+	api     http.Handler
+	configs func() []*stnrv1.StunnerConfig
+	timeout time.Duration
+	turnLog logging.LoggerFactory
+	log     logging.LeveledLogger
+}
+
+// New creates a self-test that requests credentials from the given REST API handler. The STUNner
+// configs returned by the configs function are used to find the realm expected for the issued
+// credentials. A zero timeout means DefaultTimeout. The logger factory is used for the self-test
+// and the TURN clients.
+func New(api http.Handler, configs func() []*stnrv1.StunnerConfig, timeout time.Duration, loggerFactory logging.LoggerFactory) *SelfTest {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &SelfTest{
+		api:     api,
+		configs: configs,
+		timeout: timeout,
+		turnLog: singleLoggerFactory{loggerFactory.NewLogger("selftest-turn")},
+		log:     loggerFactory.NewLogger("selftest"),
+	}
+}
+
+// singleLoggerFactory returns the same logger for all scopes, so that the TURN clients of the
+// self-tests running in parallel do not create loggers concurrently.
+type singleLoggerFactory struct {
+	log logging.LeveledLogger
+}
+
+func (f singleLoggerFactory) NewLogger(string) logging.LeveledLogger { return f.log }
+
+// Run requests an ICE config with the given query, which may contain any of the filters accepted
+// by the getIceAuth API, and tests the TURN URIs in the response in parallel. The service is
+// always set to "turn".
+func (s *SelfTest) Run(ctx context.Context, query url.Values) Report {
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("service", "turn")
+	report := Report{Query: q.Encode(), Results: []Result{}}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/ice?"+report.Query, nil)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	req.RemoteAddr = "127.0.0.1:0"
+	w := httptest.NewRecorder()
+	s.api.ServeHTTP(w, req)
+
+	report.Status = w.Code
+	if w.Code != http.StatusOK {
+		report.Error = w.Body.String()
+		return report
+	}
+
+	iceConfig := types.IceConfig{}
+	if err := json.Unmarshal(w.Body.Bytes(), &iceConfig); err != nil {
+		report.Error = fmt.Sprintf("invalid ICE config: %s", err.Error())
+		return report
+	}
+
+	type job struct{ uri, username, password, realm string }
+	jobs := []job{}
+	for _, server := range *iceConfig.IceServers {
+		if server.Urls == nil || server.Username == nil || server.Credential == nil {
+			continue
+		}
+		username, password := *server.Username, *server.Credential
+		realm := expectedRealm(s.configs(), username, password)
+		for _, uri := range *server.Urls {
+			jobs = append(jobs, job{uri: uri, username: username, password: password, realm: realm})
+		}
+	}
+
+	report.Results = make([]Result, len(jobs))
+	var wg sync.WaitGroup
+	for i, j := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.Results[i] = s.testURI(ctx, j.uri, j.username, j.password, j.realm)
+		}()
+	}
+	wg.Wait()
+
+	report.Success = len(report.Results) > 0
+	for _, r := range report.Results {
+		if r.Outcome != OutcomeSuccess {
+			report.Success = false
+		}
+	}
+
+	return report
+}
+
+// testURI performs a TURN allocation against a TURN URI.
+func (s *SelfTest) testURI(ctx context.Context, uri, username, password, realm string) Result {
+	res := Result{URI: uri, Username: username, Outcome: OutcomeError, ExpectedRealm: realm}
+
+	u, err := stun.ParseURI(uri)
+	if err != nil {
+		res.Error = fmt.Sprintf("invalid TURN URI: %s", err.Error())
+		return res
+	}
+	addr := net.JoinHostPort(u.Host, strconv.Itoa(u.Port))
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	c, err := dial(ctx, u, addr)
+	if err != nil {
+		res.Outcome = OutcomeUnreachable
+		res.Error = err.Error()
+		return res
+	}
+	conn := &errorCodeConn{PacketConn: c}
+	defer conn.Close() //nolint:errcheck
+
+	client, err := turn.NewClient(&turn.ClientConfig{
+		STUNServerAddr: addr,
+		TURNServerAddr: addr,
+		Username:       username,
+		Password:       password,
+		Conn:           conn,
+		// the retransmission interval doubles with each of the 7 retransmissions
+		RTO:           max(s.timeout/127, 10*time.Millisecond),
+		LoggerFactory: s.turnLog,
+	})
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	defer client.Close()
+
+	if err := client.Listen(); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	if _, err := client.SendBindingRequest(); err != nil {
+		res.Outcome = OutcomeUnreachable
+		res.Error = fmt.Sprintf("no response to STUN Binding request: %s", err.Error())
+		return res
+	}
+	res.Reachable = true
+
+	relayConn, err := client.Allocate()
+	res.Realm = client.Realm().String()
+	res.RealmMismatch = realm != "" && res.Realm != "" && res.Realm != realm
+	if err != nil {
+		res.Error = err.Error()
+		res.ErrorCode = int(conn.errorCode())
+		switch stun.ErrorCode(res.ErrorCode) {
+		case stun.CodeUnauthorized:
+			res.Outcome = OutcomeUnauthorized
+		case stun.CodeBadRequest:
+			// pion/turn servers, including STUNner, reject authenticated requests with an
+			// unknown username or a failed message integrity check with a 400 error
+			res.Outcome = OutcomeUnauthorized
+			res.Error += ": credentials rejected, unknown username or wrong password?"
+		case stun.CodeStaleNonce:
+			res.Outcome = OutcomeStaleNonce
+		}
+		s.log.Debugf("Self-test allocation failed for %s: %s", uri, err.Error())
+		return res
+	}
+	defer relayConn.Close() //nolint:errcheck
+
+	res.Outcome = OutcomeSuccess
+	res.RelayedAddress = relayConn.LocalAddr().String()
+	return res
+}
+
+// dial opens a connection to the TURN server over the transport of the TURN URI.
+func dial(ctx context.Context, u *stun.URI, addr string) (net.PacketConn, error) {
+	d := &net.Dialer{}
+	// the self-test checks the TURN credentials, the certificate is verified by the clients
+	tlsConfig := &tls.Config{InsecureSkipVerify: true} //nolint:gosec
+
+	// IPv6 TURN URIs need an IPv6 socket
+	network, laddr := "udp4", "0.0.0.0:0"
+	if ip := net.ParseIP(u.Host); ip != nil && ip.To4() == nil {
+		network, laddr = "udp6", "[::]:0"
+	}
+
+	switch {
+	case u.Scheme == stun.SchemeTypeTURN && u.Proto == stun.ProtoTypeUDP:
+		return net.ListenPacket(network, laddr)
+
+	case u.Scheme == stun.SchemeTypeTURN && u.Proto == stun.ProtoTypeTCP:
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return turn.NewSTUNConn(conn), nil
+
+	case u.Scheme == stun.SchemeTypeTURNS && u.Proto == stun.ProtoTypeTCP:
+		conn, err := (&tls.Dialer{NetDialer: d, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return turn.NewSTUNConn(conn), nil
+
+	case u.Scheme == stun.SchemeTypeTURNS && u.Proto == stun.ProtoTypeUDP:
+		raddr, err := net.ResolveUDPAddr(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dtls.Dial(network, raddr, &dtls.Config{InsecureSkipVerify: true}) //nolint:gosec
+		if err != nil {
+			return nil, err
+		}
+		if err := conn.HandshakeContext(ctx); err != nil {
+			conn.Close() //nolint:errcheck
+			return nil, fmt.Errorf("DTLS handshake failed: %w", err)
+		}
+		return &packetConn{Conn: conn}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported TURN URI scheme %q or transport %q", u.Scheme, u.Proto)
+}
+
+// errorCodeConn records the error code in the STUN error response to the last Allocate request
+// sent over the connection.
+type errorCodeConn struct {
+	net.PacketConn
+	transactionID [stun.TransactionIDSize]byte
+	code          stun.ErrorCode
+	lock          sync.Mutex
+}
+
+func (c *errorCodeConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if m, ok := decodeMessage(p); ok && m.Type == stun.NewType(stun.MethodAllocate, stun.ClassRequest) {
+		c.lock.Lock()
+		c.transactionID, c.code = m.TransactionID, 0
+		c.lock.Unlock()
+	}
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+func (c *errorCodeConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	if err != nil {
+		return n, addr, err
+	}
+
+	m, ok := decodeMessage(p[:n])
+	if !ok || m.Type != stun.NewType(stun.MethodAllocate, stun.ClassErrorResponse) {
+		return n, addr, err
+	}
+	code := stun.ErrorCodeAttribute{}
+	if code.GetFrom(m) != nil {
+		return n, addr, err
+	}
+
+	c.lock.Lock()
+	if m.TransactionID == c.transactionID {
+		c.code = code.Code
+	}
+	c.lock.Unlock()
+
+	return n, addr, err
+}
+
+// errorCode returns the error code in the response to the last Allocate request, or zero if the
+// request did not fail with a STUN error response.
+func (c *errorCodeConn) errorCode() stun.ErrorCode {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.code
+}
+
+// decodeMessage decodes a STUN message.
+func decodeMessage(p []byte) (*stun.Message, bool) {
+	if !stun.IsMessage(p) {
+		return nil, false
+	}
+	m := &stun.Message{Raw: append([]byte{}, p...)}
+	if err := m.Decode(); err != nil {
+		return nil, false
+	}
+	return m, true
+}
+
+// packetConn adapts a connected datagram connection to the net.PacketConn interface.
+type packetConn struct {
+	net.Conn
+}
+
+func (c *packetConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, err := c.Read(p)
+	return n, c.RemoteAddr(), err
+}
+
+func (c *packetConn) WriteTo(p []byte, _ net.Addr) (int, error) {
+	return c.Write(p)
+}
+
+// expectedRealm returns the realm of the STUNner config that yields the given credentials.
+func expectedRealm(configs []*stnrv1.StunnerConfig, username, password string) string {
+	for _, c := range configs {
+		auth := c.Auth
+		match := false
+		switch auth.Type {
+		case "static", "plaintext":
+			match = auth.Credentials["username"] == username && auth.Credentials["password"] == password
+		case "ephemeral", "timewindowed", "longterm":
+			p, err := a12n.GetLongTermCredential(username, auth.Credentials["secret"])
+			match = err == nil && p == password
+		}
+		if !match {
+			continue
+		}
+		if auth.Realm != "" {
+			return auth.Realm
+		}
+		return stnrv1.DefaultRealm
+	}
+	return ""
+}
+
+// RegisterAdminRoutes registers the admin endpoints:
+//   - GET /selftest runs a self-test with the filters in the query, e.g.,
+//     /selftest?namespace=stunner&gateway=udp-gateway.
+func (s *SelfTest) RegisterAdminRoutes(r *mux.Router) {
+	r.HandleFunc("/selftest", func(w http.ResponseWriter, r *http.Request) {
+		report := s.Run(r.Context(), r.URL.Query())
+		s.log.Infof("Self-test for query %q: success: %t", report.Query, report.Success)
+		admin.WriteJSON(w, http.StatusOK, report)
+	}).Methods(http.MethodGet)
+}
+
+// Fetch runs a self-test on the admin API of a running authentication service at the given base
+// URL, e.g., "http://127.0.0.1:8089", with the given admin API token, if not empty.
+func Fetch(ctx context.Context, adminURL, token string, query url.Values) (*Report, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, adminURL+"/selftest?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status from admin API: %s", resp.Status)
+	}
+
+	report := &Report{}
+	if err := json.NewDecoder(resp.Body).Decode(report); err != nil {
+		return nil, fmt.Errorf("invalid self-test report: %w", err)
+	}
+	return report, nil
+}
@@ -0,0 +1,114 @@
+package tags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pion/logging"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+
+	"github.com/l7mp/stunner-auth-service/internal/enricher"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+)
+
+// AnnotationPrefix is the prefix of the Gateway annotations that define tags: the annotation
+// "tags.stunner.l7mp.io/tier: premium" tags all listeners of the Gateway with "tier=premium".
+const AnnotationPrefix = "tags.stunner.l7mp.io/"
+
+// Annotations implements the credentials.Tagger interface with the tags in the annotations of
+// the Gateways, watched with an informer. The Gateway of a listener is taken from the listener
+// name, in the form "namespace/gateway/listener". Tags with an invalid name or value are ignored,
+// and reported in the log whenever the Gateway changes.
+type Annotations struct {
+	factory  dynamicinformer.DynamicSharedInformerFactory
+	gateways cache.GenericLister
+	synced   cache.InformerSynced
+	log      logging.LeveledLogger
+}
+
+// NewAnnotations creates a tagger using the given dynamic informer factory, which may be shared
+// with other components watching the Gateways.
+func NewAnnotations(factory dynamicinformer.DynamicSharedInformerFactory, log logging.LeveledLogger) (*Annotations, error) {
+	gateways := factory.ForResource(enricher.GatewayResource)
+	a := &Annotations{
+		factory:  factory,
+		gateways: gateways.Lister(),
+		synced:   gateways.Informer().HasSynced,
+		log:      log,
+	}
+
+	if _, err := gateways.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    a.check,
+		UpdateFunc: func(_, obj any) { a.check(obj) },
+	}); err != nil {
+		return nil, fmt.Errorf("cannot watch Gateways: %w", err)
+	}
+
+	return a, nil
+}
+
+// Start starts watching the Gateways until the context is canceled, and waits for the initial
+// list of the Gateways. Starting a shared informer factory again is a no-op.
+func (a *Annotations) Start(ctx context.Context) error {
+	a.factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), a.synced) {
+		return fmt.Errorf("cannot sync Gateway informer: %w", ctx.Err())
+	}
+	return nil
+}
+
+// Tags returns the tags of a listener from the annotations of its Gateway.
+func (a *Annotations) Tags(l *stnrv1.ListenerConfig) map[string]string {
+	ret := map[string]string{}
+	tokens := strings.Split(l.Name, "/")
+	if len(tokens) != 3 {
+		return ret
+	}
+
+	obj, err := a.gateways.ByNamespace(tokens[0]).Get(tokens[1])
+	if err != nil {
+		return ret
+	}
+	gw, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return ret
+	}
+	tags, _ := annotationTags(gw)
+	return tags
+}
+
+// check reports the invalid tags in the annotations of a Gateway.
+func (a *Annotations) check(obj any) {
+	gw, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	if _, errs := annotationTags(gw); len(errs) > 0 {
+		a.log.Warnf("Ignoring invalid tags in the annotations of Gateway %s/%s: %s",
+			gw.GetNamespace(), gw.GetName(), errors.Join(errs...).Error())
+	}
+}
+
+// annotationTags returns the valid tags in the annotations of a Gateway, and the errors for the
+// invalid ones.
+func annotationTags(gw *unstructured.Unstructured) (map[string]string, []error) {
+	ret, errs := map[string]string{}, []error{}
+	for k, v := range gw.GetAnnotations() {
+		name, ok := strings.CutPrefix(k, AnnotationPrefix)
+		if !ok {
+			continue
+		}
+		if err := credentials.ValidateTag(name, v); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		ret[name] = v
+	}
+	return ret, errs
+}
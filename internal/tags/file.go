@@ -0,0 +1,86 @@
+// Package tags attaches tags to the STUNner listeners, to select listeners with the label selector
+// in the "selector" request parameter. Tags are loaded from a file, e.g., a mounted ConfigMap, or
+// from the annotations of the Gateways.
+package tags
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pion/logging"
+	"sigs.k8s.io/yaml"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+
+	"github.com/l7mp/stunner-auth-service/internal/filewatch"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+)
+
+// DefaultReloadInterval is the default interval for checking the tag file for changes.
+const DefaultReloadInterval = filewatch.DefaultReloadInterval
+
+// File implements the credentials.Tagger interface with the tag overlay loaded from a file. The
+// file is a YAML or JSON map from namespaces, Gateways in the form "namespace/gateway" or
+// listeners in the form "namespace/gateway/listener" to tags, e.g.:
+//
+//	stunner:
+//	  region: eu
+//	stunner/premium-gateway:
+//	  tier: premium
+type File struct {
+	file    string
+	watcher *filewatch.Watcher
+	overlay credentials.TagOverlay
+	lock    sync.RWMutex
+	log     logging.LeveledLogger
+}
+
+// NewFile loads the tag overlay from the given file.
+func NewFile(file string, log logging.LeveledLogger) (*File, error) {
+	f := &File{file: file, log: log}
+	w, err := filewatch.New(file, "tag file", f.load, log)
+	if err != nil {
+		return nil, err
+	}
+	f.watcher = w
+	return f, nil
+}
+
+// Start reloads the tag file whenever its content changes, until the context is canceled. If the
+// new tags are invalid, the last valid tags remain in effect.
+func (f *File) Start(ctx context.Context, interval time.Duration) {
+	f.watcher.Start(ctx, interval)
+}
+
+// Reload reloads the tag file if its content has changed.
+func (f *File) Reload() error {
+	return f.watcher.Reload()
+}
+
+// load parses and validates the content of the tag file and puts the tags in effect.
+func (f *File) load(b []byte) error {
+	overlay := credentials.TagOverlay{}
+	if err := yaml.UnmarshalStrict(b, &overlay); err != nil {
+		return fmt.Errorf("cannot parse tag file: %w", err)
+	}
+	if err := overlay.Validate(); err != nil {
+		return err
+	}
+
+	f.lock.Lock()
+	f.overlay = overlay
+	f.lock.Unlock()
+
+	f.log.Infof("Loaded tag file %s: %d entries", f.file, len(overlay))
+
+	return nil
+}
+
+// Tags returns the tags of a listener.
+func (f *File) Tags(l *stnrv1.ListenerConfig) map[string]string {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.overlay.Tags(l)
+}
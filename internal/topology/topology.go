@@ -0,0 +1,229 @@
+// Package topology implements topology-aware listener selection: the end-client IP is mapped to
+// a region, and the Gateways of the region are preferred over, or selected instead of, the
+// Gateways of other regions.
+package topology
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/pion/logging"
+	"sigs.k8s.io/yaml"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+)
+
+// Mode decides how the region of the client affects the response.
+type Mode string
+
+const (
+	// ModeOrder returns the TURN URIs of the Gateways in the client region first.
+	ModeOrder Mode = "order"
+	// ModeFilter returns only the TURN URIs of the Gateways in the client region. Clients with
+	// an unknown region obtain all TURN URIs.
+	ModeFilter Mode = "filter"
+)
+
+// Region is a named set of Gateways and the client networks served by them.
+type Region struct {
+	// Name is the name of the region.
+	Name string `json:"name"`
+	// CIDRs lists the client networks in the region.
+	CIDRs []string `json:"cidrs,omitempty"`
+	// Countries lists the ISO 3166-1 country codes in the region, looked up in the GeoIP
+	// database.
+	Countries []string `json:"countries,omitempty"`
+	// Continents lists the continent codes (e.g., "EU") in the region, looked up in the GeoIP
+	// database.
+	Continents []string `json:"continents,omitempty"`
+	// Gateways lists the Gateways in the region, either as "namespace" for all Gateways in a
+	// namespace or as "namespace/gateway".
+	Gateways []string `json:"gateways"`
+}
+
+// Spec is the content of a topology file.
+type Spec struct {
+	// Mode is the selection mode. Default is ModeOrder.
+	Mode Mode `json:"mode,omitempty"`
+	// GeoIPDatabase is the path of a MaxMind-format country or city database (optional).
+	GeoIPDatabase string `json:"geoipDatabase,omitempty"`
+	// Regions lists the regions. Client networks are matched by longest prefix, and before
+	// countries and continents.
+	Regions []Region `json:"regions"`
+}
+
+type prefix struct {
+	prefix netip.Prefix
+	region string
+}
+
+// geoRecord is the part of a MaxMind database record used for region lookup.
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Continent struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"continent"`
+}
+
+// Topology maps end-client IPs to regions and implements the credentials.ListenerFilter and
+// credentials.ListenerScorer interfaces.
+type Topology struct {
+	mode       Mode
+	prefixes   []prefix
+	countries  map[string]string
+	continents map[string]string
+	gateways   map[string][]string
+	db         *maxminddb.Reader
+	log        logging.LeveledLogger
+}
+
+// New loads the topology from the given file.
+func New(file string, log logging.LeveledLogger) (*Topology, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read topology file: %w", err)
+	}
+
+	spec := Spec{}
+	if err := yaml.UnmarshalStrict(b, &spec); err != nil {
+		return nil, fmt.Errorf("cannot parse topology file: %w", err)
+	}
+
+	return NewFromSpec(spec, log)
+}
+
+// NewFromSpec creates a topology from a topology spec.
+func NewFromSpec(spec Spec, log logging.LeveledLogger) (*Topology, error) {
+	t := &Topology{
+		mode:       spec.Mode,
+		countries:  map[string]string{},
+		continents: map[string]string{},
+		gateways:   map[string][]string{},
+		log:        log,
+	}
+
+	switch t.mode {
+	case "":
+		t.mode = ModeOrder
+	case ModeOrder, ModeFilter:
+	default:
+		return nil, fmt.Errorf("invalid topology: unknown mode %q", spec.Mode)
+	}
+
+	for _, r := range spec.Regions {
+		if r.Name == "" {
+			return nil, errors.New("invalid topology: region name must be set")
+		}
+		if _, ok := t.gateways[r.Name]; ok {
+			return nil, fmt.Errorf("invalid topology: duplicate region %q", r.Name)
+		}
+		t.gateways[r.Name] = r.Gateways
+
+		for _, c := range r.CIDRs {
+			p, err := netip.ParsePrefix(c)
+			if err != nil {
+				return nil, fmt.Errorf("invalid topology: region %q: %w", r.Name, err)
+			}
+			t.prefixes = append(t.prefixes, prefix{prefix: p.Masked(), region: r.Name})
+		}
+		for _, c := range r.Countries {
+			t.countries[strings.ToUpper(c)] = r.Name
+		}
+		for _, c := range r.Continents {
+			t.continents[strings.ToUpper(c)] = r.Name
+		}
+	}
+
+	if spec.GeoIPDatabase != "" {
+		db, err := maxminddb.Open(spec.GeoIPDatabase)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open GeoIP database: %w", err)
+		}
+		t.db = db
+	}
+
+	return t, nil
+}
+
+// Close closes the GeoIP database, if any.
+func (t *Topology) Close() error {
+	if t.db == nil {
+		return nil
+	}
+	return t.db.Close()
+}
+
+// Region returns the region of the given IP address, or an empty string if unknown.
+func (t *Topology) Region(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+
+	region, bits := "", -1
+	for _, p := range t.prefixes {
+		if p.prefix.Contains(addr) && p.prefix.Bits() > bits {
+			region, bits = p.region, p.prefix.Bits()
+		}
+	}
+	if region != "" || t.db == nil {
+		return region
+	}
+
+	rec := geoRecord{}
+	if err := t.db.Lookup(net.IP(addr.AsSlice()), &rec); err != nil {
+		t.log.Debugf("GeoIP lookup failed for %s: %s", ip, err.Error())
+		return ""
+	}
+	if r, ok := t.countries[rec.Country.ISOCode]; ok {
+		return r
+	}
+	return t.continents[rec.Continent.Code]
+}
+
+// FilterListener excludes the listeners outside the client region in filter mode.
+func (t *Topology) FilterListener(req *credentials.Request, _ *stnrv1.StunnerConfig, l *stnrv1.ListenerConfig) error {
+	if t.mode != ModeFilter {
+		return nil
+	}
+
+	region := t.Region(req.ClientIP)
+	if region == "" || t.inRegion(region, l) {
+		return nil
+	}
+
+	return fmt.Errorf("listener outside client region %q", region)
+}
+
+// ScoreListener scores the listeners in the client region higher than other listeners.
+func (t *Topology) ScoreListener(req *credentials.Request, _ *stnrv1.StunnerConfig, l *stnrv1.ListenerConfig) int {
+	region := t.Region(req.ClientIP)
+	if region != "" && t.inRegion(region, l) {
+		return 1
+	}
+	return 0
+}
+
+func (t *Topology) inRegion(region string, l *stnrv1.ListenerConfig) bool {
+	tokens := strings.Split(l.Name, "/")
+	if len(tokens) != 3 {
+		return false
+	}
+
+	for _, gw := range t.gateways[region] {
+		if gw == tokens[0] || gw == tokens[0]+"/"+tokens[1] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,270 @@
+// Package webhook implements an external authorization webhook for credential requests.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pion/logging"
+
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+)
+
+const (
+	// DefaultTimeout is the default timeout for webhook calls.
+	DefaultTimeout = 2 * time.Second
+)
+
+var (
+	// ErrDenied is returned when the webhook denies a request.
+	ErrDenied = errors.New("denied by authorization webhook")
+	// ErrUnavailable is returned when the webhook cannot be reached or returns an invalid
+	// response and the webhook is configured to fail closed.
+	ErrUnavailable = errors.New("authorization webhook unavailable")
+)
+
+// Config is the webhook configuration.
+type Config struct {
+	// URL is the URL of the webhook.
+	URL string
+	// Timeout is the timeout for webhook calls. Default is DefaultTimeout.
+	Timeout time.Duration
+	// FailOpen allows requests when the webhook cannot be reached or returns an invalid
+	// response. Default is to deny such requests (fail closed).
+	FailOpen bool
+	// CacheTTL is the time webhook responses are cached for identical reviews. Reviews are
+	// identical if they differ only in the port of the remote address of the caller. Default is
+	// no caching.
+	CacheTTL time.Duration
+}
+
+// Review is the payload POSTed to the webhook.
+type Review struct {
+	// Request is the parsed credential request.
+	Request credentials.Request `json:"request"`
+	// Identity is the caller identity. Note that the user name is not verified.
+	Identity *credentials.Identity `json:"identity,omitempty"`
+	// Candidates is the list of the Gateways, in the form "namespace/gateway", that would be
+	// considered for the request.
+	Candidates []string `json:"candidates"`
+}
+
+// String returns a string representation of the review, with the API key of the caller redacted.
+func (r Review) String() string {
+	if r.Identity != nil && r.Identity.Key != "" {
+		id := *r.Identity
+		id.Key = "<redacted>"
+		r.Identity = &id
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+// Response is the webhook response. Unset fields leave the corresponding request parameter
+// intact.
+type Response struct {
+	// Allowed decides whether credentials should be issued.
+	Allowed bool `json:"allowed"`
+	// Reason is a human-readable explanation of the decision (optional).
+	Reason string `json:"reason,omitempty"`
+	// Username overrides the user id in the request.
+	Username *string `json:"username,omitempty"`
+	// TTL overrides the credential lifetime, in seconds. Must not be negative.
+	TTL *int `json:"ttl,omitempty"`
+	// Namespace overrides the namespace filter.
+	Namespace *string `json:"namespace,omitempty"`
+	// Gateway overrides the gateway filter.
+	Gateway *string `json:"gateway,omitempty"`
+	// Listener overrides the listener filter.
+	Listener *string `json:"listener,omitempty"`
+}
+
+type cacheEntry struct {
+	response Response
+	expiry   time.Time
+}
+
+// Webhook is an external authorization webhook client.
+type Webhook struct {
+	config Config
+	client *http.Client
+	cache  map[string]cacheEntry
+	lock   sync.Mutex
+	log    logging.LeveledLogger
+}
+
+// New creates a new webhook client.
+func New(config Config, log logging.LeveledLogger) (*Webhook, error) {
+	if config.URL == "" {
+		return nil, errors.New("webhook URL must be set")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	return &Webhook{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+		cache:  map[string]cacheEntry{},
+		log:    log,
+	}, nil
+}
+
+// Authorize sends the review to the webhook and applies the overrides from the response to the
+// request. Returns ErrDenied if the webhook denies the request and ErrUnavailable if the webhook
+// call fails and the webhook is configured to fail closed.
+func (w *Webhook) Authorize(ctx context.Context, req *credentials.Request, candidates []string) error {
+	review := Review{Request: *req, Identity: req.Identity, Candidates: candidates}
+
+	body, err := json.Marshal(review)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUnavailable, err.Error())
+	}
+
+	key, err := cacheKey(review)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUnavailable, err.Error())
+	}
+	resp, ok := w.lookup(key)
+	if !ok {
+		w.log.Tracef("Calling authorization webhook at %s: %s", w.config.URL, review.String())
+		resp, err = w.call(ctx, body)
+		if err != nil {
+			if w.config.FailOpen {
+				w.log.Warnf("Authorization webhook call failed, allowing request (fail-open): %s",
+					err.Error())
+				return nil
+			}
+			w.log.Errorf("Authorization webhook call failed, denying request (fail-closed): %s",
+				err.Error())
+			return fmt.Errorf("%w: %s", ErrUnavailable, err.Error())
+		}
+		w.store(key, resp)
+	}
+
+	if !resp.Allowed {
+		if resp.Reason != "" {
+			return fmt.Errorf("%w: %s", ErrDenied, resp.Reason)
+		}
+		return ErrDenied
+	}
+
+	if resp.Username != nil {
+		req.Username = *resp.Username
+	}
+	if resp.TTL != nil {
+		req.TTL = time.Duration(*resp.TTL) * time.Second
+	}
+	if resp.Namespace != nil {
+		req.Namespace = *resp.Namespace
+	}
+	if resp.Gateway != nil {
+		req.Gateway = *resp.Gateway
+	}
+	if resp.Listener != nil {
+		req.Listener = *resp.Listener
+	}
+
+	return nil
+}
+
+func (w *Webhook) call(ctx context.Context, body []byte) (Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.config.Timeout)
+	defer cancel()
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return Response{}, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(r)
+	if err != nil {
+		return Response{}, err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return Response{}, fmt.Errorf("webhook returned HTTP status %d", resp.StatusCode)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Response{}, err
+	}
+
+	ret := Response{}
+	if err := json.Unmarshal(b, &ret); err != nil {
+		return Response{}, fmt.Errorf("invalid webhook response: %w", err)
+	}
+	if ret.TTL != nil && *ret.TTL < 0 {
+		return Response{}, fmt.Errorf("invalid webhook response: negative TTL %d", *ret.TTL)
+	}
+
+	w.log.Debugf("Authorization webhook response: %s", string(b))
+
+	return ret, nil
+}
+
+func (w *Webhook) lookup(key string) (Response, bool) {
+	if w.config.CacheTTL <= 0 {
+		return Response{}, false
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	e, ok := w.cache[key]
+	if !ok || time.Now().After(e.expiry) {
+		return Response{}, false
+	}
+	return e.response, true
+}
+
+func (w *Webhook) store(key string, resp Response) {
+	if w.config.CacheTTL <= 0 {
+		return
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	now := time.Now()
+	for k, e := range w.cache {
+		if now.After(e.expiry) {
+			delete(w.cache, k)
+		}
+	}
+	w.cache[key] = cacheEntry{response: resp, expiry: now.Add(w.config.CacheTTL)}
+}
+
+// cacheKey returns the cache key of a review. The port of the remote address of the caller is
+// omitted: it changes with each connection but is irrelevant for authorization.
+func cacheKey(review Review) (string, error) {
+	if review.Identity != nil {
+		id := *review.Identity
+		if host, _, err := net.SplitHostPort(id.RemoteAddr); err == nil {
+			id.RemoteAddr = host
+		}
+		review.Identity = &id
+	}
+	body, err := json.Marshal(review)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(body)
+	return hex.EncodeToString(h[:]), nil
+}
@@ -4,23 +4,43 @@ import (
 	"context"
 	"fmt"
 	golog "log"
+	"maps"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
 
 	"github.com/pion/logging"
 	flag "github.com/spf13/pflag"
 	cliopt "k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/kubernetes"
 
 	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
 	cdsclient "github.com/l7mp/stunner/pkg/config/client"
 	"github.com/l7mp/stunner/pkg/logger"
 
+	"github.com/l7mp/stunner-auth-service/internal/admin"
+	"github.com/l7mp/stunner-auth-service/internal/canary"
 	"github.com/l7mp/stunner-auth-service/internal/config"
+	k8senricher "github.com/l7mp/stunner-auth-service/internal/enricher"
 	"github.com/l7mp/stunner-auth-service/internal/handler"
+	"github.com/l7mp/stunner-auth-service/internal/health"
+	"github.com/l7mp/stunner-auth-service/internal/maintenance"
+	"github.com/l7mp/stunner-auth-service/internal/nodes"
+	"github.com/l7mp/stunner-auth-service/internal/overrides"
+	"github.com/l7mp/stunner-auth-service/internal/policy"
+	"github.com/l7mp/stunner-auth-service/internal/selftest"
+	"github.com/l7mp/stunner-auth-service/internal/tags"
+	"github.com/l7mp/stunner-auth-service/internal/topology"
+	"github.com/l7mp/stunner-auth-service/internal/webhook"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
 	"github.com/l7mp/stunner-auth-service/pkg/server"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
 )
 
 type httpLogWriter struct {
@@ -32,16 +52,101 @@ func (l *httpLogWriter) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
+// k8sClients creates the Kubernetes clients and the informer factory for watching the Gateways
+// from the Kubernetes config flags on first use, so that all components share them.
+type k8sClients struct {
+	flags      *cliopt.ConfigFlags
+	client     kubernetes.Interface
+	dynClient  dynamic.Interface
+	dynFactory dynamicinformer.DynamicSharedInformerFactory
+}
+
+// clients returns the Kubernetes clients.
+func (c *k8sClients) clients() (kubernetes.Interface, dynamic.Interface, error) {
+	if c.client != nil {
+		return c.client, c.dynClient, nil
+	}
+
+	restConfig, err := c.flags.ToRESTConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	dynClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.client, c.dynClient = client, dynClient
+	return client, dynClient, nil
+}
+
+// gatewayInformerFactory returns the shared informer factory for watching the Gateways.
+func (c *k8sClients) gatewayInformerFactory() (dynamicinformer.DynamicSharedInformerFactory, error) {
+	if c.dynFactory != nil {
+		return c.dynFactory, nil
+	}
+	_, dynClient, err := c.clients()
+	if err != nil {
+		return nil, err
+	}
+	c.dynFactory = k8senricher.NewGatewayInformerFactory(dynClient)
+	return c.dynFactory, nil
+}
+
 func main() {
 	os.Args[0] = "authd"
+	if len(os.Args) > 1 && os.Args[1] == "selftest" {
+		os.Exit(runSelfTest(os.Args[2:]))
+	}
+
 	port := flag.IntP("port", "p", stnrv1.DefaultAuthServicePort,
 		fmt.Sprintf("HTTP port (default: %d)", stnrv1.DefaultAuthServicePort))
 	level := flag.StringP("log", "l", "", "Log level (format: <scope>:<level>, overrides: PION_LOG_*, default: all:INFO)")
 	verbose := flag.BoolP("verbose", "v", false, "Verbose logging, identical to <-l all:DEBUG>")
+	restrictNamespace := flag.String("restrict-namespace", "", "Serve credentials only for the Gateways in the given namespace (default: all namespaces)")
+	excludeProtocols := flag.StringSlice("exclude-protocol", []string{}, "Never return TURN URIs for listeners with the given protocols, e.g., turn-dtls (can be repeated)")
+	webhookURL := flag.String("webhook-url", "", "URL of an external authorization webhook to call before issuing credentials (default: no webhook)")
+	webhookTimeout := flag.Duration("webhook-timeout", webhook.DefaultTimeout, "Timeout for authorization webhook calls")
+	webhookFailOpen := flag.Bool("webhook-fail-open", false, "Allow requests when the authorization webhook is unavailable (default: deny)")
+	webhookCacheTTL := flag.Duration("webhook-cache-ttl", 0, "Cache authorization webhook responses for the given duration (default: no caching)")
+	policyFile := flag.String("policy-file", "", "Path of a CEL policy file to authorize requests and select listeners, reloaded on change (default: no policy)")
+	selection := flag.String("selection", string(credentials.SelectFirst), "Default policy to select the TURN server for TURN REST API requests when multiple Gateways match (first, priority, round-robin, weighted or hash)")
+	selectionPriority := flag.StringSlice("selection-priority", []string{}, "STUNner config names in decreasing order of priority, for the priority selection policy (can be repeated)")
+	selectionWeight := flag.StringToInt("selection-weight", map[string]int{}, "Weights of STUNner configs in the form <name>=<weight>, for the weighted selection policy (default weight: 1)")
+	topologyFile := flag.String("topology-file", "", "Path of a topology file mapping client IPs to regions and regions to Gateways (default: no topology-aware selection)")
+	trustedProxies := flag.StringSlice("trusted-proxy", []string{}, "CIDR of a proxy trusted to set the X-Forwarded-For header (can be repeated)")
+	adminAddr := flag.String("admin-addr", "", "Address to serve the admin HTTP API at, e.g., 127.0.0.1:8089 (default: admin API disabled)")
+	adminTokenFile := flag.String("admin-token-file", "", "Path of a file holding the bearer tokens accepted by the admin HTTP API, one per line (required with --admin-addr)")
+	adminConfigOverride := flag.Bool("admin-config-override", false, "Allow setting and deleting manual override STUNner configs on the admin HTTP API (requires --admin-token-file)")
+	maintenanceFile := flag.String("maintenance-file", "", "Path of the file to persist the Gateway maintenance state to (default: maintenance state is not persisted)")
+	trafficSplits := flag.StringArray("traffic-split", []string{}, `Traffic split between Gateways in the form "namespace/gateway-a: 95%, namespace/gateway-b: 5%" (can be repeated)`)
+	healthProbe := flag.String("health-probe", "", "Probe the listeners with STUN Binding requests and exclude or demote the unhealthy ones (exclude or demote, default: no probing)")
+	healthProbeInterval := flag.Duration("health-probe-interval", health.DefaultInterval, "Time between two health probes of a listener")
+	healthProbeTimeout := flag.Duration("health-probe-timeout", health.DefaultTimeout, "Time to wait for the response to a health probe")
+	healthProbeThreshold := flag.Int("health-probe-threshold", health.DefaultThreshold, "Number of consecutive failed health probes after which a listener is considered unhealthy")
+	overrideFile := flag.String("address-override-file", "", "Path of a file, e.g., a mounted ConfigMap, mapping listeners, Gateways or protocols to public addresses and ports, reloaded on change (default: no overrides)")
+	unroutable := flag.StringToString("unroutable", map[string]string{}, "Handling of TURN URIs with unroutable addresses in the form <class>=<action>, with class unspecified, loopback, link-local or private and action allow, drop, flag or fallback (default: allow all)")
+	fallbackAddr := flag.String("unroutable-fallback-addr", "", "Address to substitute for unroutable addresses with the fallback action")
+	restrictPublicAddr := flag.Bool("restrict-public-addr", false, `Reject the "public-addr" request parameter unless the caller or the address is allowed (implied by the --public-addr-allow-* flags)`)
+	publicAddrKeys := flag.StringSlice("public-addr-allow-key", []string{}, `API key authorized to set the "public-addr" request parameter (can be repeated)`)
+	publicAddrCIDRs := flag.StringSlice("public-addr-allow-cidr", []string{}, `CIDR the "public-addr" request parameter may be set to (can be repeated)`)
+	publicAddrDomains := flag.StringSlice("public-addr-allow-domain", []string{}, `Domain the "public-addr" request parameter may be set to, including subdomains (can be repeated)`)
+	enrich := flag.Bool("enrich-public-addresses", false, "Fill in the missing public addresses of listeners from the LoadBalancer Service or the status of the Gateway")
+	tagFile := flag.String("tag-file", "", `Path of a file, e.g., a mounted ConfigMap, mapping namespaces, Gateways or listeners to tags for the "selector" request parameter, reloaded on change (default: no tags)`)
+	tagAnnotations := flag.Bool("tags-from-annotations", false, "Tag the listeners of each Gateway with the Gateway annotations prefixed with "+tags.AnnotationPrefix+` for the "selector" request parameter`)
+	explain := flag.Bool("explain", false, `Enable the "explain" request parameter, which returns the decisions made while generating the response instead of credentials; reveals the STUNner configs to the clients`)
+	certHostname := flag.Bool("turns-hostname-from-cert", false, "Use the first DNS name in the SAN of the listener certificate as the public address of TLS and DTLS listeners with no hostname in the address overrides")
+	resolveNodes := flag.Bool("resolve-node-addresses", false, "Resolve the node address placeholder of listeners with relay address discovery to the Kubernetes node addresses, ExternalIP first, then InternalIP")
+	nodeSelection := flag.String("node-selection", "all", "Policy to pick the node for the resolved node address placeholders (all: a TURN URI for each node, first, round-robin or hash on the client IP)")
+	icePolicy := flag.String("ice-transport-policy", "", "Override the ICE transport policy in all ICE configs (all, public or relay, default: use the policy from the request)")
 
 	// Kubernetes config flags
 	k8sFlags := cliopt.NewConfigFlags(true)
 	k8sFlags.AddFlags(flag.CommandLine)
+	k8s := &k8sClients{flags: k8sFlags}
 
 	// CDS server discovery flags
 	cdsFlags := cdsclient.NewCDSConfigFlags()
@@ -92,13 +197,275 @@ func main() {
 		os.Exit(1)
 	}
 
+	opts := []handler.Option{}
+	if *restrictNamespace != "" {
+		log.Infof("Restricting requests to namespace %q", *restrictNamespace)
+		opts = append(opts, handler.WithRequestAuthorizer(handler.ForceNamespace(*restrictNamespace)))
+	}
+	if len(*excludeProtocols) > 0 {
+		f, err := credentials.ExcludeProtocols(*excludeProtocols...)
+		if err != nil {
+			log.Errorf("Invalid excluded protocol: %s", err.Error())
+			os.Exit(1)
+		}
+		log.Infof("Excluding listener protocols %v", *excludeProtocols)
+		opts = append(opts, handler.WithListenerFilter(f))
+	}
+	if *policyFile != "" {
+		log.Infof("Using policy file %s", *policyFile)
+		p, err := policy.New(*policyFile, loggerFactory.NewLogger("policy"))
+		if err != nil {
+			log.Errorf("Could not load policy file: %s", err.Error())
+			os.Exit(1)
+		}
+		p.Start(ctx, policy.DefaultReloadInterval)
+		opts = append(opts, handler.WithListenerFilter(p))
+	}
+	selectionPolicy, err := credentials.NewSelectionPolicy(*selection)
+	if err != nil {
+		log.Errorf("Invalid selection policy: %s", err.Error())
+		os.Exit(1)
+	}
+	log.Infof("Using TURN server selection policy %q", selectionPolicy)
+	opts = append(opts, handler.WithSelector(&credentials.Selector{
+		Policy:     selectionPolicy,
+		Priorities: *selectionPriority,
+		Weights:    *selectionWeight,
+	}))
+	if *topologyFile != "" {
+		log.Infof("Using topology file %s", *topologyFile)
+		t, err := topology.New(*topologyFile, loggerFactory.NewLogger("topology"))
+		if err != nil {
+			log.Errorf("Could not load topology file: %s", err.Error())
+			os.Exit(1)
+		}
+		defer t.Close() //nolint:errcheck
+		opts = append(opts, handler.WithListenerFilter(t), handler.WithListenerScorer(t))
+	}
+	for _, c := range *trustedProxies {
+		p, err := netip.ParsePrefix(c)
+		if err != nil {
+			log.Errorf("Invalid trusted proxy CIDR %q: %s", c, err.Error())
+			os.Exit(1)
+		}
+		opts = append(opts, handler.WithTrustedProxies(p))
+	}
+	if *adminAddr != "" && *adminTokenFile == "" {
+		log.Error("The admin API requires authentication: set --admin-token-file")
+		os.Exit(1)
+	}
+	maintenanceStore, err := maintenance.New(*maintenanceFile, loggerFactory.NewLogger("maintenance"))
+	if err != nil {
+		log.Errorf("Could not load maintenance state: %s", err.Error())
+		os.Exit(1)
+	}
+	opts = append(opts, handler.WithListenerFilter(maintenanceStore))
+	adminComponents := []admin.Registrar{maintenanceStore}
+	if *overrideFile != "" {
+		log.Infof("Using address override file %s", *overrideFile)
+		o, err := overrides.New(*overrideFile, loggerFactory.NewLogger("overrides"))
+		if err != nil {
+			log.Errorf("Could not load address override file: %s", err.Error())
+			os.Exit(1)
+		}
+		o.Start(ctx, overrides.DefaultReloadInterval)
+		opts = append(opts, handler.WithAddressOverrider(o))
+	}
+	if *resolveNodes {
+		var selector *credentials.Selector
+		switch p := credentials.SelectionPolicy(*nodeSelection); p {
+		case "all":
+		case credentials.SelectFirst, credentials.SelectRoundRobin, credentials.SelectHash:
+			selector = &credentials.Selector{Policy: p}
+		default:
+			log.Errorf("Invalid node selection policy: %q", *nodeSelection)
+			os.Exit(1)
+		}
+		k8sClient, _, err := k8s.clients()
+		if err != nil {
+			log.Errorf("Could not create Kubernetes client: %s", err.Error())
+			os.Exit(1)
+		}
+		log.Infof("Resolving node address placeholders (node selection: %s)", *nodeSelection)
+		n := nodes.New(k8sClient, loggerFactory.NewLogger("nodes"))
+		if err := n.Start(ctx); err != nil {
+			log.Errorf("Could not watch Kubernetes nodes: %s", err.Error())
+			os.Exit(1)
+		}
+		opts = append(opts, handler.WithNodeAddresser(n, selector))
+		adminComponents = append(adminComponents, n)
+	}
+	if *enrich {
+		k8sClient, _, err := k8s.clients()
+		if err != nil {
+			log.Errorf("Could not create Kubernetes client: %s", err.Error())
+			os.Exit(1)
+		}
+		gwFactory, err := k8s.gatewayInformerFactory()
+		if err != nil {
+			log.Errorf("Could not create Kubernetes client: %s", err.Error())
+			os.Exit(1)
+		}
+		log.Info("Filling in missing public addresses from Gateway Services and Gateway status")
+		e := k8senricher.New(k8sClient, gwFactory, loggerFactory.NewLogger("enricher"))
+		if err := e.Start(ctx); err != nil {
+			log.Errorf("Could not watch Services and Gateways: %s", err.Error())
+			os.Exit(1)
+		}
+		opts = append(opts, handler.WithAddressEnricher(e))
+	}
+	if *tagAnnotations {
+		gwFactory, err := k8s.gatewayInformerFactory()
+		if err != nil {
+			log.Errorf("Could not create Kubernetes client: %s", err.Error())
+			os.Exit(1)
+		}
+		log.Info("Using tags from Gateway annotations")
+		a, err := tags.NewAnnotations(gwFactory, loggerFactory.NewLogger("tags"))
+		if err != nil {
+			log.Errorf("Could not watch Gateways: %s", err.Error())
+			os.Exit(1)
+		}
+		if err := a.Start(ctx); err != nil {
+			log.Errorf("Could not watch Gateways: %s", err.Error())
+			os.Exit(1)
+		}
+		opts = append(opts, handler.WithTagger(a))
+	}
+	if *tagFile != "" {
+		log.Infof("Using tag file %s", *tagFile)
+		f, err := tags.NewFile(*tagFile, loggerFactory.NewLogger("tags"))
+		if err != nil {
+			log.Errorf("Could not load tag file: %s", err.Error())
+			os.Exit(1)
+		}
+		f.Start(ctx, tags.DefaultReloadInterval)
+		opts = append(opts, handler.WithTagger(f))
+	}
+	if *explain {
+		log.Warn(`Explain mode enabled: the "explain" request parameter reveals the STUNner configs`)
+		opts = append(opts, handler.WithExplain())
+	}
+	if *adminConfigOverride {
+		if *adminTokenFile == "" {
+			log.Error("Manual override configs require an authenticated admin API: set --admin-token-file")
+			os.Exit(1)
+		}
+		log.Warn("Manual override configs enabled on the admin API")
+		opts = append(opts, handler.WithConfigOverride())
+	}
+	if *certHostname {
+		log.Info("Using the hostname in the listener certificates for TLS and DTLS listeners")
+		opts = append(opts, handler.WithCertHostname())
+	}
+	var prober *health.Prober
+	if *healthProbe != "" {
+		log.Infof("Probing listener health (mode: %s)", *healthProbe)
+		prober, err = health.New(health.Config{
+			Mode:      health.Mode(*healthProbe),
+			Interval:  *healthProbeInterval,
+			Timeout:   *healthProbeTimeout,
+			Threshold: *healthProbeThreshold,
+		}, loggerFactory.NewLogger("health"))
+		if err != nil {
+			log.Errorf("Could not create health prober: %s", err.Error())
+			os.Exit(1)
+		}
+		opts = append(opts, handler.WithListenerFilter(prober), handler.WithListenerScorer(prober))
+		adminComponents = append(adminComponents, prober)
+	}
+	if len(*trafficSplits) > 0 {
+		splits := []canary.Split{}
+		for _, spec := range *trafficSplits {
+			split, err := canary.ParseSplit(spec)
+			if err != nil {
+				log.Errorf("Invalid traffic split: %s", err.Error())
+				os.Exit(1)
+			}
+			log.Infof("Using traffic split %s", spec)
+			splits = append(splits, split)
+		}
+		c, err := canary.New(splits, loggerFactory.NewLogger("canary"))
+		if err != nil {
+			log.Errorf("Invalid traffic split: %s", err.Error())
+			os.Exit(1)
+		}
+		opts = append(opts, handler.WithRequestObserver(c), handler.WithListenerFilter(c))
+	}
+	if len(*unroutable) > 0 {
+		p, err := credentials.NewUnroutablePolicy(*unroutable)
+		if err != nil {
+			log.Errorf("Invalid unroutable address policy: %s", err.Error())
+			os.Exit(1)
+		}
+		if slices.Contains(slices.Collect(maps.Values(p)), credentials.UnroutableFallback) && *fallbackAddr == "" {
+			log.Error("Invalid unroutable address policy: fallback action requires --unroutable-fallback-addr")
+			os.Exit(1)
+		}
+		if *fallbackAddr != "" {
+			if err := credentials.ValidateFallbackAddr(*fallbackAddr); err != nil {
+				log.Errorf("Invalid unroutable address policy: %s", err.Error())
+				os.Exit(1)
+			}
+		}
+		log.Infof("Using unroutable address policy %v", *unroutable)
+		opts = append(opts, handler.WithUnroutablePolicy(p, *fallbackAddr))
+	}
+	if *restrictPublicAddr || len(*publicAddrKeys) > 0 || len(*publicAddrCIDRs) > 0 ||
+		len(*publicAddrDomains) > 0 {
+		p := handler.PublicAddrPolicy{
+			Keys:    *publicAddrKeys,
+			Domains: *publicAddrDomains,
+			Audit:   loggerFactory.NewLogger("audit"),
+		}
+		for _, c := range *publicAddrCIDRs {
+			prefix, err := netip.ParsePrefix(c)
+			if err != nil {
+				log.Errorf("Invalid public address CIDR %q: %s", c, err.Error())
+				os.Exit(1)
+			}
+			p.CIDRs = append(p.CIDRs, prefix)
+		}
+		log.Infof("Restricting public address overrides: keys: %d, CIDRs: %v, domains: %v",
+			len(p.Keys), *publicAddrCIDRs, p.Domains)
+		opts = append(opts, handler.WithPublicAddrPolicy(p))
+	}
+	if *icePolicy != "" {
+		switch p := types.IceTransportPolicy(*icePolicy); p {
+		case types.All, types.Public, types.Relay:
+		default:
+			log.Errorf("Invalid ICE transport policy: %q", p)
+			os.Exit(1)
+		}
+		log.Infof("Overriding ICE transport policy with %q", *icePolicy)
+		opts = append(opts, handler.WithResponseMutator(
+			credentials.ForceIceTransportPolicy(types.IceTransportPolicy(*icePolicy))))
+	}
+	if *webhookURL != "" {
+		log.Infof("Using authorization webhook at %s", *webhookURL)
+		wh, err := webhook.New(webhook.Config{
+			URL:      *webhookURL,
+			Timeout:  *webhookTimeout,
+			FailOpen: *webhookFailOpen,
+			CacheTTL: *webhookCacheTTL,
+		}, loggerFactory.NewLogger("webhook"))
+		if err != nil {
+			log.Errorf("Could not create authorization webhook: %s", err.Error())
+			os.Exit(1)
+		}
+		opts = append(opts, handler.WithWebhook(wh))
+	}
+
 	log.Info("Starting auth request handler")
-	handler, err := handler.NewHandler(conf, loggerFactory.NewLogger("auth-svc"))
+	handler, err := handler.NewHandler(conf, loggerFactory.NewLogger("auth-svc"), opts...)
 	if err != nil {
 		log.Errorf("Could not start authentication server: %s", err.Error())
 		os.Exit(1)
 	}
 	handler.Start(ctx)
+	if prober != nil {
+		prober.Start(ctx, handler.Configs, handler.Options)
+	}
 
 	router := server.HandlerWithOptions(handler, server.GorillaServerOptions{})
 
@@ -123,6 +490,37 @@ func main() {
 		}
 	}()
 
+	if *adminAddr != "" {
+		adminComponents = append(adminComponents, handler, selftest.New(router, handler.Configs, 0, loggerFactory))
+		adminRouter := admin.New(loggerFactory.NewLogger("admin"), adminComponents...)
+		tokens, err := admin.LoadTokens(*adminTokenFile)
+		if err != nil {
+			log.Errorf("Could not load admin API tokens: %s", err.Error())
+			os.Exit(1)
+		}
+		adminRouter.RequireToken(tokens...)
+
+		log.Infof("Starting admin HTTP server at %s", *adminAddr)
+		adminSrv := &http.Server{
+			Addr:     *adminAddr,
+			Handler:  adminRouter,
+			ErrorLog: golog.New(&httpLogWriter{loggerFactory.NewLogger("admin-server")}, "", 0),
+		}
+		defer adminSrv.Close() //nolint:errcheck
+
+		ac, err := net.Listen("tcp", *adminAddr)
+		if err != nil {
+			log.Errorf("Could not open admin server socket: %s", err.Error())
+			os.Exit(1)
+		}
+		go func() {
+			if err = adminSrv.Serve(ac); err != nil {
+				log.Errorf("Admin HTTP server error: %s", err.Error())
+				os.Exit(1)
+			}
+		}()
+	}
+
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+	a12n "github.com/l7mp/stunner/pkg/authentication"
+	"github.com/l7mp/stunner/pkg/logger"
+
+	"github.com/l7mp/stunner-auth-service/internal/admin"
+	"github.com/l7mp/stunner-auth-service/internal/handler"
+	"github.com/l7mp/stunner-auth-service/internal/maintenance"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+func adminRequest(s *admin.Server, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "http://example.com"+path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+var iceMaintenanceTestCases = []iceAuthTestCase{
+	{
+		name:   "maintenance - gateway draining, listener disabled",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			uris := *(*iceConfig.IceServers)[0].Urls
+			assert.Equal(t, []string{"turn:1.2.3.4:3478?transport=tcp"}, uris, "URIs")
+		},
+	},
+	{
+		name:   "maintenance - draining listeners excluded, disabled listener refused",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&namespace=testnamespace",
+		status: http.StatusForbidden,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	},
+	{
+		name:   "maintenance - draining gateway requested explicitly",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&namespace=testnamespace&gateway=testgateway",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			uris := *(*iceConfig.IceServers)[0].Urls
+			assert.Equal(t, []string{"turn:1.2.3.4:3478?transport=udp",
+				"turns:127.0.0.1:3479?transport=udp"}, uris, "URIs")
+		},
+	},
+	{
+		name:   "maintenance - draining gateway matched by pattern",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&namespace=testnamespace&gateway=test*",
+		status: http.StatusNotFound,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	},
+	{
+		name:   "maintenance - disabled listener requested explicitly",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&namespace=testnamespace&gateway=dummygateway&listener=tls",
+		status: http.StatusForbidden,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	},
+}
+
+var turnMaintenanceTestCases = []turnAuthTestCase{
+	{
+		name:   "maintenance - draining gateway requested explicitly",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&namespace=testnamespace&gateway=testgateway",
+		status: 200,
+		tester: func(t *testing.T, turnAuth *types.TurnAuthenticationToken, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{"turn:1.2.3.4:3478?transport=udp",
+				"turns:127.0.0.1:3479?transport=udp"}, *turnAuth.Uris, "URIs")
+		},
+	},
+	{
+		name:   "maintenance - disabled listener requested explicitly",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&namespace=testnamespace&gateway=dummygateway&listener=tls",
+		status: http.StatusForbidden,
+		tester: func(t *testing.T, turnAuth *types.TurnAuthenticationToken, authHandler a12n.AuthHandler) {},
+	},
+}
+
+func TestMaintenance(t *testing.T) {
+	loggerFactory := logger.NewLoggerFactory(authTestLoglevel)
+	file := filepath.Join(t.TempDir(), "maintenance.json")
+
+	store, err := maintenance.New(file, loggerFactory.NewLogger("maintenance"))
+	assert.NoError(t, err, "create store")
+	s := admin.New(loggerFactory.NewLogger("admin"), store)
+
+	// mark targets
+	w := adminRequest(s, "PUT", "/maintenance/testnamespace/testgateway",
+		`{"mode":"draining","reason":"upgrade"}`)
+	assert.Equal(t, http.StatusOK, w.Code, "drain gateway")
+	w = adminRequest(s, "PUT", "/maintenance/testnamespace/dummygateway/tls", `{"mode":"disabled","ttl":3600}`)
+	assert.Equal(t, http.StatusOK, w.Code, "disable listener")
+	w = adminRequest(s, "PUT", "/maintenance/dummynamespace/testgateway/tcp",
+		`{"mode":"disabled","expiry":"`+time.Now().Add(50*time.Millisecond).Format(time.RFC3339Nano)+`"}`)
+	assert.Equal(t, http.StatusOK, w.Code, "disable listener with short expiry")
+
+	// invalid requests
+	w = adminRequest(s, "PUT", "/maintenance/testnamespace/testgateway", `{"mode":"dummy"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "invalid mode")
+	w = adminRequest(s, "PUT", "/maintenance/a/b/c/d", `{"mode":"draining"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "invalid target")
+	w = adminRequest(s, "PUT", "/maintenance/testnamespace", `{"mode":"draining","ttl":-10}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "expiry in the past")
+
+	// wait until the short entry expires
+	time.Sleep(100 * time.Millisecond)
+
+	w = adminRequest(s, "GET", "/maintenance", "")
+	assert.Equal(t, http.StatusOK, w.Code, "list")
+	entries := []maintenance.Entry{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &entries), "decode")
+	assert.Len(t, entries, 2, "entries")
+	assert.Equal(t, "testnamespace/dummygateway/tls", entries[0].Target, "target")
+	assert.Equal(t, maintenance.ModeDisabled, entries[0].Mode, "mode")
+	assert.NotNil(t, entries[0].Expiry, "expiry")
+	assert.Equal(t, "testnamespace/testgateway", entries[1].Target, "target")
+	assert.Equal(t, maintenance.ModeDraining, entries[1].Mode, "mode")
+	assert.Equal(t, "upgrade", entries[1].Reason, "reason")
+
+	testICE(t, iceMaintenanceTestCases, handler.WithListenerFilter(store))
+	testTURNAuth(t, turnMaintenanceTestCases, handler.WithListenerFilter(store))
+
+	// state survives a restart
+	store, err = maintenance.New(file, loggerFactory.NewLogger("maintenance"))
+	assert.NoError(t, err, "reload store")
+	assert.Len(t, store.List(), 2, "entries after restart")
+	s = admin.New(loggerFactory.NewLogger("admin"), store)
+
+	// back in service
+	w = adminRequest(s, "DELETE", "/maintenance/testnamespace/testgateway", "")
+	assert.Equal(t, http.StatusNoContent, w.Code, "delete")
+	w = adminRequest(s, "DELETE", "/maintenance/testnamespace/testgateway", "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "delete again")
+
+	store, err = maintenance.New(file, loggerFactory.NewLogger("maintenance"))
+	assert.NoError(t, err, "reload store")
+	assert.Len(t, store.List(), 1, "entries after delete")
+	_, ok := store.Lookup("testnamespace/testgateway/udp")
+	assert.False(t, ok, "gateway back in service")
+	_, ok = store.Lookup("testnamespace/dummygateway/tls")
+	assert.True(t, ok, "listener still disabled")
+}
+
+func TestAdminToken(t *testing.T) {
+	loggerFactory := logger.NewLoggerFactory(authTestLoglevel)
+
+	file := filepath.Join(t.TempDir(), "tokens")
+	assert.NoError(t, os.WriteFile(file, []byte("# admin tokens\ntoken-1\n\n  token-2  \n"), 0o600))
+	tokens, err := admin.LoadTokens(file)
+	assert.NoError(t, err, "load tokens")
+	assert.Equal(t, []string{"token-1", "token-2"}, tokens, "tokens")
+
+	assert.NoError(t, os.WriteFile(file, []byte("# no tokens\n"), 0o600))
+	_, err = admin.LoadTokens(file)
+	assert.Error(t, err, "no tokens")
+
+	store, err := maintenance.New("", loggerFactory.NewLogger("maintenance"))
+	assert.NoError(t, err, "create store")
+	s := admin.New(loggerFactory.NewLogger("admin"), store)
+	s.RequireToken(tokens...)
+	for header, status := range map[string]int{
+		"":               http.StatusUnauthorized,
+		"Bearer":         http.StatusUnauthorized,
+		"Bearer ":        http.StatusUnauthorized,
+		"Bearer token-3": http.StatusUnauthorized,
+		"Basic token-1":  http.StatusUnauthorized,
+		"Bearer token-1": http.StatusOK,
+		"Bearer token-2": http.StatusOK,
+	} {
+		req := httptest.NewRequest("GET", "http://example.com/maintenance", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		assert.Equal(t, status, w.Code, "HTTP status: %q", header)
+	}
+}
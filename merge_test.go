@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+	a12n "github.com/l7mp/stunner/pkg/authentication"
+
+	"github.com/l7mp/stunner-auth-service/pkg/authtest"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+var (
+	// same credentials as staticAuthConfig
+	sharedStaticAuthConfig = testConfig(authtest.NewConfig("othernamespace/stunnerd-static",
+		authtest.StaticAuth("user1", "pass1"),
+		authtest.Listener("othernamespace/othergateway/udp", "turn-udp", "5.6.7.8", 3478, "127.0.0.3", 23478),
+		authtest.Listener("othernamespace/othergateway/udp-dup", "turn-udp", "1.2.3.4", 3478, "127.0.0.3", 23478),
+	))
+	// same shared secret as ephemeralAuthConfig
+	sharedEphemeralAuthConfig = testConfig(authtest.NewConfig("othernamespace/stunnerd-ephemeral",
+		authtest.EphemeralAuth("my-secret"),
+		authtest.Listener("othernamespace/othergateway/udp-2", "turn-udp", "5.6.7.9", 3478, "127.0.0.4", 23478),
+	))
+)
+
+var iceMergeTestCases = []iceAuthTestCase{
+	{
+		name: "merge - static and ephemeral configs with shared credentials",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig, &ephemeralAuthConfig,
+			&sharedStaticAuthConfig, &sharedEphemeralAuthConfig},
+		params: "service=turn&username=alice",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			iceServers := *iceConfig.IceServers
+			assert.Len(t, iceServers, 2, "ICE servers len")
+			for _, s := range iceServers {
+				uris := *s.Urls
+				if *s.Username == "user1" {
+					assert.Len(t, uris, 5, "static URI len")
+					assert.Contains(t, uris, "turn:1.2.3.4:3478?transport=udp", "UDP URI")
+					assert.Contains(t, uris, "turn:5.6.7.8:3478?transport=udp", "merged UDP URI")
+				} else {
+					assert.Len(t, uris, 5, "ephemeral URI len")
+					assert.Contains(t, uris, "turn:1.2.3.5:3478?transport=udp", "UDP URI")
+					assert.Contains(t, uris, "turn:5.6.7.9:3478?transport=udp", "merged UDP URI")
+				}
+			}
+		},
+	},
+}
+
+var turnMergeTestCases = []turnAuthTestCase{
+	{
+		name:   "merge - static configs with shared credentials",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig, &sharedStaticAuthConfig},
+		params: "service=turn",
+		status: 200,
+		tester: func(t *testing.T, turnAuthToken *types.TurnAuthenticationToken, authHandler a12n.AuthHandler) {
+			assert.Equal(t, "user1", *turnAuthToken.Username, "username")
+			assert.Equal(t, "pass1", *turnAuthToken.Password, "password")
+			uris := *turnAuthToken.Uris
+			assert.Len(t, uris, 5, "URI len")
+			assert.Contains(t, uris, "turn:1.2.3.4:3478?transport=udp", "UDP URI")
+			assert.Contains(t, uris, "turn:5.6.7.8:3478?transport=udp", "merged UDP URI")
+		},
+	},
+	{
+		name:   "merge - ephemeral configs with shared secret",
+		config: []*stnrv1.StunnerConfig{&ephemeralAuthConfig, &sharedEphemeralAuthConfig},
+		params: "service=turn&username=alice",
+		status: 200,
+		tester: func(t *testing.T, turnAuthToken *types.TurnAuthenticationToken, authHandler a12n.AuthHandler) {
+			passwd, err := a12n.GetLongTermCredential(*turnAuthToken.Username, "my-secret")
+			assert.NoError(t, err, "GetLongTermCredential")
+			assert.Equal(t, passwd, *turnAuthToken.Password, "credential ok")
+			uris := *turnAuthToken.Uris
+			assert.Len(t, uris, 5, "URI len")
+			assert.Contains(t, uris, "turn:1.2.3.5:3478?transport=udp", "UDP URI")
+			assert.Contains(t, uris, "turn:5.6.7.9:3478?transport=udp", "merged UDP URI")
+		},
+	},
+}
+
+func TestICEMerge(t *testing.T)  { testICE(t, iceMergeTestCases) }
+func TestTURNMerge(t *testing.T) { testTURNAuth(t, turnMergeTestCases) }
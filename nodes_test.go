@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+	a12n "github.com/l7mp/stunner/pkg/authentication"
+	"github.com/l7mp/stunner/pkg/logger"
+
+	"github.com/l7mp/stunner-auth-service/internal/admin"
+	"github.com/l7mp/stunner-auth-service/internal/handler"
+	"github.com/l7mp/stunner-auth-service/internal/nodes"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+func testNode(name string, ready corev1.ConditionStatus, addrs ...corev1.NodeAddress) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			Addresses:  addrs,
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}},
+		},
+	}
+}
+
+// placeholderPatch sets the node address placeholder on the UDP listener
+func placeholderPatch(c *stnrv1.StunnerConfig) {
+	c.Listeners[0].Addr = stnrv1.DefaultNodeAddressPlaceholder
+	c.Listeners[0].PublicAddr = ""
+}
+
+var iceNodeTestCases = []iceAuthTestCase{
+	{
+		name:   "nodes - placeholder resolved to all nodes",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		patch:  placeholderPatch,
+		params: "service=turn&namespace=testnamespace&gateway=testgateway&listener=udp",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:1.1.1.1:3478?transport=udp",
+				"turn:10.0.0.2:3478?transport=udp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "nodes - public address takes precedence",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		patch:  placeholderPatch,
+		params: "service=turn&namespace=testnamespace&gateway=testgateway&listener=udp&public-addr=5.6.7.8",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{"turn:5.6.7.8:3478?transport=udp"},
+				*(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "nodes - other listeners unaffected",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		patch:  placeholderPatch,
+		params: "service=turn&namespace=dummynamespace",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{"turn:1.2.3.4:3478?transport=tcp"},
+				*(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+}
+
+var iceNodeSelectionTestCases = []iceAuthTestCase{
+	{
+		name:   "nodes - single node selected",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		patch:  placeholderPatch,
+		params: "service=turn&namespace=testnamespace&gateway=testgateway&listener=udp",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{"turn:1.1.1.1:3478?transport=udp"},
+				*(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+}
+
+func TestNodeAddresses(t *testing.T) {
+	loggerFactory := logger.NewLoggerFactory(authTestLoglevel)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := fake.NewClientset(
+		testNode("node-1", corev1.ConditionTrue,
+			corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+			corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: "1.1.1.1"}),
+		testNode("node-2", corev1.ConditionTrue,
+			corev1.NodeAddress{Type: corev1.NodeHostName, Address: "node-2"},
+			corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.0.2"}),
+		testNode("node-3", corev1.ConditionFalse,
+			corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: "3.3.3.3"}),
+	)
+
+	n := nodes.New(client, loggerFactory.NewLogger("nodes"))
+	assert.NoError(t, n.Start(ctx), "start")
+	assert.Equal(t, []credentials.NodeAddress{
+		{Node: "node-1", Address: "1.1.1.1"},
+		{Node: "node-2", Address: "10.0.0.2"},
+	}, n.NodeAddresses(), "node addresses")
+
+	runICE(t, iceNodeTestCases, handler.WithNodeAddresser(n, nil))
+	runICE(t, iceNodeSelectionTestCases, handler.WithNodeAddresser(n,
+		&credentials.Selector{Policy: credentials.SelectFirst}))
+
+	// all listeners of a Gateway resolve to the same node
+	runICE(t, []iceAuthTestCase{{
+		name:   "nodes - same node for all listeners of a gateway",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		patch: func(c *stnrv1.StunnerConfig) {
+			for _, i := range []int{0, 3} {
+				c.Listeners[i].Addr = stnrv1.DefaultNodeAddressPlaceholder
+				c.Listeners[i].PublicAddr = ""
+			}
+		},
+		params: "service=turn&namespace=testnamespace&gateway=testgateway",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:1.1.1.1:3478?transport=udp",
+				"turns:1.1.1.1:3479?transport=udp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	}}, handler.WithNodeAddresser(n, &credentials.Selector{Policy: credentials.SelectRoundRobin}))
+
+	// admin API
+	w := adminRequest(admin.New(loggerFactory.NewLogger("admin"), n), http.MethodGet, "/nodes", "")
+	assert.Equal(t, http.StatusOK, w.Code, "admin status")
+	addrs := []credentials.NodeAddress{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &addrs), "decode")
+	assert.Len(t, addrs, 2, "admin node addresses")
+
+	// the informer follows node changes
+	_, err := client.CoreV1().Nodes().Create(ctx, testNode("node-0", corev1.ConditionTrue,
+		corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: "2.2.2.2"}), metav1.CreateOptions{})
+	assert.NoError(t, err, "create node")
+	assert.Eventually(t, func() bool { return len(n.NodeAddresses()) == 3 }, 5*time.Second,
+		10*time.Millisecond, "node added")
+	assert.Equal(t, credentials.NodeAddress{Node: "node-0", Address: "2.2.2.2"}, n.NodeAddresses()[0],
+		"new node address")
+
+	assert.NoError(t, client.CoreV1().Nodes().Delete(ctx, "node-1", metav1.DeleteOptions{}), "delete node")
+	assert.Eventually(t, func() bool { return len(n.NodeAddresses()) == 2 }, 5*time.Second,
+		10*time.Millisecond, "node removed")
+
+	// no nodes: placeholder is left as is
+	runICE(t, []iceAuthTestCase{{
+		name:   "nodes - no nodes",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		patch:  placeholderPatch,
+		params: "service=turn&namespace=testnamespace&gateway=testgateway&listener=udp",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{"turn:0.0.0.0:3478?transport=udp"},
+				*(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	}}, handler.WithNodeAddresser(noNodes{}, nil))
+}
+
+// noNodes is a node addresser with no nodes
+type noNodes struct{}
+
+func (noNodes) NodeAddresses() []credentials.NodeAddress { return nil }
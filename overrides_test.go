@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+	a12n "github.com/l7mp/stunner/pkg/authentication"
+	"github.com/l7mp/stunner/pkg/logger"
+
+	"github.com/l7mp/stunner-auth-service/internal/handler"
+	"github.com/l7mp/stunner-auth-service/internal/overrides"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+const testOverrides = `
+turn-tcp:
+  publicAddr: 5.6.7.8
+  publicPort: 443
+testnamespace/testgateway:
+  publicAddr: 9.9.9.9
+testnamespace/testgateway/dtls:
+  publicPort: 30479
+`
+
+var iceOverrideTestCases = []iceAuthTestCase{
+	{
+		name:   "overrides - listener, gateway and protocol",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:9.9.9.9:3478?transport=udp",
+				"turn:5.6.7.8:443?transport=tcp",
+				"turns:127.0.0.1:3479?transport=tcp",
+				"turns:9.9.9.9:30479?transport=udp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "overrides - public address from request",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&public-addr=1.1.1.1",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:1.1.1.1:3478?transport=udp",
+				"turn:1.1.1.1:443?transport=tcp",
+				"turns:1.1.1.1:3479?transport=tcp",
+				"turns:1.1.1.1:30479?transport=udp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "overrides - public port from request",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&namespace=testnamespace&public-port=8443",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:9.9.9.9:8443?transport=udp",
+				"turns:127.0.0.1:8443?transport=tcp",
+				"turns:9.9.9.9:8443?transport=udp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:          "overrides - precedence over environment",
+		config:        []*stnrv1.StunnerConfig{&staticAuthConfig},
+		envPublicAddr: "7.7.7.7",
+		params:        "service=turn",
+		status:        200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:9.9.9.9:3478?transport=udp",
+				"turn:5.6.7.8:443?transport=tcp",
+				"turns:7.7.7.7:3479?transport=tcp",
+				"turns:9.9.9.9:30479?transport=udp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "overrides - invalid public port",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&public-port=70000",
+		status: 400,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	},
+}
+
+func TestAddressOverrides(t *testing.T) {
+	loggerFactory := logger.NewLoggerFactory(authTestLoglevel)
+	file := filepath.Join(t.TempDir(), "overrides.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte(testOverrides), 0o600), "write overrides")
+
+	o, err := overrides.New(file, loggerFactory.NewLogger("overrides"))
+	assert.NoError(t, err, "load overrides")
+
+	testICE(t, iceOverrideTestCases, handler.WithAddressOverrider(o))
+
+	// reload
+	udp := &staticAuthConfig.Listeners[0]
+	assert.NoError(t, os.WriteFile(file, []byte("turn-udp: {publicPort: 1234}\n"), 0o600), "write overrides")
+	assert.NoError(t, o.Reload(), "reload")
+	assert.Equal(t, credentials.AddressOverride{PublicPort: 1234}, o.OverrideAddress(udp), "reloaded override")
+
+	// invalid overrides are not loaded
+	for _, content := range []string{
+		"turn-sctp: {publicAddr: 1.2.3.4}\n",
+		"a/b/c/d: {publicAddr: 1.2.3.4}\n",
+		"turn-udp: {publicPort: 100000}\n",
+		"turn-udp: {publicHost: dummy}\n",
+	} {
+		assert.NoError(t, os.WriteFile(file, []byte(content), 0o600), "write overrides")
+		assert.Error(t, o.Reload(), "invalid overrides %q", content)
+	}
+	assert.Equal(t, credentials.AddressOverride{PublicPort: 1234}, o.OverrideAddress(udp),
+		"previous overrides kept")
+}
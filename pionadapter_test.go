@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/l7mp/stunner-auth-service/pkg/client/pionadapter"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+// testIceConfigSource is a scriptable ICE config source.
+type testIceConfigSource struct {
+	lock     sync.Mutex
+	password string
+	err      error
+	calls    int
+}
+
+func (s *testIceConfigSource) GetIceConfig(_ context.Context, _ *types.GetIceAuthParams) (*types.IceConfig, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	username, password := "user1", s.password
+	uris := []string{"turn:1.2.3.4:3478?transport=udp"}
+	return &types.IceConfig{IceServers: &[]types.IceAuthenticationToken{
+		{Username: &username, Credential: &password, Urls: &uris},
+	}}, nil
+}
+
+func (s *testIceConfigSource) set(password string, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.password, s.err = password, err
+}
+
+func (s *testIceConfigSource) numCalls() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.calls
+}
+
+// testUpdates collects the configurations passed to OnUpdate.
+type testUpdates struct {
+	lock    sync.Mutex
+	configs []webrtc.Configuration
+}
+
+func (u *testUpdates) onUpdate(c webrtc.Configuration) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.configs = append(u.configs, c)
+}
+
+func (u *testUpdates) get() []webrtc.Configuration {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return append([]webrtc.Configuration{}, u.configs...)
+}
+
+func credential(c webrtc.Configuration) any {
+	if len(c.ICEServers) == 0 {
+		return nil
+	}
+	return c.ICEServers[0].Credential
+}
+
+func TestRefresherRefresh(t *testing.T) {
+	ctx := context.Background()
+	source := &testIceConfigSource{password: "pass1"}
+	updates := &testUpdates{}
+	r := pionadapter.NewRefresher(source, pionadapter.RefresherOptions{OnUpdate: updates.onUpdate})
+	assert.Empty(t, r.Configuration().ICEServers, "no configuration before the first refresh")
+
+	// no update for the first configuration
+	assert.NoError(t, r.Refresh(ctx), "refresh")
+	assert.Equal(t, "pass1", credential(r.Configuration()), "configuration")
+	assert.Empty(t, updates.get(), "no update")
+
+	// no update if the configuration is unchanged
+	assert.NoError(t, r.Refresh(ctx), "refresh")
+	assert.Empty(t, updates.get(), "no update")
+
+	source.set("pass2", nil)
+	assert.NoError(t, r.Refresh(ctx), "refresh")
+	assert.Len(t, updates.get(), 1, "update")
+	assert.Equal(t, "pass2", credential(updates.get()[0]), "update")
+	assert.Equal(t, "pass2", credential(r.Configuration()), "configuration")
+
+	// errors retain the last valid configuration
+	source.set("pass3", errors.New("unavailable"))
+	assert.Error(t, r.Refresh(ctx), "refresh")
+	assert.Equal(t, "pass2", credential(r.Configuration()), "configuration")
+	assert.Len(t, updates.get(), 1, "no update")
+}
+
+func TestRefresherStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the initial refresh fails
+	source := &testIceConfigSource{err: errors.New("unavailable")}
+	r := pionadapter.NewRefresher(source, pionadapter.RefresherOptions{})
+	assert.Error(t, r.Start(ctx), "start")
+
+	// OnUpdate is not called for the initial configuration, so that the callback may use
+	// PeerConnections created after Start
+	source.set("pass1", nil)
+	updates := &testUpdates{}
+	r = pionadapter.NewRefresher(source, pionadapter.RefresherOptions{
+		Interval: 20 * time.Millisecond,
+		OnUpdate: updates.onUpdate,
+	})
+	assert.NoError(t, r.Start(ctx), "start")
+	assert.Equal(t, "pass1", credential(r.Configuration()), "configuration")
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, updates.get(), "no update")
+
+	source.set("pass2", nil)
+	assert.Eventually(t, func() bool { return len(updates.get()) == 1 }, time.Second,
+		5*time.Millisecond, "update")
+	assert.Equal(t, "pass2", credential(updates.get()[0]), "update")
+}
+
+func TestRefresherBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := &testIceConfigSource{password: "pass1"}
+	errs := make(chan error, 100)
+	updates := &testUpdates{}
+	r := pionadapter.NewRefresher(source, pionadapter.RefresherOptions{
+		Interval:      400 * time.Millisecond,
+		RetryInterval: 10 * time.Millisecond,
+		OnUpdate:      updates.onUpdate,
+		OnError:       func(err error) { errs <- err },
+	})
+	assert.NoError(t, r.Start(ctx), "start")
+
+	source.set("pass2", errors.New("unavailable"))
+	// the first refresh fails after 400ms, then retries follow after 10, 20, 40, 80, ... ms
+	assert.Eventually(t, func() bool { return len(errs) >= 4 }, 700*time.Millisecond,
+		5*time.Millisecond, "retries")
+	assert.Equal(t, "pass1", credential(r.Configuration()), "last valid configuration")
+
+	// the backoff is capped at the interval
+	time.Sleep(300 * time.Millisecond)
+	n := source.numCalls()
+	time.Sleep(300 * time.Millisecond)
+	assert.LessOrEqual(t, source.numCalls()-n, 1, "capped backoff")
+
+	source.set("pass2", nil)
+	assert.Eventually(t, func() bool { return len(updates.get()) == 1 }, time.Second,
+		5*time.Millisecond, "recovered")
+	assert.Equal(t, "pass2", credential(r.Configuration()), "configuration")
+}
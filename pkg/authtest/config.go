@@ -0,0 +1,109 @@
+// Package authtest provides utilities for testing code that embeds the STUNner authentication
+// service client, without a running Kubernetes cluster.
+package authtest
+
+import (
+	"encoding/base64"
+
+	"github.com/l7mp/stunner"
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+)
+
+// DefaultLogLevel is the log level set in the configs returned by the builders.
+const DefaultLogLevel = "all:ERROR"
+
+var (
+	certPem, keyPem, _ = stunner.GenerateSelfSignedKey()
+	// CertPem64 is a base64-encoded self-signed TLS certificate used for TLS/DTLS listeners.
+	CertPem64 = base64.StdEncoding.EncodeToString(certPem)
+	// KeyPem64 is the base64-encoded private key for CertPem64.
+	KeyPem64 = base64.StdEncoding.EncodeToString(keyPem)
+)
+
+// NewConfig builds a STUNner config with the given name (in the form "namespace/name"),
+// authentication config and listeners.
+func NewConfig(name string, auth stnrv1.AuthConfig, listeners ...stnrv1.ListenerConfig) *stnrv1.StunnerConfig {
+	if listeners == nil {
+		listeners = []stnrv1.ListenerConfig{}
+	}
+	return &stnrv1.StunnerConfig{
+		ApiVersion: "v1",
+		Admin: stnrv1.AdminConfig{
+			Name:     name,
+			LogLevel: DefaultLogLevel,
+		},
+		Auth:      auth,
+		Listeners: listeners,
+		Clusters:  []stnrv1.ClusterConfig{},
+	}
+}
+
+// StaticAuth builds a static authentication config with the given username and password.
+func StaticAuth(username, password string) stnrv1.AuthConfig {
+	return stnrv1.AuthConfig{
+		Type:  "static",
+		Realm: "",
+		Credentials: map[string]string{
+			"username": username,
+			"password": password,
+		},
+	}
+}
+
+// EphemeralAuth builds an ephemeral authentication config with the given shared secret.
+func EphemeralAuth(secret string) stnrv1.AuthConfig {
+	return stnrv1.AuthConfig{
+		Type:  "ephemeral",
+		Realm: "",
+		Credentials: map[string]string{
+			"secret": secret,
+		},
+	}
+}
+
+// Listener builds a listener config. The name must be in the form
+// "namespace/gateway/listener". TLS and DTLS listeners receive a self-signed certificate.
+func Listener(name, protocol, publicAddr string, publicPort int, addr string, port int) stnrv1.ListenerConfig {
+	l := stnrv1.ListenerConfig{
+		Name:       name,
+		Protocol:   protocol,
+		PublicAddr: publicAddr,
+		PublicPort: publicPort,
+		Addr:       addr,
+		Port:       port,
+		Routes:     []string{},
+	}
+
+	if protocol == "turn-tls" || protocol == "turn-dtls" {
+		l.Cert = CertPem64
+		l.Key = KeyPem64
+	}
+
+	return l
+}
+
+// StaticAuthConfig returns a config named "testnamespace/stunnerd-static" with static
+// authentication (username "user1", password "pass1") and a UDP, TCP, TLS and DTLS listener,
+// spread across different namespaces and gateways. The UDP and TCP listeners have public address
+// 1.2.3.4, the TLS and DTLS listeners only have a private address 127.0.0.1.
+func StaticAuthConfig() *stnrv1.StunnerConfig {
+	return NewConfig("testnamespace/stunnerd-static", StaticAuth("user1", "pass1"),
+		Listener("testnamespace/testgateway/udp", "turn-udp", "1.2.3.4", 3478, "127.0.0.1", 23478),
+		Listener("dummynamespace/testgateway/tcp", "turn-tcp", "1.2.3.4", 3478, "127.0.0.1", 3478),
+		Listener("testnamespace/dummygateway/tls", "turn-tls", "", 0, "127.0.0.1", 3479),
+		Listener("testnamespace/testgateway/dtls", "turn-dtls", "", 0, "127.0.0.1", 3479),
+	)
+}
+
+// EphemeralAuthConfig returns a config named "testnamespace/stunnerd-ephemeral" with ephemeral
+// authentication (shared secret "my-secret"), and the same listener layout as StaticAuthConfig
+// but with listener names suffixed with "-2", public address 1.2.3.5 and private address
+// 127.0.0.2.
+func EphemeralAuthConfig() *stnrv1.StunnerConfig {
+	return NewConfig("testnamespace/stunnerd-ephemeral", EphemeralAuth("my-secret"),
+		Listener("testnamespace/testgateway/udp-2", "turn-udp", "1.2.3.5", 3478, "127.0.0.2", 23478),
+		Listener("dummynamespace/testgateway/tcp-2", "turn-tcp", "1.2.3.5", 3478, "127.0.0.2", 3478),
+		Listener("testnamespace/dummygateway/tls-2", "turn-tls", "", 0, "127.0.0.2", 3479),
+		Listener("testnamespace/testgateway/dtls-2", "turn-dtls", "", 0, "127.0.0.2", 3479),
+	)
+}
@@ -0,0 +1,161 @@
+package authtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/l7mp/stunner-auth-service/pkg/client"
+	"github.com/l7mp/stunner-auth-service/pkg/server"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+// FakeResponse is a scripted response of the fake authentication service.
+type FakeResponse struct {
+	// Status is the HTTP status code to return. Default is 200.
+	Status int
+	// Error is the error message returned in the body if Status is not 200.
+	Error string
+	// Latency is the time to wait before responding.
+	Latency time.Duration
+	// TurnAuthToken is the response returned from the TURN REST API endpoint "/".
+	TurnAuthToken *types.TurnAuthenticationToken
+	// IceConfig is the response returned from the ICE config API endpoint "/ice".
+	IceConfig *types.IceConfig
+}
+
+// NewFakeResponse returns a successful response with the given credentials and URIs, valid for
+// both the TURN REST API and the ICE config API endpoints.
+func NewFakeResponse(username, password string, ttl time.Duration, uris ...string) FakeResponse {
+	duration := int64(ttl.Seconds())
+	policy := types.All
+	urls := append([]string{}, uris...)
+	return FakeResponse{
+		TurnAuthToken: &types.TurnAuthenticationToken{
+			Username: &username,
+			Password: &password,
+			Ttl:      &duration,
+			Uris:     &urls,
+		},
+		IceConfig: &types.IceConfig{
+			IceServers: &[]types.IceAuthenticationToken{{
+				Username:   &username,
+				Credential: &password,
+				Urls:       &urls,
+			}},
+			IceTransportPolicy: &policy,
+		},
+	}
+}
+
+// Fake is a scriptable fake authentication service running on a local HTTP test server. Scripted
+// responses are returned in the order they were pushed, after which the default response is
+// returned.
+type Fake struct {
+	*httptest.Server
+	script       []FakeResponse
+	def          FakeResponse
+	turnRequests []types.GetTurnAuthParams
+	iceRequests  []types.GetIceAuthParams
+	lock         sync.Mutex
+}
+
+// NewFake starts a fake authentication service that by default returns static credentials
+// "user1"/"pass1" with a single TURN URI. The caller should call Close when finished to shut it
+// down.
+func NewFake() *Fake {
+	f := &Fake{
+		def: NewFakeResponse("user1", "pass1", 24*time.Hour,
+			"turn:1.2.3.4:3478?transport=udp"),
+	}
+	f.Server = httptest.NewServer(server.HandlerWithOptions(f, server.GorillaServerOptions{}))
+	return f
+}
+
+// SetDefault sets the response returned when there are no scripted responses left.
+func (f *Fake) SetDefault(r FakeResponse) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.def = r
+}
+
+// Push appends responses to the script.
+func (f *Fake) Push(r ...FakeResponse) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.script = append(f.script, r...)
+}
+
+// TurnRequests returns the parameters of the TURN REST API requests received so far.
+func (f *Fake) TurnRequests() []types.GetTurnAuthParams {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]types.GetTurnAuthParams{}, f.turnRequests...)
+}
+
+// IceRequests returns the parameters of the ICE config API requests received so far.
+func (f *Fake) IceRequests() []types.GetIceAuthParams {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]types.GetIceAuthParams{}, f.iceRequests...)
+}
+
+// NewClient returns an authentication service client connected to the fake.
+func (f *Fake) NewClient() (*client.Client, error) {
+	return client.NewClient(f.URL)
+}
+
+// GetTurnAuth implements server.ServerInterface.
+func (f *Fake) GetTurnAuth(w http.ResponseWriter, r *http.Request, params types.GetTurnAuthParams) {
+	f.lock.Lock()
+	f.turnRequests = append(f.turnRequests, params)
+	resp := f.next()
+	f.lock.Unlock()
+
+	f.respond(w, r, resp, resp.TurnAuthToken)
+}
+
+// GetIceAuth implements server.ServerInterface.
+func (f *Fake) GetIceAuth(w http.ResponseWriter, r *http.Request, params types.GetIceAuthParams) {
+	f.lock.Lock()
+	f.iceRequests = append(f.iceRequests, params)
+	resp := f.next()
+	f.lock.Unlock()
+
+	f.respond(w, r, resp, resp.IceConfig)
+}
+
+// next must be called with the lock held.
+func (f *Fake) next() FakeResponse {
+	if len(f.script) == 0 {
+		return f.def
+	}
+	resp := f.script[0]
+	f.script = f.script[1:]
+	return resp
+}
+
+func (f *Fake) respond(w http.ResponseWriter, r *http.Request, resp FakeResponse, body any) {
+	if resp.Latency > 0 {
+		select {
+		case <-time.After(resp.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	status := resp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	if status != http.StatusOK {
+		http.Error(w, resp.Error, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_ = json.NewEncoder(w).Encode(body)
+}
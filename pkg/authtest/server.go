@@ -0,0 +1,47 @@
+package authtest
+
+import (
+	"net/http/httptest"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+	"github.com/l7mp/stunner/pkg/logger"
+
+	"github.com/l7mp/stunner-auth-service/internal/handler"
+	"github.com/l7mp/stunner-auth-service/pkg/client"
+	"github.com/l7mp/stunner-auth-service/pkg/server"
+)
+
+// Server is an in-process authentication service running on a local HTTP test server. Instead of
+// watching a CDS server, the STUNner configs are injected directly.
+type Server struct {
+	*httptest.Server
+	handler *handler.Handler
+}
+
+// NewServer starts an authentication service on a local HTTP test server with the given
+// STUNner configs. The caller should call Close when finished to shut it down.
+func NewServer(configs ...*stnrv1.StunnerConfig) *Server {
+	loggerFactory := logger.NewLoggerFactory(DefaultLogLevel)
+
+	// we don't Start() the handler so a nil channel is not a problem
+	h, _ := handler.NewHandler(nil, loggerFactory.NewLogger("auth-svc"))
+
+	s := &Server{handler: h}
+	s.SetConfig(configs...)
+	s.Server = httptest.NewServer(server.HandlerWithOptions(h, server.GorillaServerOptions{}))
+
+	return s
+}
+
+// SetConfig replaces the STUNner configs served by the authentication service.
+func (s *Server) SetConfig(configs ...*stnrv1.StunnerConfig) {
+	s.handler.Reset()
+	for _, c := range configs {
+		s.handler.SetConfig(c.Admin.Name, c.DeepCopy())
+	}
+}
+
+// NewClient returns an authentication service client connected to the server.
+func (s *Server) NewClient() (*client.Client, error) {
+	return client.NewClient(s.URL)
+}
@@ -0,0 +1,61 @@
+// Package pionadapter converts the ICE configurations obtained from the STUNner authentication
+// service into pion/webrtc PeerConnection configurations.
+package pionadapter
+
+import (
+	"github.com/pion/webrtc/v4"
+
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+// NewConfiguration converts an ICE config into a pion/webrtc PeerConnection configuration.
+func NewConfiguration(c *types.IceConfig) webrtc.Configuration {
+	if c == nil {
+		return webrtc.Configuration{}
+	}
+
+	return webrtc.Configuration{
+		ICEServers:         NewICEServers(c),
+		ICETransportPolicy: NewICETransportPolicy(c.IceTransportPolicy),
+	}
+}
+
+// NewICEServers converts the ICE servers of an ICE config into a list of pion/webrtc ICE
+// servers. ICE servers with no URLs are omitted.
+func NewICEServers(c *types.IceConfig) []webrtc.ICEServer {
+	ret := []webrtc.ICEServer{}
+	if c == nil || c.IceServers == nil {
+		return ret
+	}
+
+	for _, s := range *c.IceServers {
+		if s.Urls == nil || len(*s.Urls) == 0 {
+			continue
+		}
+
+		server := webrtc.ICEServer{
+			URLs:           append([]string{}, *s.Urls...),
+			CredentialType: webrtc.ICECredentialTypePassword,
+		}
+		if s.Username != nil {
+			server.Username = *s.Username
+		}
+		if s.Credential != nil {
+			server.Credential = *s.Credential
+		}
+
+		ret = append(ret, server)
+	}
+
+	return ret
+}
+
+// NewICETransportPolicy converts an ICE transport policy into a pion/webrtc ICE transport
+// policy. Since pion/webrtc does not implement the "public" policy, this and unset policies map
+// to "all".
+func NewICETransportPolicy(p *types.IceTransportPolicy) webrtc.ICETransportPolicy {
+	if p != nil && *p == types.Relay {
+		return webrtc.ICETransportPolicyRelay
+	}
+	return webrtc.ICETransportPolicyAll
+}
@@ -0,0 +1,159 @@
+package pionadapter
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+// DefaultRetryInterval is the default time to wait before retrying a failed refresh.
+const DefaultRetryInterval = 5 * time.Second
+
+// IceConfigSource is a source of up-to-date ICE configurations, like a client.Client or a
+// credential cache wrapping one.
+type IceConfigSource interface {
+	GetIceConfig(ctx context.Context, params *types.GetIceAuthParams) (*types.IceConfig, error)
+}
+
+// RefresherOptions specifies the parameters for a Refresher.
+type RefresherOptions struct {
+	// Params are the parameters used to request ICE configs from the source (optional).
+	Params *types.GetIceAuthParams
+	// Interval is the period between refreshes. Default is half of the TTL specified in the
+	// request parameters, or half of the default TTL of the authentication service.
+	Interval time.Duration
+	// RetryInterval is the time to wait before retrying a failed refresh, doubled after each
+	// consecutive failure up to Interval. Default is DefaultRetryInterval.
+	RetryInterval time.Duration
+	// OnUpdate is called with the new configuration every time the ICE config changes
+	// (optional). Use this to call SetConfiguration on running PeerConnections. OnUpdate is not
+	// called for the first configuration: create PeerConnections with Configuration after
+	// Start returns.
+	OnUpdate func(webrtc.Configuration)
+	// OnError is called when an ICE config could not be obtained from the source (optional).
+	// The last valid configuration is retained in this case.
+	OnError func(error)
+}
+
+// Refresher keeps a pion/webrtc PeerConnection configuration up to date by periodically
+// re-requesting the ICE config from a source, so that the configuration is rebuilt when
+// credentials rotate.
+type Refresher struct {
+	source        IceConfigSource
+	params        *types.GetIceAuthParams
+	interval      time.Duration
+	retryInterval time.Duration
+	onUpdate      func(webrtc.Configuration)
+	onError       func(error)
+	config        *webrtc.Configuration
+	lock          sync.RWMutex
+}
+
+// NewRefresher creates a new Refresher that obtains ICE configs from the given source.
+func NewRefresher(source IceConfigSource, opts RefresherOptions) *Refresher {
+	params := opts.Params
+	if params == nil {
+		params = &types.GetIceAuthParams{}
+	}
+
+	interval := opts.Interval
+	if interval <= 0 {
+		ttl := credentials.DefaultTTL
+		if params.Ttl != nil && *params.Ttl > 0 {
+			ttl = time.Duration(*params.Ttl) * time.Second
+		}
+		interval = ttl / 2
+	}
+
+	retryInterval := opts.RetryInterval
+	if retryInterval <= 0 {
+		retryInterval = DefaultRetryInterval
+	}
+	retryInterval = min(retryInterval, interval)
+
+	return &Refresher{
+		source:        source,
+		params:        params,
+		interval:      interval,
+		retryInterval: retryInterval,
+		onUpdate:      opts.OnUpdate,
+		onError:       opts.OnError,
+	}
+}
+
+// Start performs an initial refresh and then keeps on refreshing the configuration in the
+// background until the context is canceled. Returns an error if the initial refresh fails. Failed
+// refreshes are retried with an exponential backoff.
+func (r *Refresher) Start(ctx context.Context) error {
+	if err := r.Refresh(ctx); err != nil {
+		return err
+	}
+
+	go func() {
+		timer := time.NewTimer(r.interval)
+		defer timer.Stop()
+
+		backoff := r.retryInterval
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+				if err := r.Refresh(ctx); err != nil {
+					if r.onError != nil {
+						r.onError(err)
+					}
+					timer.Reset(backoff)
+					backoff = min(2*backoff, r.interval)
+					continue
+				}
+				timer.Reset(r.interval)
+				backoff = r.retryInterval
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Refresh obtains a new ICE config from the source and rebuilds the configuration. The
+// OnUpdate callback is called only if the configuration has changed, and not for the first
+// configuration.
+func (r *Refresher) Refresh(ctx context.Context) error {
+	// the client may default some params, so pass a copy
+	params := *r.params
+	iceConfig, err := r.source.GetIceConfig(ctx, &params)
+	if err != nil {
+		return err
+	}
+
+	config := NewConfiguration(iceConfig)
+
+	r.lock.Lock()
+	changed := r.config != nil && !reflect.DeepEqual(*r.config, config)
+	r.config = &config
+	r.lock.Unlock()
+
+	if changed && r.onUpdate != nil {
+		r.onUpdate(config)
+	}
+
+	return nil
+}
+
+// Configuration returns the last valid PeerConnection configuration, or an empty configuration if
+// no ICE config has been obtained yet.
+func (r *Refresher) Configuration() webrtc.Configuration {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.config == nil {
+		return webrtc.Configuration{}
+	}
+	return *r.config
+}
@@ -0,0 +1,196 @@
+package credentials
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+)
+
+// AddressClass is a class of addresses that are not routable from the public Internet.
+type AddressClass string
+
+const (
+	// AddressUnspecified is the unspecified address, e.g., 0.0.0.0. Listeners with no
+	// address at all also fall into this class.
+	AddressUnspecified AddressClass = "unspecified"
+	// AddressLoopback is a loopback address, e.g., 127.0.0.1.
+	AddressLoopback AddressClass = "loopback"
+	// AddressLinkLocal is a link-local address, e.g., 169.254.0.1.
+	AddressLinkLocal AddressClass = "link-local"
+	// AddressPrivate is a private address as per RFC 1918 (IPv4) or RFC 4193 (IPv6).
+	AddressPrivate AddressClass = "private"
+)
+
+// UnroutableAction specifies the handling of the TURN URIs with an unroutable address.
+type UnroutableAction string
+
+const (
+	// UnroutableAllow returns the TURN URI as is.
+	UnroutableAllow UnroutableAction = "allow"
+	// UnroutableDrop omits the TURN URI.
+	UnroutableDrop UnroutableAction = "drop"
+	// UnroutableFlag returns the TURN URI but reports it.
+	UnroutableFlag UnroutableAction = "flag"
+	// UnroutableFallback substitutes the fallback address in the TURN URI.
+	UnroutableFallback UnroutableAction = "fallback"
+)
+
+// UnroutablePolicy maps address classes to the action to take for the TURN URIs with an address
+// in the class. TURN URIs with an address in a class not in the policy are allowed.
+type UnroutablePolicy map[AddressClass]UnroutableAction
+
+// NewUnroutablePolicy creates an unroutable address policy from a map of address class names to
+// action names, e.g., {"unspecified": "drop", "private": "flag"}.
+func NewUnroutablePolicy(spec map[string]string) (UnroutablePolicy, error) {
+	classes := []AddressClass{AddressUnspecified, AddressLoopback, AddressLinkLocal, AddressPrivate}
+	actions := []UnroutableAction{UnroutableAllow, UnroutableDrop, UnroutableFlag, UnroutableFallback}
+
+	p := UnroutablePolicy{}
+	for c, a := range spec {
+		class, action := AddressClass(strings.ToLower(c)), UnroutableAction(strings.ToLower(a))
+		if !slices.Contains(classes, class) {
+			return nil, fmt.Errorf("%w: unknown address class %q", ErrInvalidRequest, c)
+		}
+		if !slices.Contains(actions, action) {
+			return nil, fmt.Errorf("%w: unknown action %q for address class %q", ErrInvalidRequest, a, c)
+		}
+		p[class] = action
+	}
+	return p, nil
+}
+
+// ClassifyAddress returns the class of an unroutable address, or an empty string if the address
+// is routable or is a hostname.
+func ClassifyAddress(addr string) AddressClass {
+	if addr == "" || addr == stnrv1.DefaultNodeAddressPlaceholder {
+		return AddressUnspecified
+	}
+
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return ""
+	}
+	ip = ip.Unmap()
+
+	switch {
+	case ip.IsUnspecified():
+		return AddressUnspecified
+	case ip.IsLoopback():
+		return AddressLoopback
+	case ip.IsLinkLocalUnicast():
+		return AddressLinkLocal
+	case ip.IsPrivate():
+		return AddressPrivate
+	}
+	return ""
+}
+
+// ValidatePublicAddr checks that a public address is either an IP address or a valid DNS
+// hostname.
+func ValidatePublicAddr(addr string) error {
+	if _, err := netip.ParseAddr(addr); err == nil {
+		return nil
+	}
+
+	host := strings.TrimSuffix(addr, ".")
+	if host == "" || len(host) > 253 {
+		return fmt.Errorf("%q is not an IP address or a hostname", addr)
+	}
+	for _, label := range strings.Split(host, ".") {
+		if !validLabel(label) {
+			return fmt.Errorf("%q is not an IP address or a hostname", addr)
+		}
+	}
+	return nil
+}
+
+// ValidateFallbackAddr checks that the fallback address substituted for unroutable addresses is a
+// single public address: an IP address or a hostname that is not in any of the unroutable address
+// classes.
+func ValidateFallbackAddr(addr string) error {
+	if strings.Contains(addr, ",") {
+		return fmt.Errorf("invalid fallback address %q: should be a single address", addr)
+	}
+	if err := ValidatePublicAddr(addr); err != nil {
+		return fmt.Errorf("invalid fallback address: %w", err)
+	}
+	if class := ClassifyAddress(addr); class != "" {
+		return fmt.Errorf("invalid fallback address %q: %s address is not routable", addr, class)
+	}
+	return nil
+}
+
+// validLabel checks a DNS label as per RFC 1123.
+func validLabel(label string) bool {
+	if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+	for _, c := range label {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// Unroutable describes a listener with an unroutable address.
+type Unroutable struct {
+	// Address is the unroutable address.
+	Address string `json:"address"`
+	// Class is the address class.
+	Class AddressClass `json:"class"`
+	// Action is the action taken.
+	Action UnroutableAction `json:"action"`
+}
+
+// String returns a string representation of the unroutable address.
+func (u Unroutable) String() string {
+	return fmt.Sprintf("addr=%s;class=%s;action=%s", u.Address, u.Class, u.Action)
+}
+
+// listenerAddr returns the address in the TURN URI generated for a listener.
+func listenerAddr(l *stnrv1.ListenerConfig) string {
+	if l.PublicAddr != "" {
+		return l.PublicAddr
+	}
+	return l.Addr
+}
+
+// checkRoutable applies the unroutable address policy to a listener. Returns false if the TURN URI
+// of the listener must be dropped.
+func (g *generator) checkRoutable(name string, l *stnrv1.ListenerConfig) bool {
+	addr := listenerAddr(l)
+	class := ClassifyAddress(addr)
+	if class == "" {
+		return true
+	}
+	action, ok := g.opts.Unroutable[class]
+	if !ok || action == UnroutableAllow {
+		return true
+	}
+	if addr == "" || addr == stnrv1.DefaultNodeAddressPlaceholder {
+		addr = "0.0.0.0"
+	}
+	if action == UnroutableFallback && g.opts.FallbackAddr == "" {
+		action = UnroutableDrop
+	}
+
+	u := &Unroutable{Address: addr, Class: class, Action: action}
+	switch action {
+	case UnroutableDrop:
+		g.diags.unroutable(name, l.Name, u, "dropping TURN URI: %s address %s", class, addr)
+		g.traceExclude(fmt.Sprintf("unroutable address: %s address %s", class, addr))
+		return false
+	case UnroutableFlag:
+		g.diags.unroutable(name, l.Name, u, "flagging TURN URI: %s address %s", class, addr)
+	case UnroutableFallback:
+		l.PublicAddr = g.opts.FallbackAddr
+		g.traceSource(AddressSourceFallback)
+		g.diags.unroutable(name, l.Name, u, "substituting fallback address %s for %s address %s",
+			g.opts.FallbackAddr, class, addr)
+	}
+	return true
+}
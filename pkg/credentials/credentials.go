@@ -0,0 +1,585 @@
+// Package credentials implements the generation of TURN credentials and URIs from STUNner
+// configs. The package has no HTTP dependency, so it can be embedded into applications that watch
+// the STUNner configs themselves and want to issue TURN credentials in-process.
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+	a12n "github.com/l7mp/stunner/pkg/authentication"
+
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+// DefaultTTL is the default TURN credential lifetime, one day.
+const DefaultTTL = 24 * time.Hour
+
+var (
+	// ErrInvalidRequest is returned when the request is malformed.
+	ErrInvalidRequest = errors.New("invalid request")
+	// ErrNoConfig is returned when there are no STUNner configs to generate credentials from.
+	ErrNoConfig = errors.New("no STUNner configuration available")
+	// ErrNoListener is returned when no listener matches the request.
+	ErrNoListener = errors.New("no valid listener found")
+	// ErrForbidden is returned when the request is not authorized.
+	ErrForbidden = errors.New("forbidden")
+	// ErrUnavailable is returned when the request cannot be authorized because an external
+	// service, e.g., the authorization webhook, is unavailable.
+	ErrUnavailable = errors.New("service unavailable")
+)
+
+// Options specifies the environment for credential generation.
+type Options struct {
+	// PublicAddr is the public address to use for all listeners, unless overridden by the
+	// request or the address overrider (optional).
+	PublicAddr string
+	// AddressOverrider overrides the public address and port of individual listeners, unless
+	// overridden by the request (optional).
+	AddressOverrider AddressOverrider
+	// AddressEnricher fills in the public address and port of the listeners that have no
+	// public address from any other source, e.g., from the Kubernetes API (optional).
+	AddressEnricher AddressOverrider
+	// CertHostname sets the public address of the TLS and DTLS listeners with no hostname in
+	// the address overrides to the first DNS name in the SAN of the listener certificate, so
+	// that clients can validate the certificate of the TURN server.
+	CertHostname bool
+	// Certificates caches the parsed listener certificates, so that each certificate is parsed
+	// once and problems with it are reported at error or warning severity only once (optional).
+	Certificates *CertificateCache
+	// Taggers return the tags of the listeners, matched against the label selector in the
+	// request. Later taggers override the same tags of the earlier ones.
+	Taggers []Tagger
+	// Now returns the current time, used to compute ephemeral usernames. Default is time.Now.
+	Now func() time.Time
+	// ListenerFilters are called in order for each listener that matches the request; the
+	// listener is excluded if any of the filters rejects it.
+	ListenerFilters []ListenerFilter
+	// ResponseMutators are called in order on the final ICE config.
+	ResponseMutators []ResponseMutator
+	// ListenerScorers are called for each listener that passes the listener filters; the score
+	// of a listener is the sum of the scores. TURN URIs are ordered by decreasing score within
+	// each ICE server, ICE servers are ordered by the highest score of their TURN URIs, and TURN
+	// REST API responses are generated only from the ICE servers with the highest score.
+	ListenerScorers []ListenerScorer
+	// Unroutable specifies the handling of the TURN URIs with an unroutable address, e.g.,
+	// 0.0.0.0 or a loopback address. Default is to return all TURN URIs.
+	Unroutable UnroutablePolicy
+	// FallbackAddr is the address substituted for unroutable addresses by the
+	// UnroutableFallback action. TURN URIs are dropped if no fallback address is set.
+	FallbackAddr string
+	// NodeAddresser resolves the node address placeholder of the listeners with relay address
+	// discovery and no public address to the addresses of the Kubernetes nodes (optional).
+	NodeAddresser NodeAddresser
+	// NodeSelector selects a single node for the listeners of each Gateway with a node address
+	// placeholder, using the default policy of the selector keyed on the client IP, or the
+	// username if the client IP is unknown. Default is to generate a TURN URI for each node.
+	NodeSelector *Selector
+	// Selector selects the TURN server for TURN REST API responses when multiple STUNner
+	// configs match the request. Default is to select the TURN server generated from the STUNner
+	// config with the lexicographically smallest name.
+	Selector *Selector
+}
+
+// GetIceConfig generates an ICE config from the given STUNner configs. The returned ICE config
+// contains a separate ICE server for each STUNner config that has at least one listener matching
+// the request, except that STUNner configs that yield identical credentials (e.g., the same
+// static username/password or the same ephemeral shared secret) are merged into a single ICE
+// server. Diagnostics are returned even if credential generation fails.
+func GetIceConfig(configs []*stnrv1.StunnerConfig, req Request, opts Options) (*types.IceConfig, Diagnostics, error) {
+	iceConfig, _, diags, err := getIceConfig(configs, req, opts, nil)
+	return iceConfig, diags, err
+}
+
+// serverInfo describes an ICE server.
+type serverInfo struct {
+	// name is the name of the STUNner config the ICE server was generated from. Merged ICE
+	// servers are named after the STUNner config with the lexicographically smallest name.
+	name string
+	// score is the highest score of the TURN URIs of the ICE server.
+	score int
+	// username and credential identify the ICE server: ICE servers with identical credentials
+	// are merged.
+	username, credential string
+}
+
+// iceServer is an ICE server along with its description.
+type iceServer struct {
+	token types.IceAuthenticationToken
+	info  serverInfo
+}
+
+// lookupServerInfo returns the description of an ICE server. Response mutators may rebuild the
+// ICE servers, so the ICE servers are identified by their credentials.
+func lookupServerInfo(infos []serverInfo, s types.IceAuthenticationToken) serverInfo {
+	for _, i := range infos {
+		if s.Username != nil && s.Credential != nil && i.username == *s.Username &&
+			i.credential == *s.Credential {
+			return i
+		}
+	}
+	return serverInfo{}
+}
+
+// getIceConfig generates an ICE config and also returns information on the ICE servers, in the
+// order of the ICE servers before the response mutators are called. If an explanation is given,
+// the decisions are recorded in the explanation and no credentials are generated.
+func getIceConfig(configs []*stnrv1.StunnerConfig, req Request, opts Options, explain *Explanation) (*types.IceConfig, []serverInfo, Diagnostics, error) {
+	if len(configs) == 0 {
+		return nil, nil, Diagnostics{}, ErrNoConfig
+	}
+
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	// invalid selectors are reported for each listener
+	_ = req.parseSelector()
+
+	g := &generator{req: req, opts: opts, now: opts.Now(), diags: Diagnostics{},
+		scores: map[string]int{}, ranks: map[string]int{}, nodes: map[string]string{}, explain: explain}
+	servers := g.generate(configs)
+	if unavailable := g.unavailablePreferred(); len(unavailable) > 0 {
+		// some listeners were excluded in favor of a Gateway that yields no TURN URIs: retry with
+		// these listeners used as a fallback
+		g.reset()
+		g.fallback = unavailable
+		g.diags.info("", "", "no TURN URIs for the preferred Gateway(s) %s: falling back to the "+
+			"listeners excluded in their favor", strings.Join(unavailable, ", "))
+		servers = g.generate(configs)
+	}
+
+	// order by score, then by rank in the client profile
+	for i := range servers {
+		uris := *servers[i].token.Urls
+		slices.SortStableFunc(uris, func(a, b string) int {
+			if d := g.scores[b] - g.scores[a]; d != 0 {
+				return d
+			}
+			return g.ranks[a] - g.ranks[b]
+		})
+		servers[i].info.score = g.scores[uris[0]]
+	}
+	slices.SortStableFunc(servers, func(a, b iceServer) int {
+		return b.info.score - a.info.score
+	})
+
+	iceServers := make([]types.IceAuthenticationToken, len(servers))
+	infos := make([]serverInfo, len(servers))
+	for i, s := range servers {
+		iceServers[i], infos[i] = s.token, s.info
+	}
+
+	policy := req.IceTransportPolicy
+	if policy == "" {
+		policy = types.All
+	}
+
+	iceConfig := types.IceConfig{
+		IceServers:         &iceServers,
+		IceTransportPolicy: &policy,
+	}
+
+	for _, m := range opts.ResponseMutators {
+		if err := m.MutateIceConfig(&req, &iceConfig); err != nil {
+			return nil, nil, g.diags, err
+		}
+	}
+
+	if iceConfig.IceServers == nil || len(*iceConfig.IceServers) == 0 {
+		if len(g.denials) > 0 {
+			return &iceConfig, infos, g.diags, fmt.Errorf("%w: %s", ErrForbidden,
+				strings.Join(g.denials, "; "))
+		}
+		return &iceConfig, infos, g.diags, ErrNoListener
+	}
+
+	return &iceConfig, infos, g.diags, nil
+}
+
+// GetTurnAuthToken generates a TURN REST API authentication token from the given STUNner
+// configs. Since a token carries a single username/password pair, the token contains the TURN
+// URIs of the STUNner configs that yield identical credentials. If the request matches STUNner
+// configs with differing credentials, only one of them is considered, chosen by the selector in
+// the options using the selection policy in the request.
+func GetTurnAuthToken(configs []*stnrv1.StunnerConfig, req Request, opts Options) (*types.TurnAuthenticationToken, Diagnostics, error) {
+	ice, infos, diags, err := getIceConfig(configs, req, opts, nil)
+	if err != nil {
+		return nil, diags, err
+	}
+	return turnAuthToken(ice, infos, req, opts, &diags), diags, nil
+}
+
+// turnAuthToken generates a TURN REST API authentication token from the ICE server selected from
+// an ICE config.
+func turnAuthToken(ice *types.IceConfig, infos []serverInfo, req Request, opts Options, diags *Diagnostics) *types.TurnAuthenticationToken {
+	// consider only the servers with the highest score
+	servers, candidates := []types.IceAuthenticationToken{}, []string{}
+	score := 0
+	for _, s := range *ice.IceServers {
+		i := lookupServerInfo(infos, s)
+		if len(servers) == 0 {
+			score = i.score
+		}
+		if i.score == score {
+			servers = append(servers, s)
+			candidates = append(candidates, i.name)
+		}
+	}
+
+	selected := 0
+	if len(servers) > 1 {
+
+		selector := opts.Selector
+		if selector == nil {
+			selector = &Selector{}
+		}
+		selected = selector.Select(req.Selection, req.Username, candidates)
+
+		diags.info(candidates[selected], "", "multiple TURN servers available: generating "+
+			"credentials only for the selected one (candidates: %s)", strings.Join(candidates, ", "))
+	}
+
+	duration := int64(req.ttl().Seconds())
+	return &types.TurnAuthenticationToken{
+		Username: servers[selected].Username,
+		Password: servers[selected].Credential,
+		Ttl:      &duration,
+		Uris:     servers[selected].Urls,
+	}
+}
+
+func (r *Request) ttl() time.Duration {
+	if r.TTL > 0 {
+		return r.TTL
+	}
+	return DefaultTTL
+}
+
+// generator holds the state of a single credential generation run.
+type generator struct {
+	req  Request
+	opts Options
+	// now is the time used for generating the credentials, the same for all STUNner configs so
+	// that configs with the same ephemeral secret yield identical credentials.
+	now   time.Time
+	diags Diagnostics
+	// scores maps the generated TURN URIs to the highest score of the listeners they were
+	// generated from.
+	scores map[string]int
+	// ranks maps the generated TURN URIs to the lowest rank of the listeners they were
+	// generated from in the client profile of the request.
+	ranks map[string]int
+	// nodes maps the Gateways to the node chosen by the node selector, so that all listeners of
+	// a Gateway resolve to the same node.
+	nodes map[string]string
+	// denials collects the unique reasons listener filters gave for denying listeners.
+	denials []string
+	// preferred collects the Gateways listeners were excluded in favor of with a FallbackError.
+	preferred []string
+	// gateways collects the Gateways that yield TURN URIs.
+	gateways []string
+	// fallback lists the preferred Gateways that yield no TURN URIs in the second pass over the
+	// STUNner configs: the listeners excluded in their favor are used.
+	fallback []string
+	// explain records the decisions in explain mode, nil otherwise.
+	explain *Explanation
+	// cur is the explanation of the current listener in explain mode, nil otherwise.
+	cur *ListenerExplanation
+}
+
+// generate generates an ICE server for each STUNner config, merging the ICE servers with
+// identical credentials.
+func (g *generator) generate(configs []*stnrv1.StunnerConfig) []iceServer {
+	servers := []iceServer{}
+
+	// try to generate an iceconfig for each config
+	for _, c := range configs {
+		ice, err := g.getIceServerConfForStunnerConf(c)
+		if err != nil {
+			g.diags.error(c.Admin.Name, "", "cannot generate ICE server config for STUNner config: %s",
+				err.Error())
+			continue
+		}
+
+		if ice == nil {
+			continue
+		}
+
+		// merge with the ICE server with the same credentials, if any
+		i := slices.IndexFunc(servers, func(s iceServer) bool {
+			return s.info.username == *ice.Username && s.info.credential == *ice.Credential
+		})
+		if i < 0 {
+			servers = append(servers, iceServer{token: *ice, info: serverInfo{
+				name:       c.Admin.Name,
+				username:   *ice.Username,
+				credential: *ice.Credential,
+			}})
+			continue
+		}
+
+		merged := &servers[i]
+		g.diags.info(c.Admin.Name, "", "merging TURN URIs into the ICE server of STUNner config %s: "+
+			"identical credentials", merged.info.name)
+		for _, uri := range *ice.Urls {
+			if !slices.Contains(*merged.token.Urls, uri) {
+				*merged.token.Urls = append(*merged.token.Urls, uri)
+			}
+		}
+		if c.Admin.Name < merged.info.name {
+			merged.info.name = c.Admin.Name
+		}
+	}
+
+	return servers
+}
+
+// reset clears the state of the generator for another pass over the STUNner configs.
+func (g *generator) reset() {
+	g.diags, g.denials, g.preferred, g.gateways = Diagnostics{}, nil, nil, nil
+	g.scores, g.ranks = map[string]int{}, map[string]int{}
+	if g.explain != nil {
+		g.explain.Configs = nil
+	}
+	g.cur = nil
+}
+
+// unavailablePreferred returns the Gateways listeners were excluded in favor of that yield no
+// TURN URIs.
+func (g *generator) unavailablePreferred() []string {
+	ret := []string{}
+	for _, gw := range g.preferred {
+		if !slices.Contains(g.gateways, gw) {
+			ret = append(ret, gw)
+		}
+	}
+	return ret
+}
+
+func (g *generator) getIceServerConfForStunnerConf(stunnerConfig *stnrv1.StunnerConfig) (*types.IceAuthenticationToken, error) {
+	req, opts, diags := &g.req, &g.opts, &g.diags
+	name := stunnerConfig.Admin.Name
+	g.traceConfig(stunnerConfig)
+
+	// should we generate an ICE server config for this stunner config?
+	uris := []string{}
+	for _, l := range stunnerConfig.Listeners {
+		l := l
+		g.traceListener(&l)
+
+		// format is namespace/gateway/listener
+		tokens := strings.Split(l.Name, "/")
+		if len(tokens) != 3 {
+			diags.error(name, l.Name, `invalid listener: name should be "namespace/gateway/listener"`)
+			g.traceExclude("invalid listener name")
+			continue
+		}
+		namespace, gateway, listener := tokens[0], tokens[1], tokens[2]
+
+		g.resolveAddress(name, &l)
+
+		reason := matchListener(req, namespace, gateway, listener)
+		if reason == "" {
+			reason = g.matchTags(&l)
+		}
+		if reason == "" {
+			reason = matchTransport(req, &l)
+		}
+		if reason != "" {
+			diags.info(name, l.Name, "ignoring listener due to %s", reason)
+			g.traceExclude(reason)
+			continue
+		}
+
+		for _, l := range g.expandListener(name, l) {
+			if !g.checkRoutable(name, &l) {
+				continue
+			}
+
+			if err := filterListener(req, stunnerConfig, &l, opts.ListenerFilters, g.fallback); err != nil {
+				var denial *DenialError
+				if errors.As(err, &denial) && !slices.Contains(g.denials, denial.Reason) {
+					g.denials = append(g.denials, denial.Reason)
+				}
+				var fallback *FallbackError
+				if errors.As(err, &fallback) && !slices.Contains(g.preferred, fallback.Preferred) {
+					g.preferred = append(g.preferred, fallback.Preferred)
+				}
+				diags.info(name, l.Name, "ignoring listener due to listener filter: %s", err.Error())
+				g.traceExclude("listener filter: " + err.Error())
+				continue
+			}
+
+			g.checkCertificate(name, &l)
+
+			uri, err := listenerURI(&l)
+			if err != nil {
+				diags.error(name, l.Name, "cannot generate URI for listener: %s", err.Error())
+				g.traceExclude("cannot generate URI: " + err.Error())
+				continue
+			}
+
+			uris = append(uris, uri)
+			g.traceURI(uri)
+			if gw := namespace + "/" + gateway; !slices.Contains(g.gateways, gw) {
+				g.gateways = append(g.gateways, gw)
+			}
+			score := scoreListener(req, stunnerConfig, &l, opts.ListenerScorers)
+			if s, ok := g.scores[uri]; !ok || score > s {
+				g.scores[uri] = score
+			}
+			rank := rankListener(req, &l)
+			if r, ok := g.ranks[uri]; !ok || rank < r {
+				g.ranks[uri] = rank
+			}
+		}
+	}
+
+	if len(uris) == 0 {
+		return nil, nil
+	}
+
+	if g.explain != nil {
+		return g.explainCredentials(stunnerConfig, uris)
+	}
+
+	username, password, err := getCredentials(stunnerConfig.Auth, req.Username, req.ttl(), g.now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.IceAuthenticationToken{
+		Username:   &username,
+		Credential: &password,
+		Urls:       &uris,
+	}, nil
+}
+
+// expandListener returns the listeners to generate TURN URIs for from a listener: one listener
+// per node address for node address placeholders and one listener per address for dual-stack
+// public addresses, restricted to the address family of the request.
+func (g *generator) expandListener(name string, l stnrv1.ListenerConfig) []stnrv1.ListenerConfig {
+	ret := []stnrv1.ListenerConfig{}
+	for _, l := range g.resolveNodeAddress(name, l) {
+		ret = append(ret, g.splitAddresses(name, l)...)
+	}
+	return ret
+}
+
+// CandidateGateways returns the sorted list of the Gateways, in the form "namespace/gateway",
+// that have at least one listener matching the filters in the request.
+func CandidateGateways(configs []*stnrv1.StunnerConfig, req Request) []string {
+	if err := req.parseSelector(); err != nil {
+		return []string{}
+	}
+
+	ret := []string{}
+	for _, c := range configs {
+		for _, l := range c.Listeners {
+			tokens := strings.Split(l.Name, "/")
+			if len(tokens) != 3 {
+				continue
+			}
+			if matchListener(&req, tokens[0], tokens[1], tokens[2]) != "" {
+				continue
+			}
+			gw := tokens[0] + "/" + tokens[1]
+			if !slices.Contains(ret, gw) {
+				ret = append(ret, gw)
+			}
+		}
+	}
+	slices.Sort(ret)
+	return ret
+}
+
+// filterListener runs the listener filters. A FallbackError is returned only if no other filter
+// excludes the listener, and ignored if the preferred Gateway is listed in fallback.
+func filterListener(req *Request, c *stnrv1.StunnerConfig, l *stnrv1.ListenerConfig, filters []ListenerFilter, fallback []string) error {
+	var deferred error
+	for _, f := range filters {
+		err := f.FilterListener(req, c, l)
+		var fe *FallbackError
+		if errors.As(err, &fe) {
+			if deferred == nil && !slices.Contains(fallback, fe.Preferred) {
+				deferred = err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return deferred
+}
+
+func scoreListener(req *Request, c *stnrv1.StunnerConfig, l *stnrv1.ListenerConfig, scorers []ListenerScorer) int {
+	score := 0
+	for _, s := range scorers {
+		score += s.ScoreListener(req, c, l)
+	}
+	return score
+}
+
+// getCredentials generates a username/password pair for the given auth config.
+func getCredentials(auth stnrv1.AuthConfig, userid string, ttl time.Duration, now time.Time) (string, string, error) {
+	atype, err := checkAuth(auth)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch atype {
+	case stnrv1.AuthTypePlainText:
+		return auth.Credentials["username"], auth.Credentials["password"], nil
+
+	case stnrv1.AuthTypeLongTerm:
+		username := a12n.GenerateTimeWindowedUsername(now, ttl, userid)
+		p, err := a12n.GetLongTermCredential(username, auth.Credentials["secret"])
+		if err != nil {
+			return "", "", fmt.Errorf("cannot generate longterm credential: %w", err)
+		}
+		return username, p, nil
+	}
+
+	return "", "", fmt.Errorf("internal server error: unknown auth type %q", auth.Type)
+}
+
+// checkAuth checks an auth config and returns the auth type.
+func checkAuth(auth stnrv1.AuthConfig) (stnrv1.AuthType, error) {
+	authType := auth.Type
+
+	// aliases
+	switch authType {
+	// plaintext
+	case "static", "plaintext":
+		authType = "plaintext"
+	case "ephemeral", "timewindowed", "longterm":
+		authType = "longterm"
+	}
+
+	atype, err := stnrv1.NewAuthType(authType)
+	if err != nil {
+		return atype, fmt.Errorf("internal server error: %w", err)
+	}
+
+	switch atype {
+	case stnrv1.AuthTypePlainText:
+		_, userFound := auth.Credentials["username"]
+		_, passFound := auth.Credentials["password"]
+		if !userFound || !passFound {
+			return atype, errors.New("invalid STUNner config: no username or password " +
+				"(auth: plaintext)")
+		}
+	case stnrv1.AuthTypeLongTerm:
+		if _, secretFound := auth.Credentials["secret"]; !secretFound {
+			return atype, errors.New("invalid STUNner config: no shared secret (auth: longterm)")
+		}
+	}
+
+	return atype, nil
+}
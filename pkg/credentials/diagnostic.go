@@ -0,0 +1,97 @@
+package credentials
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Severity is the severity of a diagnostic.
+type Severity string
+
+const (
+	// SeverityInfo marks diagnostics that record a decision, e.g., a listener ignored due to a
+	// filter mismatch.
+	SeverityInfo Severity = "info"
+	// SeverityWarning marks diagnostics that report a likely misconfiguration that does not
+	// prevent credential generation, e.g., a certificate that does not cover the advertised
+	// address.
+	SeverityWarning Severity = "warning"
+	// SeverityError marks diagnostics that report a problem, e.g., an invalid STUNner config.
+	SeverityError Severity = "error"
+)
+
+// Diagnostic reports a decision made or a problem encountered while generating credentials.
+type Diagnostic struct {
+	// Severity is the severity of the diagnostic.
+	Severity Severity `json:"severity"`
+	// Config is the name of the STUNner config the diagnostic pertains to, if any.
+	Config string `json:"config,omitempty"`
+	// Listener is the name of the listener the diagnostic pertains to, if any.
+	Listener string `json:"listener,omitempty"`
+	// Message is a human-readable description.
+	Message string `json:"message"`
+	// Unroutable describes the unroutable address of the listener, if the diagnostic reports
+	// the handling of a TURN URI with an unroutable address.
+	Unroutable *Unroutable `json:"unroutable,omitempty"`
+}
+
+// String returns a string representation of the diagnostic.
+func (d Diagnostic) String() string {
+	ret := d.Message
+	if d.Listener != "" {
+		ret = fmt.Sprintf("listener %q: %s", d.Listener, ret)
+	}
+	if d.Config != "" {
+		ret = fmt.Sprintf("config %q: %s", d.Config, ret)
+	}
+	return ret
+}
+
+// Diagnostics is a list of diagnostics.
+type Diagnostics []Diagnostic
+
+func (ds *Diagnostics) add(severity Severity, config, listener, format string, args ...any) {
+	*ds = append(*ds, Diagnostic{
+		Severity: severity,
+		Config:   config,
+		Listener: listener,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (ds *Diagnostics) info(config, listener, format string, args ...any) {
+	ds.add(SeverityInfo, config, listener, format, args...)
+}
+
+func (ds *Diagnostics) warning(config, listener, format string, args ...any) {
+	ds.add(SeverityWarning, config, listener, format, args...)
+}
+
+func (ds *Diagnostics) error(config, listener, format string, args ...any) {
+	ds.add(SeverityError, config, listener, format, args...)
+}
+
+func (ds *Diagnostics) unroutable(config, listener string, u *Unroutable, format string, args ...any) {
+	ds.info(config, listener, format, args...)
+	(*ds)[len(*ds)-1].Unroutable = u
+}
+
+// Unroutable returns the diagnostics that report the handling of TURN URIs with an unroutable
+// address.
+func (ds Diagnostics) Unroutable() Diagnostics {
+	ret := Diagnostics{}
+	for _, d := range ds {
+		if d.Unroutable != nil {
+			ret = append(ret, d)
+		}
+	}
+	return ret
+}
+
+func stringify(p any) string {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Sprintf("<error: %s>", err.Error())
+	}
+	return string(b)
+}
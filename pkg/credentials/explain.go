@@ -0,0 +1,190 @@
+package credentials
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+// AddressSource is the source of the address in the TURN URIs of a listener.
+type AddressSource string
+
+const (
+	// AddressSourceListener is the address of the listener in the STUNner config.
+	AddressSourceListener AddressSource = "listener"
+	// AddressSourceRequest is the public address in the request.
+	AddressSourceRequest AddressSource = "request"
+	// AddressSourceOverride is the public address from the address overrider.
+	AddressSourceOverride AddressSource = "override"
+	// AddressSourceEnvironment is the public address in the options, e.g., from the
+	// environment.
+	AddressSourceEnvironment AddressSource = "environment"
+	// AddressSourceEnricher is the public address from the address enricher.
+	AddressSourceEnricher AddressSource = "enricher"
+	// AddressSourceHostname is the hostname from the address overrider.
+	AddressSourceHostname AddressSource = "hostname"
+	// AddressSourceCertificate is the hostname from the SAN of the listener certificate.
+	AddressSourceCertificate AddressSource = "certificate"
+	// AddressSourceNode is the address of a Kubernetes node.
+	AddressSourceNode AddressSource = "node"
+	// AddressSourceFallback is the fallback address substituted for an unroutable address.
+	AddressSourceFallback AddressSource = "fallback"
+)
+
+// Explanation reports the decisions made while generating credentials, without the credentials.
+type Explanation struct {
+	// Request is the request explained.
+	Request Request `json:"request"`
+	// Configs explains the decisions for each STUNner config.
+	Configs []ConfigExplanation `json:"configs"`
+	// URIs is the list of the TURN URIs that would be returned, in order.
+	URIs []string `json:"uris"`
+	// Error is the error credential generation would fail with, if any.
+	Error string `json:"error,omitempty"`
+	// Diagnostics is the list of all the diagnostics.
+	Diagnostics Diagnostics `json:"diagnostics"`
+}
+
+// ConfigExplanation explains the decisions for a STUNner config.
+type ConfigExplanation struct {
+	// Name is the name of the STUNner config.
+	Name string `json:"name"`
+	// AuthType is the authentication type of the STUNner config.
+	AuthType string `json:"authType"`
+	// AuthError is the error in the authentication config, if any.
+	AuthError string `json:"authError,omitempty"`
+	// Listeners explains the decisions for each listener.
+	Listeners []ListenerExplanation `json:"listeners"`
+}
+
+// ListenerExplanation explains the decisions for a listener.
+type ListenerExplanation struct {
+	// Name is the name of the listener.
+	Name string `json:"name"`
+	// Protocol is the protocol of the listener.
+	Protocol string `json:"protocol"`
+	// Included is true if at least one TURN URI was generated from the listener.
+	Included bool `json:"included"`
+	// Excluded lists the reasons the listener, or some of its addresses, were excluded.
+	Excluded []string `json:"excluded,omitempty"`
+	// AddressSource is the source of the address in the TURN URIs of the listener.
+	AddressSource AddressSource `json:"addressSource"`
+	// URIs is the list of the TURN URIs generated from the listener.
+	URIs []string `json:"uris,omitempty"`
+}
+
+// ExplainIceConfig explains the generation of an ICE config from the given STUNner configs, as
+// done by GetIceConfig, without generating credentials.
+func ExplainIceConfig(configs []*stnrv1.StunnerConfig, req Request, opts Options) *Explanation {
+	e := &Explanation{Request: req, Configs: []ConfigExplanation{}, URIs: []string{}}
+	iceConfig, _, diags, err := getIceConfig(configs, req, opts, e)
+	if iceConfig != nil && iceConfig.IceServers != nil {
+		for _, s := range *iceConfig.IceServers {
+			e.URIs = append(e.URIs, *s.Urls...)
+		}
+	}
+	return e.finish(diags, err)
+}
+
+// ExplainTurnAuthToken explains the generation of a TURN REST API authentication token from the
+// given STUNner configs, as done by GetTurnAuthToken, without generating credentials.
+func ExplainTurnAuthToken(configs []*stnrv1.StunnerConfig, req Request, opts Options) *Explanation {
+	e := &Explanation{Request: req, Configs: []ConfigExplanation{}, URIs: []string{}}
+	iceConfig, infos, diags, err := getIceConfig(configs, req, opts, e)
+	if err == nil {
+		token := turnAuthToken(iceConfig, infos, req, opts, &diags)
+		e.URIs = append(e.URIs, *token.Uris...)
+	}
+	return e.finish(diags, err)
+}
+
+func (e *Explanation) finish(diags Diagnostics, err error) *Explanation {
+	if err != nil {
+		e.Error = err.Error()
+	}
+	e.Diagnostics = diags
+	for i := range e.Configs {
+		for j := range e.Configs[i].Listeners {
+			l := &e.Configs[i].Listeners[j]
+			l.Included = len(l.URIs) > 0
+		}
+	}
+	return e
+}
+
+// traceConfig starts explaining a STUNner config, if explaining.
+func (g *generator) traceConfig(c *stnrv1.StunnerConfig) {
+	if g.explain == nil {
+		return
+	}
+	e := ConfigExplanation{
+		Name:      c.Admin.Name,
+		AuthType:  c.Auth.Type,
+		Listeners: []ListenerExplanation{},
+	}
+	if atype, err := checkAuth(c.Auth); err != nil {
+		e.AuthError = err.Error()
+	} else {
+		e.AuthType = atype.String()
+	}
+	g.explain.Configs = append(g.explain.Configs, e)
+}
+
+// traceListener starts explaining a listener of the current STUNner config, if explaining.
+func (g *generator) traceListener(l *stnrv1.ListenerConfig) {
+	if g.explain == nil {
+		return
+	}
+	c := &g.explain.Configs[len(g.explain.Configs)-1]
+	c.Listeners = append(c.Listeners, ListenerExplanation{
+		Name:          l.Name,
+		Protocol:      l.Protocol,
+		AddressSource: AddressSourceListener,
+	})
+	g.cur = &c.Listeners[len(c.Listeners)-1]
+}
+
+// traceSource records the address source of the current listener, if explaining.
+func (g *generator) traceSource(s AddressSource) {
+	if g.cur != nil {
+		g.cur.AddressSource = s
+	}
+}
+
+// traceExclude records a reason for excluding the current listener, if explaining.
+func (g *generator) traceExclude(reason string) {
+	if g.cur != nil {
+		g.cur.Excluded = append(g.cur.Excluded, reason)
+	}
+}
+
+// traceURI records a TURN URI generated from the current listener, if explaining.
+func (g *generator) traceURI(uri string) {
+	if g.cur != nil {
+		g.cur.URIs = append(g.cur.URIs, uri)
+	}
+}
+
+// explainCredentials checks the authentication config of a STUNner config in place of generating
+// credentials. The returned ICE server carries no credentials, but a digest of the authentication
+// config so that the ICE servers are merged the same way as with real credentials.
+func (g *generator) explainCredentials(c *stnrv1.StunnerConfig, uris []string) (*types.IceAuthenticationToken, error) {
+	atype, err := checkAuth(c.Auth)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	for _, k := range []string{"username", "password", "secret"} {
+		h.Write([]byte(k + "=" + c.Auth.Credentials[k] + "\x00"))
+	}
+	username, digest := atype.String(), hex.EncodeToString(h.Sum(nil))
+	return &types.IceAuthenticationToken{
+		Username:   &username,
+		Credential: &digest,
+		Urls:       &uris,
+	}, nil
+}
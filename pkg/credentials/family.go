@@ -0,0 +1,124 @@
+package credentials
+
+import (
+	"cmp"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/l7mp/stunner"
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+)
+
+// AddressFamily restricts the TURN URIs to an address family.
+type AddressFamily string
+
+const (
+	// AddressFamilyIPv4 returns only the TURN URIs with an IPv4 address or a hostname.
+	AddressFamilyIPv4 AddressFamily = "ipv4"
+	// AddressFamilyIPv6 returns only the TURN URIs with an IPv6 address or a hostname.
+	AddressFamilyIPv6 AddressFamily = "ipv6"
+	// AddressFamilyDual returns the TURN URIs of both address families.
+	AddressFamilyDual AddressFamily = "dual"
+)
+
+// NewAddressFamily parses an address family.
+func NewAddressFamily(family string) (AddressFamily, error) {
+	switch f := AddressFamily(strings.ToLower(family)); f {
+	case AddressFamilyIPv4, AddressFamilyIPv6, AddressFamilyDual:
+		return f, nil
+	}
+	return "", fmt.Errorf("%w: unknown address family %q", ErrInvalidRequest, family)
+}
+
+// SplitPublicAddr splits a public address into the individual addresses. Dual-stack public
+// addresses are given as a comma-separated list, e.g., "1.2.3.4,2001:db8::1".
+func SplitPublicAddr(addr string) []string {
+	ret := []string{}
+	for _, a := range strings.Split(addr, ",") {
+		if a = strings.TrimSpace(a); a != "" && !slices.Contains(ret, a) {
+			ret = append(ret, a)
+		}
+	}
+	return ret
+}
+
+// DualStackAddr returns the public address for a list of addresses: the first IPv4 and the first
+// IPv6 address, separated by a comma, or the first hostname if there are no IP addresses.
+func DualStackAddr(addrs []string) string {
+	var v4, v6, host string
+	for _, a := range addrs {
+		switch familyOf(a) {
+		case AddressFamilyIPv4:
+			v4 = cmp.Or(v4, a)
+		case AddressFamilyIPv6:
+			v6 = cmp.Or(v6, a)
+		default:
+			host = cmp.Or(host, a)
+		}
+	}
+	switch {
+	case v4 != "" && v6 != "":
+		return v4 + "," + v6
+	case v4 != "" || v6 != "":
+		return v4 + v6
+	}
+	return host
+}
+
+// familyOf returns the address family of an address, or an empty string for hostnames.
+func familyOf(addr string) AddressFamily {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return ""
+	}
+	if ip.Unmap().Is4() {
+		return AddressFamilyIPv4
+	}
+	return AddressFamilyIPv6
+}
+
+// splitAddresses splits a listener with a dual-stack public address into one listener per
+// address, and drops the listeners with an address not in the address family of the request.
+// Hostnames match any address family.
+func (g *generator) splitAddresses(name string, l stnrv1.ListenerConfig) []stnrv1.ListenerConfig {
+	req, diags := &g.req, &g.diags
+
+	ls := []stnrv1.ListenerConfig{l}
+	if addrs := SplitPublicAddr(l.PublicAddr); len(addrs) > 1 {
+		ls = make([]stnrv1.ListenerConfig, len(addrs))
+		for i, a := range addrs {
+			ls[i] = l
+			ls[i].PublicAddr = a
+		}
+	}
+
+	if req.AddressFamily == "" || req.AddressFamily == AddressFamilyDual {
+		return ls
+	}
+
+	ret := []stnrv1.ListenerConfig{}
+	for _, l := range ls {
+		addr := listenerAddr(&l)
+		if f := familyOf(addr); f != "" && f != req.AddressFamily {
+			diags.info(name, l.Name, "ignoring address %s due to address family mismatch: "+
+				"required-family: %s, address-family: %s", addr, req.AddressFamily, f)
+			g.traceExclude(fmt.Sprintf("address family mismatch: address: %s, required-family: %s",
+				addr, req.AddressFamily))
+			continue
+		}
+		ret = append(ret, l)
+	}
+	return ret
+}
+
+// listenerURI returns the TURN URI of a listener, with IPv6 addresses enclosed in brackets.
+func listenerURI(l *stnrv1.ListenerConfig) (string, error) {
+	if addr := listenerAddr(l); familyOf(addr) == AddressFamilyIPv6 {
+		bracketed := *l
+		bracketed.PublicAddr = "[" + addr + "]"
+		l = &bracketed
+	}
+	return stunner.GetUriFromListener(l)
+}
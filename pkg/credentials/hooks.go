@@ -0,0 +1,115 @@
+package credentials
+
+import (
+	"fmt"
+	"slices"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+// ListenerFilter decides whether TURN URIs should be generated for a listener. The listener
+// passed in has its public address already resolved. Returning a non-nil error excludes the
+// listener, with the error message recorded as the reason in the diagnostics.
+type ListenerFilter interface {
+	FilterListener(req *Request, config *stnrv1.StunnerConfig, listener *stnrv1.ListenerConfig) error
+}
+
+// DenialError can be returned by listener filters to deny, rather than merely filter out, a
+// listener. If no listener remains for a request, the request is rejected with ErrForbidden and
+// the reasons of all denials.
+type DenialError struct {
+	// Reason is a human-readable explanation of the denial.
+	Reason string
+}
+
+// Error implements the error interface.
+func (e *DenialError) Error() string { return "denied: " + e.Reason }
+
+// Is reports that a denial is a case of ErrForbidden.
+func (e *DenialError) Is(target error) bool { return target == ErrForbidden }
+
+// FallbackError can be returned by listener filters to exclude a listener in favor of another
+// Gateway: the listener is excluded only if the preferred Gateway yields at least one TURN URI
+// for the request, otherwise the listener is used as a fallback.
+type FallbackError struct {
+	// Reason is a human-readable explanation of the exclusion.
+	Reason string
+	// Preferred is the Gateway preferred over the listener, in the form "namespace/gateway".
+	Preferred string
+}
+
+// Error implements the error interface.
+func (e *FallbackError) Error() string { return e.Reason }
+
+// ListenerFilterFunc is an adapter to allow the use of ordinary functions as listener filters.
+type ListenerFilterFunc func(req *Request, config *stnrv1.StunnerConfig, listener *stnrv1.ListenerConfig) error
+
+// FilterListener calls f(req, config, listener).
+func (f ListenerFilterFunc) FilterListener(req *Request, config *stnrv1.StunnerConfig, listener *stnrv1.ListenerConfig) error {
+	return f(req, config, listener)
+}
+
+// ListenerScorer ranks the listeners that pass the listener filters: TURN URIs generated from
+// listeners with higher scores are returned first.
+type ListenerScorer interface {
+	ScoreListener(req *Request, config *stnrv1.StunnerConfig, listener *stnrv1.ListenerConfig) int
+}
+
+// ListenerScorerFunc is an adapter to allow the use of ordinary functions as listener scorers.
+type ListenerScorerFunc func(req *Request, config *stnrv1.StunnerConfig, listener *stnrv1.ListenerConfig) int
+
+// ScoreListener calls f(req, config, listener).
+func (f ListenerScorerFunc) ScoreListener(req *Request, config *stnrv1.StunnerConfig, listener *stnrv1.ListenerConfig) int {
+	return f(req, config, listener)
+}
+
+// ResponseMutator edits the final ICE config before it is returned. Mutators run before the ICE
+// config is converted into a TURN REST API authentication token. Returning a non-nil error
+// fails the request.
+type ResponseMutator interface {
+	MutateIceConfig(req *Request, iceConfig *types.IceConfig) error
+}
+
+// ResponseMutatorFunc is an adapter to allow the use of ordinary functions as response mutators.
+type ResponseMutatorFunc func(req *Request, iceConfig *types.IceConfig) error
+
+// MutateIceConfig calls f(req, iceConfig).
+func (f ResponseMutatorFunc) MutateIceConfig(req *Request, iceConfig *types.IceConfig) error {
+	return f(req, iceConfig)
+}
+
+// ExcludeProtocols is a built-in listener filter that excludes listeners with the given
+// protocols, e.g., "turn-dtls". Returns an error if a protocol is invalid.
+func ExcludeProtocols(protocols ...string) (ListenerFilter, error) {
+	ps := []string{}
+	for _, p := range protocols {
+		proto, err := stnrv1.NewListenerProtocol(p)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, proto.String())
+	}
+
+	return ListenerFilterFunc(func(_ *Request, _ *stnrv1.StunnerConfig, l *stnrv1.ListenerConfig) error {
+		proto, err := stnrv1.NewListenerProtocol(l.Protocol)
+		if err != nil {
+			return nil
+		}
+		if slices.Contains(ps, proto.String()) {
+			return fmt.Errorf("protocol %s excluded", proto.String())
+		}
+		return nil
+	}), nil
+}
+
+// ForceIceTransportPolicy is a built-in response mutator that overrides the ICE transport policy
+// in all responses.
+func ForceIceTransportPolicy(policy types.IceTransportPolicy) ResponseMutator {
+	return ResponseMutatorFunc(func(_ *Request, iceConfig *types.IceConfig) error {
+		p := policy
+		iceConfig.IceTransportPolicy = &p
+		return nil
+	})
+}
@@ -0,0 +1,212 @@
+package credentials
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"sync"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+)
+
+// tlsListener checks whether a listener is a TLS or DTLS listener, i.e., one that yields a
+// "turns:" URI.
+func tlsListener(l *stnrv1.ListenerConfig) bool {
+	p, err := stnrv1.NewListenerProtocol(l.Protocol)
+	return err == nil && (p == stnrv1.ListenerProtocolTURNTLS || p == stnrv1.ListenerProtocolTURNDTLS)
+}
+
+// parseCert parses the base64-encoded PEM certificate of a listener.
+func parseCert(cert string) (*x509.Certificate, error) {
+	b, err := base64.StdEncoding.DecodeString(cert)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// maxCheckedAddrs is the maximum number of listener addresses the results of checking a
+// certificate are cached for.
+const maxCheckedAddrs = 256
+
+// CertificateCache caches the parsed listener certificates and the results of checking them
+// against the advertised addresses, so that each certificate is parsed only once and problems
+// with a certificate are reported at error or warning severity only the first time. Safe for
+// concurrent use; a nil cache parses and checks the certificates on each use.
+type CertificateCache struct {
+	certs map[string]*cachedCert
+	lock  sync.Mutex
+}
+
+// cachedCert is a parsed certificate in the cache.
+type cachedCert struct {
+	cert *x509.Certificate
+	err  error
+	// checked maps the listeners and the addresses the certificate was checked against to the
+	// result.
+	checked map[string]error
+}
+
+// NewCertificateCache creates an empty certificate cache.
+func NewCertificateCache() *CertificateCache {
+	return &CertificateCache{certs: map[string]*cachedCert{}}
+}
+
+// Retain removes the certificates that are not used by any listener of the given STUNner
+// configs from the cache. Call it whenever the STUNner configs change.
+func (c *CertificateCache) Retain(configs []*stnrv1.StunnerConfig) {
+	used := map[string]bool{}
+	for _, conf := range configs {
+		for _, l := range conf.Listeners {
+			if l.Cert != "" {
+				used[l.Cert] = true
+			}
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for cert := range c.certs {
+		if !used[cert] {
+			delete(c.certs, cert)
+		}
+	}
+}
+
+// parse parses a certificate, and reports whether this is the first time the certificate is
+// parsed.
+func (c *CertificateCache) parse(cert string) (*x509.Certificate, bool, error) {
+	if c == nil {
+		x, err := parseCert(cert)
+		return x, true, err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.certs[cert]
+	if !ok {
+		e = &cachedCert{checked: map[string]error{}}
+		e.cert, e.err = parseCert(cert)
+		c.certs[cert] = e
+	}
+	return e.cert, !ok, e.err
+}
+
+// verify checks that a parsed certificate covers the address of a listener, and reports whether
+// this is the first time the certificate is checked for the listener and the address.
+func (c *CertificateCache) verify(cert string, x *x509.Certificate, listener, addr string) (bool, error) {
+	if c == nil {
+		return true, x.VerifyHostname(addr)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.certs[cert]
+	if !ok {
+		return true, x.VerifyHostname(addr)
+	}
+	key := listener + "@" + addr
+	if err, ok := e.checked[key]; ok {
+		return false, err
+	}
+	err := x.VerifyHostname(addr)
+	if len(e.checked) >= maxCheckedAddrs {
+		return false, err
+	}
+	e.checked[key] = err
+	return true, err
+}
+
+// certHostname returns the first DNS name in the SAN of a certificate that is not a wildcard, or
+// an empty string if there is no such name.
+func certHostname(c *x509.Certificate) string {
+	for _, n := range c.DNSNames {
+		if !strings.Contains(n, "*") {
+			return n
+		}
+	}
+	return ""
+}
+
+// resolveHostname sets the public address of TLS and DTLS listeners to a hostname, so that
+// clients can validate the certificate of the TURN server: the hostname in the address
+// override, or the first DNS name in the SAN of the listener certificate if CertHostname is set
+// in the options.
+func (g *generator) resolveHostname(name string, l *stnrv1.ListenerConfig, hostname string) {
+	opts, diags := &g.opts, &g.diags
+
+	if !tlsListener(l) {
+		return
+	}
+
+	if hostname != "" {
+		l.PublicAddr = hostname
+		diags.info(name, l.Name, "using hostname from address override: %s", hostname)
+		g.traceSource(AddressSourceHostname)
+		return
+	}
+
+	if !opts.CertHostname || l.Cert == "" {
+		return
+	}
+	cert, err := g.parseCert(name, l)
+	if err != nil {
+		return
+	}
+	if hostname = certHostname(cert); hostname == "" {
+		diags.info(name, l.Name, "cannot derive hostname from listener certificate: "+
+			"no DNS name in the SAN")
+		return
+	}
+	l.PublicAddr = hostname
+	diags.info(name, l.Name, "using hostname from listener certificate: %s", hostname)
+	g.traceSource(AddressSourceCertificate)
+}
+
+// checkCertificate warns if the certificate of a TLS or DTLS listener does not cover the address
+// in the TURN URI of the listener, in which case clients validating the certificate, e.g.,
+// browsers, fail to connect.
+func (g *generator) checkCertificate(name string, l *stnrv1.ListenerConfig) {
+	diags := &g.diags
+
+	if !tlsListener(l) || l.Cert == "" {
+		return
+	}
+	cert, err := g.parseCert(name, l)
+	if err != nil {
+		return
+	}
+	addr := listenerAddr(l)
+	first, err := g.opts.Certificates.verify(l.Cert, cert, l.Name, addr)
+	if err == nil {
+		return
+	}
+	if first {
+		diags.warning(name, l.Name, "listener certificate does not cover the advertised "+
+			"address %s: %s", addr, err.Error())
+	} else {
+		diags.info(name, l.Name, "listener certificate does not cover the advertised "+
+			"address %s (reported before): %s", addr, err.Error())
+	}
+}
+
+// parseCert parses the certificate of a listener using the certificate cache in the options.
+// Parse errors are reported as an error the first time only.
+func (g *generator) parseCert(name string, l *stnrv1.ListenerConfig) (*x509.Certificate, error) {
+	cert, first, err := g.opts.Certificates.parse(l.Cert)
+	if err != nil {
+		if first {
+			g.diags.error(name, l.Name, "cannot parse listener certificate: %s", err.Error())
+		} else {
+			g.diags.info(name, l.Name, "cannot parse listener certificate (reported before): %s",
+				err.Error())
+		}
+	}
+	return cert, err
+}
@@ -0,0 +1,22 @@
+package credentials
+
+// Identity describes the caller requesting credentials.
+type Identity struct {
+	// UnverifiedUser is the user name claimed by the caller with HTTP basic authentication, if
+	// any. The password is not checked, so the name is supplied by the client and must not be
+	// trusted for authorization.
+	UnverifiedUser string `json:"unverifiedUser,omitempty"`
+	// Key is the API key supplied with the request, if any.
+	Key string `json:"key,omitempty"`
+	// RemoteAddr is the network address of the caller.
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+}
+
+// String returns a string representation of the identity, with the API key redacted.
+func (i *Identity) String() string {
+	id := *i
+	if id.Key != "" {
+		id.Key = "<redacted>"
+	}
+	return stringify(&id)
+}
@@ -0,0 +1,147 @@
+package credentials
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// NameSelector matches names against a selector: a comma-separated list of glob patterns, e.g.,
+// "prod-*", each optionally negated with a leading "!", e.g., "!internal-*". A name matches if
+// it matches any of the patterns that are not negated, or there are no such patterns, and none of
+// the negated patterns. The empty selector matches all names. Patterns use the syntax of
+// path.Match.
+type NameSelector struct {
+	include, exclude []string
+}
+
+// NewNameSelector parses a name selector.
+func NewNameSelector(selector string) (NameSelector, error) {
+	s := NameSelector{}
+	for _, p := range strings.Split(selector, ",") {
+		p = strings.TrimSpace(p)
+		negated := strings.HasPrefix(p, "!")
+		if negated {
+			p = strings.TrimSpace(p[1:])
+		}
+		if p == "" {
+			if negated {
+				return NameSelector{}, fmt.Errorf("empty negated pattern in selector %q", selector)
+			}
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return NameSelector{}, fmt.Errorf("invalid pattern %q in selector %q", p, selector)
+		}
+		if negated {
+			s.exclude = append(s.exclude, p)
+		} else {
+			s.include = append(s.include, p)
+		}
+	}
+	return s, nil
+}
+
+// Matches checks whether a name matches the selector.
+func (s NameSelector) Matches(name string) bool {
+	for _, p := range s.exclude {
+		if ok, _ := path.Match(p, name); ok {
+			return false
+		}
+	}
+	if len(s.include) == 0 {
+		return true
+	}
+	for _, p := range s.include {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ListenerSelector selects listeners by the namespace and the name of their Gateway and by the
+// listener name. The selectors are independent, so that, e.g., listeners can be selected by name
+// alone across all Gateways.
+type ListenerSelector struct {
+	Namespace, Gateway, Listener NameSelector
+}
+
+// NewListenerSelector parses the namespace, Gateway and listener selectors.
+func NewListenerSelector(namespace, gateway, listener string) (ListenerSelector, error) {
+	var s ListenerSelector
+	var err error
+	if s.Namespace, err = NewNameSelector(namespace); err != nil {
+		return ListenerSelector{}, fmt.Errorf(`%w: invalid "namespace": %s`, ErrInvalidRequest,
+			err.Error())
+	}
+	if s.Gateway, err = NewNameSelector(gateway); err != nil {
+		return ListenerSelector{}, fmt.Errorf(`%w: invalid "gateway": %s`, ErrInvalidRequest,
+			err.Error())
+	}
+	if s.Listener, err = NewNameSelector(listener); err != nil {
+		return ListenerSelector{}, fmt.Errorf(`%w: invalid "listener": %s`, ErrInvalidRequest,
+			err.Error())
+	}
+	return s, nil
+}
+
+// parsedSelector is a listener selector along with the selectors it was parsed from.
+type parsedSelector struct {
+	ListenerSelector
+	namespace, gateway, listener string
+}
+
+// parseSelector parses the namespace, Gateway and listener selectors of the request, unless
+// already parsed.
+func (r *Request) parseSelector() error {
+	if r.selectorParsed() {
+		return nil
+	}
+	s, err := NewListenerSelector(r.Namespace, r.Gateway, r.Listener)
+	if err != nil {
+		return err
+	}
+	r.selector = &parsedSelector{ListenerSelector: s, namespace: r.Namespace, gateway: r.Gateway,
+		listener: r.Listener}
+	return nil
+}
+
+// selectorParsed reports whether the parsed listener selector of the request is up to date, i.e.,
+// the selectors were not rewritten after parsing, e.g., by a webhook.
+func (r *Request) selectorParsed() bool {
+	p := r.selector
+	return p != nil && p.namespace == r.Namespace && p.gateway == r.Gateway && p.listener == r.Listener
+}
+
+// matchListener checks a listener against the namespace, Gateway and listener selectors in the
+// request and returns the reason for the mismatch, or an empty string if the listener matches.
+// The selectors are parsed on each call unless already parsed with parseSelector.
+func matchListener(req *Request, namespace, gateway, listener string) string {
+	var s ListenerSelector
+	if req.selectorParsed() {
+		s = req.selector.ListenerSelector
+	} else {
+		var err error
+		if s, err = NewListenerSelector(req.Namespace, req.Gateway, req.Listener); err != nil {
+			return err.Error()
+		}
+	}
+
+	if !s.Namespace.Matches(namespace) {
+		return fmt.Sprintf("gateway namespace mismatch: required-namespace: %s, "+
+			"gateway-namespace: %s", req.Namespace, namespace)
+	}
+
+	if !s.Gateway.Matches(gateway) {
+		return fmt.Sprintf("gateway name mismatch: required-name: %s, gateway-name: %s",
+			req.Gateway, gateway)
+	}
+
+	if !s.Listener.Matches(listener) {
+		return fmt.Sprintf("listener name mismatch: required-name: %s, listener-name: %s",
+			req.Listener, listener)
+	}
+
+	return ""
+}
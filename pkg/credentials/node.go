@@ -0,0 +1,74 @@
+package credentials
+
+import (
+	"slices"
+	"strings"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+)
+
+// NodeAddress is the address of a Kubernetes node.
+type NodeAddress struct {
+	// Node is the name of the node.
+	Node string `json:"node"`
+	// Address is the address of the node, the ExternalIP if the node has one, otherwise the
+	// InternalIP. Dual-stack nodes have a comma-separated IPv4 and IPv6 address.
+	Address string `json:"address"`
+}
+
+// NodeAddresser returns the addresses of the Kubernetes nodes, used to resolve the node address
+// placeholder STUNner sets as the address of the listeners with relay address discovery.
+type NodeAddresser interface {
+	NodeAddresses() []NodeAddress
+}
+
+// resolveNodeAddress resolves the node address placeholder of a listener with no public address
+// to the node addresses. Returns one listener per node, or a single listener for the node chosen
+// by the node selector in the options. The node is chosen once per Gateway, so that all listeners
+// of a Gateway resolve to the same node. Returns the listener as is if it needs no resolution or
+// there are no nodes.
+func (g *generator) resolveNodeAddress(name string, l stnrv1.ListenerConfig) []stnrv1.ListenerConfig {
+	req, opts, diags := &g.req, &g.opts, &g.diags
+
+	if opts.NodeAddresser == nil || l.PublicAddr != "" || l.Addr != stnrv1.DefaultNodeAddressPlaceholder {
+		return []stnrv1.ListenerConfig{l}
+	}
+
+	nodes := opts.NodeAddresser.NodeAddresses()
+	if len(nodes) == 0 {
+		diags.error(name, l.Name, "cannot resolve node address placeholder: no node addresses available")
+		return []stnrv1.ListenerConfig{l}
+	}
+
+	if opts.NodeSelector != nil {
+		candidates := make([]string, len(nodes))
+		for i, n := range nodes {
+			candidates[i] = n.Node
+		}
+
+		gateway := l.Name
+		if i := strings.LastIndex(l.Name, "/"); i >= 0 {
+			gateway = l.Name[:i]
+		}
+		i := slices.Index(candidates, g.nodes[gateway])
+		if i < 0 {
+			key := req.ClientIP
+			if key == "" {
+				key = req.Username
+			}
+			i = opts.NodeSelector.Select("", key, candidates)
+			g.nodes[gateway] = candidates[i]
+		}
+		nodes = nodes[i:][:1]
+	}
+
+	g.traceSource(AddressSourceNode)
+	ret := make([]stnrv1.ListenerConfig, len(nodes))
+	for i, n := range nodes {
+		ret[i] = l
+		ret[i].PublicAddr = n.Address
+		diags.info(name, l.Name, "resolving node address placeholder to the address of node %s: %s",
+			n.Node, n.Address)
+	}
+	return ret
+}
@@ -0,0 +1,164 @@
+package credentials
+
+import (
+	"fmt"
+	"strings"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+)
+
+// AddressOverride overrides the public address and/or the public port of listeners. Empty fields
+// are not overridden.
+type AddressOverride struct {
+	// PublicAddr is the public address.
+	PublicAddr string `json:"publicAddr,omitempty"`
+	// PublicPort is the public port.
+	PublicPort int `json:"publicPort,omitempty"`
+	// Hostname is the hostname to use as the public address of TLS and DTLS listeners, so
+	// that clients can validate the certificate of the TURN server. Takes precedence over
+	// the public address.
+	Hostname string `json:"hostname,omitempty"`
+}
+
+// AddressOverrider returns the address override for a listener.
+type AddressOverrider interface {
+	OverrideAddress(listener *stnrv1.ListenerConfig) AddressOverride
+}
+
+// AddressOverrides maps listeners to address overrides. Keys are either listener names in the
+// form "namespace/gateway/listener", Gateways in the form "namespace/gateway", or listener
+// protocols, e.g., "turn-udp". Each field of the override is taken from the most specific entry
+// that sets it: listener entries take precedence over Gateway entries, which take precedence over
+// protocol entries.
+type AddressOverrides map[string]AddressOverride
+
+// Validate checks the keys and the ports of the address overrides.
+func (o AddressOverrides) Validate() error {
+	for k, v := range o {
+		switch strings.Count(k, "/") {
+		case 0:
+			if _, err := stnrv1.NewListenerProtocol(k); err != nil {
+				return fmt.Errorf("invalid address override key %q: should be a listener "+
+					"protocol, a Gateway or a listener", k)
+			}
+		case 1, 2:
+			for _, t := range strings.Split(k, "/") {
+				if t == "" {
+					return fmt.Errorf("invalid address override key %q", k)
+				}
+			}
+		default:
+			return fmt.Errorf("invalid address override key %q", k)
+		}
+		if v.PublicPort < 0 || v.PublicPort > 65535 {
+			return fmt.Errorf("invalid public port %d in address override %q", v.PublicPort, k)
+		}
+		if v.Hostname != "" {
+			if err := ValidatePublicAddr(v.Hostname); err != nil || familyOf(v.Hostname) != "" {
+				return fmt.Errorf("invalid hostname %q in address override %q", v.Hostname, k)
+			}
+		}
+	}
+	return nil
+}
+
+// OverrideAddress returns the address override for a listener.
+func (o AddressOverrides) OverrideAddress(l *stnrv1.ListenerConfig) AddressOverride {
+	keys := []string{}
+	tokens := strings.Split(l.Name, "/")
+	if len(tokens) == 3 {
+		keys = append(keys, l.Name, tokens[0]+"/"+tokens[1])
+	}
+	if proto, err := stnrv1.NewListenerProtocol(l.Protocol); err == nil {
+		for k := range o {
+			if !strings.Contains(k, "/") && strings.EqualFold(k, proto.String()) {
+				keys = append(keys, k)
+			}
+		}
+	}
+
+	ret := AddressOverride{}
+	for _, k := range keys {
+		e, ok := o[k]
+		if !ok {
+			continue
+		}
+		if ret.PublicAddr == "" {
+			ret.PublicAddr = e.PublicAddr
+		}
+		if ret.PublicPort == 0 {
+			ret.PublicPort = e.PublicPort
+		}
+		if ret.Hostname == "" {
+			ret.Hostname = e.Hostname
+		}
+	}
+	return ret
+}
+
+// resolveAddress sets the public address and port of a listener, in order of priority, from the
+// request, the address overrides and the public address in the options. Listeners with no public
+// address are then enriched by the address enricher in the options. Finally, TLS and DTLS
+// listeners get a hostname as the public address, unless the request sets the public address.
+func (g *generator) resolveAddress(name string, l *stnrv1.ListenerConfig) {
+	req, opts, diags := &g.req, &g.opts, &g.diags
+
+	o := AddressOverride{}
+	if opts.AddressOverrider != nil {
+		o = opts.AddressOverrider.OverrideAddress(l)
+	}
+
+	switch {
+	case req.PublicAddr != "":
+		l.PublicAddr = req.PublicAddr
+		diags.info(name, l.Name, "using public address from request: %s", l.PublicAddr)
+		g.traceSource(AddressSourceRequest)
+	case o.PublicAddr != "":
+		l.PublicAddr = o.PublicAddr
+		diags.info(name, l.Name, "using public address from address override: %s", l.PublicAddr)
+		g.traceSource(AddressSourceOverride)
+	case opts.PublicAddr != "":
+		l.PublicAddr = opts.PublicAddr
+		diags.info(name, l.Name, "using public address from environment: %s", l.PublicAddr)
+		g.traceSource(AddressSourceEnvironment)
+	}
+
+	switch {
+	case req.PublicPort != 0:
+		l.PublicPort = req.PublicPort
+		diags.info(name, l.Name, "using public port from request: %d", l.PublicPort)
+	case o.PublicPort != 0:
+		l.PublicPort = o.PublicPort
+		diags.info(name, l.Name, "using public port from address override: %d", l.PublicPort)
+	}
+
+	if l.PublicAddr == "" && opts.AddressEnricher != nil {
+		if e := opts.AddressEnricher.OverrideAddress(l); e.PublicAddr != "" {
+			l.PublicAddr = e.PublicAddr
+			diags.info(name, l.Name, "using public address from address enricher: %s", l.PublicAddr)
+			g.traceSource(AddressSourceEnricher)
+			if e.PublicPort != 0 && req.PublicPort == 0 && o.PublicPort == 0 {
+				l.PublicPort = e.PublicPort
+				diags.info(name, l.Name, "using public port from address enricher: %d", l.PublicPort)
+			}
+		}
+	}
+
+	if req.PublicAddr == "" {
+		g.resolveHostname(name, l, o.Hostname)
+	}
+}
+
+// ResolveListener resolves the public address and port of a listener the same way as for
+// credential generation for a request that sets no address: the address overrides, the address
+// enricher, the certificate hostname of TLS and DTLS listeners and the node address placeholder
+// are all taken into account. Returns a listener for each resolved address, i.e., for each node
+// address and for each address family of a dual-stack address.
+func ResolveListener(l stnrv1.ListenerConfig, opts Options) []stnrv1.ListenerConfig {
+	// all node addresses are resolved
+	opts.NodeSelector = nil
+	g := &generator{opts: opts, diags: Diagnostics{}, scores: map[string]int{}, ranks: map[string]int{},
+		nodes: map[string]string{}}
+	g.resolveAddress("", &l)
+	return g.expandListener("", l)
+}
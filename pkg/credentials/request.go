@@ -0,0 +1,204 @@
+package credentials
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+// Request is a typed request for TURN credentials.
+type Request struct {
+	// Username is an optional user id to be associated with the credentials.
+	Username string `json:"username,omitempty"`
+	// TTL is the lifetime of the credentials. Default is DefaultTTL. Encoded in seconds in
+	// JSON.
+	TTL time.Duration `json:"-"`
+	// IceTransportPolicy is the ICE transport policy to return in ICE configs. Default is "all".
+	IceTransportPolicy types.IceTransportPolicy `json:"iceTransportPolicy,omitempty"`
+	// Namespace restricts credential generation to the Gateways in the namespaces matching the
+	// given selector, see NameSelector.
+	Namespace string `json:"namespace,omitempty"`
+	// Gateway restricts credential generation to the Gateways with a name matching the given
+	// selector, in any namespace unless Namespace is also set.
+	Gateway string `json:"gateway,omitempty"`
+	// Listener restricts credential generation to the listeners with a name matching the given
+	// selector, on any Gateway unless Namespace or Gateway is also set.
+	Listener string `json:"listener,omitempty"`
+	// Selector restricts credential generation to the listeners with tags matching the given
+	// Kubernetes label selector, see Tagger.
+	Selector string `json:"selector,omitempty"`
+	// PublicAddr overrides the public address of all listeners.
+	PublicAddr string `json:"publicAddr,omitempty"`
+	// PublicPort overrides the public port of all listeners.
+	PublicPort int `json:"publicPort,omitempty"`
+	// AddressFamily restricts the TURN URIs to an address family. Default is AddressFamilyDual.
+	AddressFamily AddressFamily `json:"addressFamily,omitempty"`
+	// Transports restricts the TURN URIs to the given transports. Default is all transports.
+	Transports []Transport `json:"transports,omitempty"`
+	// Profile is the name of the client profile that selects and orders the TURN URIs, see
+	// ClientProfiles (optional).
+	Profile string `json:"profile,omitempty"`
+	// ClientIP is the IP address of the end client the credentials are issued for, if known.
+	ClientIP string `json:"clientIP,omitempty"`
+	// Selection is the policy for selecting the TURN server for TURN REST API requests.
+	// Default is the policy of the selector.
+	Selection SelectionPolicy `json:"selection,omitempty"`
+	// Identity is the caller requesting the credentials, if known.
+	Identity *Identity `json:"-"`
+
+	// selector is the listener selector parsed from Namespace, Gateway and Listener.
+	selector *parsedSelector
+}
+
+// NewRequestFromIceParams converts ICE config API request parameters into a typed request.
+func NewRequestFromIceParams(params types.GetIceAuthParams) (Request, error) {
+	if params.Service == nil || *params.Service != types.GetIceAuthParamsServiceTurn {
+		return Request{}, fmt.Errorf(`%w: "service" must be "turn"`, ErrInvalidRequest)
+	}
+
+	req := Request{IceTransportPolicy: types.All}
+	if params.Username != nil {
+		req.Username = *params.Username
+	}
+	if params.Ttl != nil {
+		req.TTL = time.Duration(*params.Ttl) * time.Second
+	}
+	if params.IceTransportPolicy != nil {
+		req.IceTransportPolicy = *params.IceTransportPolicy
+	}
+	if params.Namespace != nil {
+		req.Namespace = *params.Namespace
+	}
+	if params.Gateway != nil {
+		req.Gateway = *params.Gateway
+	}
+	if params.Listener != nil {
+		req.Listener = *params.Listener
+	}
+	if err := req.parseSelector(); err != nil {
+		return Request{}, err
+	}
+	if params.Selector != nil && *params.Selector != "" {
+		if err := ValidateSelector(*params.Selector); err != nil {
+			return Request{}, err
+		}
+		req.Selector = *params.Selector
+	}
+	if params.PublicAddr != nil && *params.PublicAddr != "" {
+		addrs := SplitPublicAddr(*params.PublicAddr)
+		if len(addrs) == 0 {
+			return Request{}, fmt.Errorf(`%w: invalid "public-addr": %q`, ErrInvalidRequest,
+				*params.PublicAddr)
+		}
+		for _, addr := range addrs {
+			if err := ValidatePublicAddr(addr); err != nil {
+				return Request{}, fmt.Errorf(`%w: invalid "public-addr": %s`, ErrInvalidRequest,
+					err.Error())
+			}
+		}
+		req.PublicAddr = *params.PublicAddr
+	}
+	if params.AddressFamily != nil && *params.AddressFamily != "" {
+		f, err := NewAddressFamily(*params.AddressFamily)
+		if err != nil {
+			return Request{}, err
+		}
+		req.AddressFamily = f
+	}
+	if params.Transport != nil {
+		for _, v := range *params.Transport {
+			for _, t := range strings.Split(v, ",") {
+				if t = strings.TrimSpace(t); t == "" {
+					continue
+				}
+				transport, err := NewTransport(t)
+				if err != nil {
+					return Request{}, err
+				}
+				if !slices.Contains(req.Transports, transport) {
+					req.Transports = append(req.Transports, transport)
+				}
+			}
+		}
+	}
+	if params.Profile != nil && *params.Profile != "" {
+		if _, err := NewClientProfile(*params.Profile); err != nil {
+			return Request{}, err
+		}
+		req.Profile = strings.ToLower(*params.Profile)
+	}
+	if params.PublicPort != nil {
+		if *params.PublicPort < 1 || *params.PublicPort > 65535 {
+			return Request{}, fmt.Errorf(`%w: invalid "public-port": %d`, ErrInvalidRequest,
+				*params.PublicPort)
+		}
+		req.PublicPort = *params.PublicPort
+	}
+	if params.ClientIp != nil && *params.ClientIp != "" {
+		ip, err := netip.ParseAddr(*params.ClientIp)
+		if err != nil {
+			return Request{}, fmt.Errorf(`%w: invalid "client-ip": %s`, ErrInvalidRequest,
+				err.Error())
+		}
+		req.ClientIP = ip.Unmap().String()
+	}
+
+	return req, nil
+}
+
+// NewRequestFromTurnParams converts TURN REST API request parameters into a typed request.
+func NewRequestFromTurnParams(params types.GetTurnAuthParams) (Request, error) {
+	req, err := NewRequestFromIceParams(params.IceAuthParams())
+	if err != nil {
+		return Request{}, err
+	}
+	if err := req.SetSelection(params.Selection); err != nil {
+		return Request{}, err
+	}
+	return req, nil
+}
+
+// SetSelection parses and sets the selection policy of the request, if given.
+func (r *Request) SetSelection(policy *string) error {
+	if policy == nil || *policy == "" {
+		return nil
+	}
+	p, err := NewSelectionPolicy(*policy)
+	if err != nil {
+		return err
+	}
+	r.Selection = p
+	return nil
+}
+
+// requestJSON is the JSON encoding of a request.
+type requestJSON struct {
+	*requestAlias
+	TTL int64 `json:"ttl,omitempty"`
+}
+
+type requestAlias Request
+
+// MarshalJSON encodes a request into JSON, with the TTL in seconds.
+func (r Request) MarshalJSON() ([]byte, error) {
+	a := requestAlias(r)
+	return json.Marshal(requestJSON{requestAlias: &a, TTL: int64(r.TTL.Seconds())})
+}
+
+// UnmarshalJSON decodes a request from JSON, with the TTL in seconds.
+func (r *Request) UnmarshalJSON(b []byte) error {
+	j := requestJSON{requestAlias: (*requestAlias)(r)}
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	r.TTL = time.Duration(j.TTL) * time.Second
+	return nil
+}
+
+// String returns a string representation of the request.
+func (r *Request) String() string { return stringify(r) }
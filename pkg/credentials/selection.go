@@ -0,0 +1,145 @@
+package credentials
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"slices"
+	"sync/atomic"
+)
+
+// SelectionPolicy decides which TURN server is returned in TURN REST API responses when multiple
+// STUNner configs match a request. TURN servers are identified by the name of the STUNner config
+// they were generated from.
+type SelectionPolicy string
+
+const (
+	// SelectFirst selects the TURN server with the lexicographically smallest name.
+	SelectFirst SelectionPolicy = "first"
+	// SelectPriority selects TURN servers in a fixed priority order.
+	SelectPriority SelectionPolicy = "priority"
+	// SelectRoundRobin selects TURN servers in turn.
+	SelectRoundRobin SelectionPolicy = "round-robin"
+	// SelectWeighted selects a TURN server randomly, proportionally to the server weights.
+	SelectWeighted SelectionPolicy = "weighted"
+	// SelectHash selects a TURN server by consistent hashing on the username, so that the same
+	// user is always assigned the same TURN server as long as the server is available.
+	SelectHash SelectionPolicy = "hash"
+)
+
+// NewSelectionPolicy parses a selection policy.
+func NewSelectionPolicy(policy string) (SelectionPolicy, error) {
+	switch p := SelectionPolicy(policy); p {
+	case SelectFirst, SelectPriority, SelectRoundRobin, SelectWeighted, SelectHash:
+		return p, nil
+	}
+	return "", fmt.Errorf("%w: unknown selection policy %q", ErrInvalidRequest, policy)
+}
+
+// Selector implements the selection policies. A selector keeps state across requests (e.g., the
+// round-robin counter), so the same selector should be used for all requests.
+type Selector struct {
+	// Policy is the default policy, used unless the request specifies a policy. Default is
+	// SelectFirst.
+	Policy SelectionPolicy
+	// Priorities lists the names of the STUNner configs in decreasing order of priority, for
+	// SelectPriority. Unlisted configs follow the listed ones in name order.
+	Priorities []string
+	// Weights maps the names of the STUNner configs to weights, for SelectWeighted. Default
+	// weight is 1.
+	Weights map[string]int
+	// Rand returns a pseudo-random number in [0,1), for SelectWeighted. Default is
+	// math/rand/v2.Float64.
+	Rand func() float64
+
+	next atomic.Uint64
+}
+
+// Select returns the index of the selected candidate, or -1 if there are no candidates. If policy
+// is empty the default policy of the selector is used. The result does not depend on the order
+// of the candidates.
+func (s *Selector) Select(policy SelectionPolicy, username string, candidates []string) int {
+	if len(candidates) == 0 {
+		return -1
+	}
+
+	if policy == "" {
+		policy = s.Policy
+	}
+
+	// order candidates by name
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		switch {
+		case candidates[a] < candidates[b]:
+			return -1
+		case candidates[a] > candidates[b]:
+			return 1
+		}
+		return 0
+	})
+
+	switch policy {
+	case SelectPriority:
+		for _, p := range s.Priorities {
+			for _, i := range order {
+				if candidates[i] == p {
+					return i
+				}
+			}
+		}
+		return order[0]
+
+	case SelectRoundRobin:
+		n := s.next.Add(1) - 1
+		return order[n%uint64(len(order))]
+
+	case SelectWeighted:
+		total := 0
+		for _, i := range order {
+			total += s.weight(candidates[i])
+		}
+		if total == 0 {
+			return order[0]
+		}
+
+		rnd := rand.Float64
+		if s.Rand != nil {
+			rnd = s.Rand
+		}
+		r := int(rnd() * float64(total))
+		for _, i := range order {
+			r -= s.weight(candidates[i])
+			if r < 0 {
+				return i
+			}
+		}
+		return order[len(order)-1]
+
+	case SelectHash:
+		// rendezvous hashing: only the users of a removed server are reassigned
+		best, bestScore := order[0], uint64(0)
+		for _, i := range order {
+			h := fnv.New64a()
+			_, _ = h.Write([]byte(username))
+			_, _ = h.Write([]byte{0})
+			_, _ = h.Write([]byte(candidates[i]))
+			if score := h.Sum64(); score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		return best
+	}
+
+	return order[0]
+}
+
+func (s *Selector) weight(name string) int {
+	if w, ok := s.Weights[name]; ok {
+		return max(w, 0)
+	}
+	return 1
+}
@@ -0,0 +1,101 @@
+package credentials
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+)
+
+// Tagger returns the tags of a listener, matched against the label selector in the request.
+type Tagger interface {
+	Tags(listener *stnrv1.ListenerConfig) map[string]string
+}
+
+// TagOverlay attaches tags to listeners. Keys are namespaces, Gateways in the form
+// "namespace/gateway", or listeners in the form "namespace/gateway/listener". A listener has the
+// tags of its namespace, its Gateway and its own entry, with the tags of the more specific entries
+// overriding the same tags of the less specific ones.
+type TagOverlay map[string]map[string]string
+
+// Validate checks the keys and the tags of the tag overlay.
+func (o TagOverlay) Validate() error {
+	for k, tags := range o {
+		for _, t := range strings.Split(k, "/") {
+			if t == "" || strings.Count(k, "/") > 2 {
+				return fmt.Errorf("invalid tag overlay key %q: should be a namespace, a "+
+					"Gateway or a listener", k)
+			}
+		}
+		for name, value := range tags {
+			if err := ValidateTag(name, value); err != nil {
+				return fmt.Errorf("%w for %q", err, k)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateTag checks that a tag name is a valid Kubernetes label name and the value is a valid
+// Kubernetes label value, so that the tag can be matched against label selectors.
+func ValidateTag(name, value string) error {
+	if errs := validation.IsQualifiedName(name); len(errs) > 0 {
+		return fmt.Errorf("invalid tag name %q: %s", name, strings.Join(errs, "; "))
+	}
+	if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+		return fmt.Errorf("invalid value %q of tag %q: %s", value, name, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Tags returns the tags of a listener.
+func (o TagOverlay) Tags(l *stnrv1.ListenerConfig) map[string]string {
+	ret := map[string]string{}
+	tokens := strings.Split(l.Name, "/")
+	if len(tokens) != 3 {
+		return ret
+	}
+	for _, k := range []string{tokens[0], tokens[0] + "/" + tokens[1], l.Name} {
+		maps.Copy(ret, o[k])
+	}
+	return ret
+}
+
+// ValidateSelector checks a label selector, e.g., "tier=premium,region in (eu, us)".
+func ValidateSelector(selector string) error {
+	if _, err := labels.Parse(selector); err != nil {
+		return fmt.Errorf("%w: invalid \"selector\": %s", ErrInvalidRequest, err.Error())
+	}
+	return nil
+}
+
+// matchTags checks the tags of a listener from the taggers in the options against the label
+// selector in the request, and returns the reason for the mismatch, or an empty string if the
+// listener matches. Taggers are called in order, later taggers overriding the same tags of the
+// earlier ones.
+func (g *generator) matchTags(l *stnrv1.ListenerConfig) string {
+	req, opts := &g.req, &g.opts
+
+	if req.Selector == "" {
+		return ""
+	}
+	selector, err := labels.Parse(req.Selector)
+	if err != nil {
+		return fmt.Sprintf("invalid selector: %s", err.Error())
+	}
+
+	tags := labels.Set{}
+	for _, t := range opts.Taggers {
+		maps.Copy(tags, t.Tags(l))
+	}
+	if !selector.Matches(tags) {
+		return fmt.Sprintf("tag mismatch: required-selector: %s, listener-tags: %s",
+			req.Selector, tags.String())
+	}
+
+	return ""
+}
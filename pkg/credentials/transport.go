@@ -0,0 +1,149 @@
+package credentials
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+)
+
+// Transport is the transport of a TURN URI.
+type Transport string
+
+const (
+	// TransportUDP is TURN over UDP, e.g., "turn:1.2.3.4:3478?transport=udp".
+	TransportUDP Transport = "udp"
+	// TransportTCP is TURN over TCP, e.g., "turn:1.2.3.4:3478?transport=tcp".
+	TransportTCP Transport = "tcp"
+	// TransportTLS is TURN over TLS, e.g., "turns:1.2.3.4:443?transport=tcp".
+	TransportTLS Transport = "tls"
+	// TransportDTLS is TURN over DTLS, e.g., "turns:1.2.3.4:443?transport=udp".
+	TransportDTLS Transport = "dtls"
+)
+
+// NewTransport parses a transport.
+func NewTransport(transport string) (Transport, error) {
+	switch t := Transport(strings.ToLower(transport)); t {
+	case TransportUDP, TransportTCP, TransportTLS, TransportDTLS:
+		return t, nil
+	}
+	return "", fmt.Errorf("%w: unknown transport %q", ErrInvalidRequest, transport)
+}
+
+// transportOf returns the transport of a listener, or an empty string for unknown protocols.
+func transportOf(l *stnrv1.ListenerConfig) Transport {
+	p, err := stnrv1.NewListenerProtocol(l.Protocol)
+	if err != nil {
+		return ""
+	}
+	switch p {
+	case stnrv1.ListenerProtocolTURNUDP:
+		return TransportUDP
+	case stnrv1.ListenerProtocolTURNTCP:
+		return TransportTCP
+	case stnrv1.ListenerProtocolTURNTLS:
+		return TransportTLS
+	case stnrv1.ListenerProtocolTURNDTLS:
+		return TransportDTLS
+	}
+	return ""
+}
+
+// ClientProfile selects and orders the TURN URIs for a class of clients.
+type ClientProfile struct {
+	// Transports lists the transports the clients support, in decreasing order of preference.
+	// TURN URIs with other transports are omitted.
+	Transports []Transport
+	// PreferredPort is the public port preferred among the TURN URIs with the same transport,
+	// e.g., 443 for networks that block all other ports (optional).
+	PreferredPort int
+}
+
+// ClientProfiles are the built-in client profiles, by name.
+var ClientProfiles = map[string]ClientProfile{
+	// browsers do not support TURN over DTLS
+	"browser": {
+		Transports:    []Transport{TransportUDP, TransportTCP, TransportTLS},
+		PreferredPort: 443,
+	},
+	"pion": {
+		Transports: []Transport{TransportUDP, TransportDTLS, TransportTCP, TransportTLS},
+	},
+	// locked-down enterprise networks often let only TLS on port 443 through
+	"restrictive-network": {
+		Transports:    []Transport{TransportTLS, TransportTCP, TransportUDP, TransportDTLS},
+		PreferredPort: 443,
+	},
+	// mobile clients prefer UDP and fall back to TLS on port 443, DTLS is rarely supported
+	"mobile": {
+		Transports:    []Transport{TransportUDP, TransportTLS, TransportTCP},
+		PreferredPort: 443,
+	},
+}
+
+// NewClientProfile looks up a built-in client profile.
+func NewClientProfile(name string) (ClientProfile, error) {
+	p, ok := ClientProfiles[strings.ToLower(name)]
+	if !ok {
+		return ClientProfile{}, fmt.Errorf("%w: unknown client profile %q", ErrInvalidRequest, name)
+	}
+	return p, nil
+}
+
+// rank returns the rank of a listener in the client profile: TURN URIs are ordered by increasing
+// rank.
+func (p *ClientProfile) rank(l *stnrv1.ListenerConfig) int {
+	rank := slices.Index(p.Transports, transportOf(l)) * 2
+	port := l.PublicPort
+	if port == 0 {
+		port = l.Port
+	}
+	if p.PreferredPort != 0 && port != p.PreferredPort {
+		rank++
+	}
+	return rank
+}
+
+// matchTransport checks the transport of a listener against the transports and the client
+// profile in the request and returns the reason for the mismatch, or an empty string if the
+// listener matches.
+func matchTransport(req *Request, l *stnrv1.ListenerConfig) string {
+	t := transportOf(l)
+
+	if len(req.Transports) > 0 && !slices.Contains(req.Transports, t) {
+		return fmt.Sprintf("transport mismatch: required-transports: %s, listener-transport: %s",
+			joinTransports(req.Transports), t)
+	}
+
+	if req.Profile != "" {
+		p, err := NewClientProfile(req.Profile)
+		if err == nil && !slices.Contains(p.Transports, t) {
+			return fmt.Sprintf("transport not supported by client profile %s: "+
+				"listener-transport: %s", req.Profile, t)
+		}
+	}
+
+	return ""
+}
+
+// rankListener returns the rank of a listener in the client profile of the request, or zero if
+// the request has no client profile.
+func rankListener(req *Request, l *stnrv1.ListenerConfig) int {
+	if req.Profile == "" {
+		return 0
+	}
+	p, err := NewClientProfile(req.Profile)
+	if err != nil {
+		return 0
+	}
+	return p.rank(l)
+}
+
+func joinTransports(ts []Transport) string {
+	ss := make([]string, len(ts))
+	for i, t := range ts {
+		ss[i] = string(t)
+	}
+	return strings.Join(ss, ",")
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"github.com/l7mp/stunner-auth-service/internal/handler"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+)
+
+// The authentication service request handler, for embedding the service into Go programs.
+
+type AuthHandler = handler.Handler
+type AuthHandlerOption = handler.Option
+
+type RequestAuthorizer = handler.RequestAuthorizer
+type RequestAuthorizerFunc = handler.RequestAuthorizerFunc
+type RequestObserver = handler.RequestObserver
+type ListenerFilter = credentials.ListenerFilter
+type ListenerFilterFunc = credentials.ListenerFilterFunc
+type ListenerScorer = credentials.ListenerScorer
+type ListenerScorerFunc = credentials.ListenerScorerFunc
+type ResponseMutator = credentials.ResponseMutator
+type ResponseMutatorFunc = credentials.ResponseMutatorFunc
+type Selector = credentials.Selector
+
+var (
+	NewAuthHandler        = handler.NewHandler
+	WithRequestAuthorizer = handler.WithRequestAuthorizer
+	WithRequestObserver   = handler.WithRequestObserver
+	WithListenerFilter    = handler.WithListenerFilter
+	WithListenerScorer    = handler.WithListenerScorer
+	WithResponseMutator   = handler.WithResponseMutator
+	WithTrustedProxies    = handler.WithTrustedProxies
+	WithSelector          = handler.WithSelector
+
+	ForceNamespace          = handler.ForceNamespace
+	ExcludeProtocols        = credentials.ExcludeProtocols
+	ForceIceTransportPolicy = credentials.ForceIceTransportPolicy
+)
@@ -1,6 +1,6 @@
 // Package server provides primitives to interact with the openapi HTTP API.
 //
-// Code generated by github.com/deepmap/oapi-codegen/v2 version v2.0.0 DO NOT EDIT.
+// Code generated by github.com/oapi-codegen/oapi-codegen/v2 version v2.4.0 DO NOT EDIT.
 package server
 
 import (
@@ -32,7 +32,6 @@ type MiddlewareFunc func(http.Handler) http.Handler
 
 // GetTurnAuth operation middleware
 func (siw *ServerInterfaceWrapper) GetTurnAuth(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
 
 	var err error
 
@@ -110,6 +109,70 @@ func (siw *ServerInterfaceWrapper) GetTurnAuth(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	// ------------- Optional query parameter "selection" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "selection", r.URL.Query(), &params.Selection)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "selection", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "client-ip" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "client-ip", r.URL.Query(), &params.ClientIp)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "client-ip", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "public-port" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "public-port", r.URL.Query(), &params.PublicPort)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "public-port", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "addressFamily" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "addressFamily", r.URL.Query(), &params.AddressFamily)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "addressFamily", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "transport" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "transport", r.URL.Query(), &params.Transport)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "transport", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "profile" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "profile", r.URL.Query(), &params.Profile)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "profile", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "selector" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "selector", r.URL.Query(), &params.Selector)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "selector", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "explain" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "explain", r.URL.Query(), &params.Explain)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "explain", Err: err})
+		return
+	}
+
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		siw.Handler.GetTurnAuth(w, r, params)
 	}))
@@ -118,12 +181,11 @@ func (siw *ServerInterfaceWrapper) GetTurnAuth(w http.ResponseWriter, r *http.Re
 		handler = middleware(handler)
 	}
 
-	handler.ServeHTTP(w, r.WithContext(ctx))
+	handler.ServeHTTP(w, r)
 }
 
 // GetIceAuth operation middleware
 func (siw *ServerInterfaceWrapper) GetIceAuth(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
 
 	var err error
 
@@ -202,6 +264,62 @@ func (siw *ServerInterfaceWrapper) GetIceAuth(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	// ------------- Optional query parameter "client-ip" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "client-ip", r.URL.Query(), &params.ClientIp)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "client-ip", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "public-port" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "public-port", r.URL.Query(), &params.PublicPort)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "public-port", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "addressFamily" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "addressFamily", r.URL.Query(), &params.AddressFamily)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "addressFamily", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "transport" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "transport", r.URL.Query(), &params.Transport)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "transport", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "profile" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "profile", r.URL.Query(), &params.Profile)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "profile", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "selector" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "selector", r.URL.Query(), &params.Selector)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "selector", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "explain" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "explain", r.URL.Query(), &params.Explain)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "explain", Err: err})
+		return
+	}
+
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		siw.Handler.GetIceAuth(w, r, params)
 	}))
@@ -210,7 +328,7 @@ func (siw *ServerInterfaceWrapper) GetIceAuth(w http.ResponseWriter, r *http.Req
 		handler = middleware(handler)
 	}
 
-	handler.ServeHTTP(w, r.WithContext(ctx))
+	handler.ServeHTTP(w, r)
 }
 
 type UnescapedCookieParamError struct {
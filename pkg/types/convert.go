@@ -0,0 +1,24 @@
+package types
+
+// IceAuthParams converts TURN REST API request parameters into the equivalent ICE config API
+// request parameters.
+func (p *GetTurnAuthParams) IceAuthParams() GetIceAuthParams {
+	svc := GetIceAuthParamsService(p.Service)
+	return GetIceAuthParams{
+		Service:       &svc,
+		Username:      p.Username,
+		Ttl:           p.Ttl,
+		Key:           p.Key,
+		Namespace:     p.Namespace,
+		Gateway:       p.Gateway,
+		Listener:      p.Listener,
+		PublicAddr:    p.PublicAddr,
+		ClientIp:      p.ClientIp,
+		PublicPort:    p.PublicPort,
+		AddressFamily: p.AddressFamily,
+		Transport:     p.Transport,
+		Profile:       p.Profile,
+		Selector:      p.Selector,
+		Explain:       p.Explain,
+	}
+}
@@ -5,8 +5,20 @@ import (
 	"encoding/json"
 )
 
-func (p *GetTurnAuthParams) String() string       { return stringify(p) }
-func (p *GetIceAuthParams) String() string        { return stringify(p) }
+// String returns the request parameters in JSON, with the API key redacted.
+func (p *GetTurnAuthParams) String() string {
+	q := *p
+	q.Key = redact(q.Key)
+	return stringify(&q)
+}
+
+// String returns the request parameters in JSON, with the API key redacted.
+func (p *GetIceAuthParams) String() string {
+	q := *p
+	q.Key = redact(q.Key)
+	return stringify(&q)
+}
+
 func (p *TurnAuthenticationToken) String() string { return stringify(p) }
 func (p *IceConfig) String() string               { return stringify(p) }
 func (p *IceAuthenticationToken) String() string  { return stringify(p) }
@@ -18,3 +30,12 @@ func stringify(p any) string {
 	}
 	return string(b)
 }
+
+// redact hides a credential, e.g., the API key, so that it never reaches the logs.
+func redact(s *string) *string {
+	if s == nil || *s == "" {
+		return s
+	}
+	r := "<redacted>"
+	return &r
+}
@@ -1,6 +1,6 @@
 // Package types provides primitives to interact with the openapi HTTP API.
 //
-// Code generated by github.com/deepmap/oapi-codegen/v2 version v2.1.0 DO NOT EDIT.
+// Code generated by github.com/oapi-codegen/oapi-codegen/v2 version v2.4.0 DO NOT EDIT.
 package types
 
 // Defines values for IceTransportPolicy.
@@ -58,19 +58,47 @@ type GetTurnAuthParams struct {
 	// Key If an API key is used for authentication, the API key
 	Key *string `form:"key,omitempty" json:"key,omitempty"`
 
-	// Namespace Generate TURN URIs only for the Gateways in the given namespace (optional)
+	// Namespace Generate TURN URIs only for the Gateways in the given namespaces (optional): a
+	// comma-separated list of glob patterns, each optionally negated with a leading "!"
 	Namespace *string `form:"namespace,omitempty" json:"namespace,omitempty"`
 
-	// Gateway Generate TURN URIs only for the specified Gateway (optional); if gateway is set then
-	// namespace must be set as well
+	// Gateway Generate TURN URIs only for the specified Gateways (optional), in any namespace unless
+	// namespace is also set; same syntax as namespace
 	Gateway *string `form:"gateway,omitempty" json:"gateway,omitempty"`
 
-	// Listener Generate TURN URIs only for the specified listener of a given Gateway (optional); if
-	// listener is set then namespace and gateway must be set as well
+	// Listener Generate TURN URIs only for the specified listeners (optional), on any Gateway unless
+	// namespace or gateway is also set; same syntax as namespace
 	Listener *string `form:"listener,omitempty" json:"listener,omitempty"`
 
 	// PublicAddr Override the public IP address with the provided value (optional)
 	PublicAddr *string `form:"public-addr,omitempty" json:"public-addr,omitempty"`
+
+	// Selection Select the TURN server when multiple Gateways match the request (optional, "first",
+	// "priority", "round-robin", "weighted" or "hash"); default is set by the server
+	Selection *string `form:"selection,omitempty" json:"selection,omitempty"`
+
+	// ClientIp IP address of the end client the credentials are issued for, used for selecting the nearest
+	// Gateways (optional); default is taken from the X-Forwarded-For header set by trusted proxies or
+	// the address of the caller
+	ClientIp *string `form:"client-ip,omitempty" json:"client-ip,omitempty"`
+
+	// PublicPort Override the public port with the provided value (optional)
+	PublicPort *int `form:"public-port,omitempty" json:"public-port,omitempty"`
+
+	// AddressFamily Return only the TURN URIs with the given address family: ipv4, ipv6 or dual (default: dual)
+	AddressFamily *string `form:"addressFamily,omitempty" json:"addressFamily,omitempty"`
+
+	// Transport Restrict the TURN URIs to the given transports: udp, tcp, tls or dtls. Can be repeated or comma-separated.
+	Transport *[]string `form:"transport,omitempty" json:"transport,omitempty"`
+
+	// Profile Client profile that selects and orders the TURN URIs for a class of clients: browser, pion, restrictive-network or mobile.
+	Profile *string `form:"profile,omitempty" json:"profile,omitempty"`
+
+	// Selector Generate TURN URIs only for the listeners with tags matching the given Kubernetes label selector, e.g., "tier=premium,region in (eu,us)" (optional)
+	Selector *string `form:"selector,omitempty" json:"selector,omitempty"`
+
+	// Explain Explain the decisions made while generating the response instead of generating credentials (optional): the response is an explanation of why each listener was included or excluded, the address source and the resulting TURN URIs
+	Explain *bool `form:"explain,omitempty" json:"explain,omitempty"`
 }
 
 // GetTurnAuthParamsService defines parameters for GetTurnAuth.
@@ -93,19 +121,43 @@ type GetIceAuthParams struct {
 	// Key If an API key is used for authentication, the API key
 	Key *string `form:"key,omitempty" json:"key,omitempty"`
 
-	// Namespace Generate TURN URIs only for the Gateways in the given namespace (optional)
+	// Namespace Generate TURN URIs only for the Gateways in the given namespaces (optional): a
+	// comma-separated list of glob patterns, each optionally negated with a leading "!"
 	Namespace *string `form:"namespace,omitempty" json:"namespace,omitempty"`
 
-	// Gateway Generate TURN URIs only for the specified Gateway (optional); if gateway is set then
-	// namespace must be set as well
+	// Gateway Generate TURN URIs only for the specified Gateways (optional), in any namespace unless
+	// namespace is also set; same syntax as namespace
 	Gateway *string `form:"gateway,omitempty" json:"gateway,omitempty"`
 
-	// Listener Generate TURN URIs only for the specified listener of a given Gateway (optional); if
-	// listener is set then namespace and gateway must be set as well
+	// Listener Generate TURN URIs only for the specified listeners (optional), on any Gateway unless
+	// namespace or gateway is also set; same syntax as namespace
 	Listener *string `form:"listener,omitempty" json:"listener,omitempty"`
 
 	// PublicAddr Override the public IP address with the provided value (optional)
 	PublicAddr *string `form:"public-addr,omitempty" json:"public-addr,omitempty"`
+
+	// ClientIp IP address of the end client the credentials are issued for, used for selecting the nearest
+	// Gateways (optional); default is taken from the X-Forwarded-For header set by trusted proxies or
+	// the address of the caller
+	ClientIp *string `form:"client-ip,omitempty" json:"client-ip,omitempty"`
+
+	// PublicPort Override the public port with the provided value (optional)
+	PublicPort *int `form:"public-port,omitempty" json:"public-port,omitempty"`
+
+	// AddressFamily Return only the TURN URIs with the given address family: ipv4, ipv6 or dual (default: dual)
+	AddressFamily *string `form:"addressFamily,omitempty" json:"addressFamily,omitempty"`
+
+	// Transport Restrict the TURN URIs to the given transports: udp, tcp, tls or dtls. Can be repeated or comma-separated.
+	Transport *[]string `form:"transport,omitempty" json:"transport,omitempty"`
+
+	// Profile Client profile that selects and orders the TURN URIs for a class of clients: browser, pion, restrictive-network or mobile.
+	Profile *string `form:"profile,omitempty" json:"profile,omitempty"`
+
+	// Selector Generate TURN URIs only for the listeners with tags matching the given Kubernetes label selector, e.g., "tier=premium,region in (eu,us)" (optional)
+	Selector *string `form:"selector,omitempty" json:"selector,omitempty"`
+
+	// Explain Explain the decisions made while generating the response instead of generating credentials (optional): the response is an explanation of why each listener was included or excluded, the address source and the resulting TURN URIs
+	Explain *bool `form:"explain,omitempty" json:"explain,omitempty"`
 }
 
 // GetIceAuthParamsService defines parameters for GetIceAuth.
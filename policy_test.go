@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+	a12n "github.com/l7mp/stunner/pkg/authentication"
+	"github.com/l7mp/stunner/pkg/logger"
+
+	"github.com/l7mp/stunner-auth-service/internal/handler"
+	"github.com/l7mp/stunner-auth-service/internal/policy"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+var testPolicy = `
+identities:
+  - key: tenant-key
+    namespaces: ["testnamespace"]
+rules:
+  - name: namespace-allowed
+    expression: listener.namespace in identity.namespaces
+  - name: ttl-limit
+    expression: request.ttl <= 3600
+  - name: no-dtls
+    expression: listener.protocol != "turn-dtls"
+`
+
+var icePolicyTestCases = []iceAuthTestCase{
+	{
+		name:   "policy - allowed",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&key=tenant-key&ttl=3600",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			iceServers := *iceConfig.IceServers
+			assert.Len(t, iceServers, 1, "ICE servers len")
+			uris := *iceServers[0].Urls
+			assert.Len(t, uris, 2, "URI len")
+			assert.Contains(t, uris, "turn:1.2.3.4:3478?transport=udp", "UDP URI")
+			assert.Contains(t, uris, "turns:127.0.0.1:3479?transport=tcp", "TLS URI")
+		},
+	},
+	{
+		name:   "policy - ttl too long",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&key=tenant-key&ttl=7200",
+		status: http.StatusForbidden,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	},
+	{
+		name:   "policy - unknown identity",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&ttl=3600",
+		status: http.StatusForbidden,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	},
+	{
+		name:   "policy - listener filter still yields 404",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&key=tenant-key&ttl=3600&namespace=nonexistent",
+		status: http.StatusNotFound,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	},
+}
+
+func newTestPolicy(t *testing.T, content string) (*policy.Policy, string) {
+	file := filepath.Join(t.TempDir(), "policy.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte(content), 0o600), "write policy file")
+
+	loggerFactory := logger.NewLoggerFactory(authTestLoglevel)
+	p, err := policy.New(file, loggerFactory.NewLogger("policy"))
+	assert.NoError(t, err, "load policy")
+	return p, file
+}
+
+func TestICEPolicy(t *testing.T) {
+	p, _ := newTestPolicy(t, testPolicy)
+	testICE(t, icePolicyTestCases, handler.WithListenerFilter(p))
+}
+
+func TestPolicyDenialReasons(t *testing.T) {
+	p, file := newTestPolicy(t, testPolicy)
+
+	opts := credentials.Options{ListenerFilters: []credentials.ListenerFilter{p}}
+	req := credentials.Request{Username: "dummy", TTL: 2 * time.Hour, Identity: &credentials.Identity{Key: "tenant-key"}}
+	_, _, err := credentials.GetIceConfig([]*stnrv1.StunnerConfig{&staticAuthConfig}, req, opts)
+	assert.True(t, errors.Is(err, credentials.ErrForbidden), "forbidden")
+	assert.Contains(t, err.Error(), "ttl-limit", "rule name")
+	assert.Contains(t, err.Error(), "namespace-allowed", "rule name")
+
+	// invalid reload keeps the previous policy
+	assert.NoError(t, os.WriteFile(file, []byte("rules: [{name: bad, expression: 'request.'}]"), 0o600))
+	assert.Error(t, p.Reload(), "invalid reload")
+	_, _, err = credentials.GetIceConfig([]*stnrv1.StunnerConfig{&staticAuthConfig}, req, opts)
+	assert.Contains(t, err.Error(), "ttl-limit", "previous policy in effect")
+
+	// valid reload
+	assert.NoError(t, os.WriteFile(file, []byte("rules: [{name: short-ttl, expression: 'request.ttl < 60'}]"), 0o600))
+	assert.NoError(t, p.Reload(), "reload")
+	_, _, err = credentials.GetIceConfig([]*stnrv1.StunnerConfig{&staticAuthConfig}, req, opts)
+	assert.Contains(t, err.Error(), "short-ttl", "new policy in effect")
+	assert.NotContains(t, err.Error(), "ttl-limit", "old policy replaced")
+}
+
+func TestPolicyIdentity(t *testing.T) {
+	loggerFactory := logger.NewLoggerFactory(authTestLoglevel)
+	file := filepath.Join(t.TempDir(), "policy.yaml")
+
+	// callers cannot be identified by the unverified basic auth user name
+	assert.NoError(t, os.WriteFile(file, []byte(`
+identities:
+  - user: alice
+    namespaces: ["testnamespace"]
+`), 0o600))
+	_, err := policy.New(file, loggerFactory.NewLogger("policy"))
+	assert.Error(t, err, "user identity")
+
+	_, err = policy.NewFromSpec(policy.Spec{Identities: []policy.Identity{
+		{Namespaces: []string{"testnamespace"}}}}, loggerFactory.NewLogger("policy"))
+	assert.Error(t, err, "identity without key")
+
+	p, _ := newTestPolicy(t, testPolicy)
+	opts := credentials.Options{ListenerFilters: []credentials.ListenerFilter{p}}
+	for id, status := range map[credentials.Identity]error{
+		{Key: "tenant-key"}:                                   nil,
+		{Key: "tenant-key", UnverifiedUser: "alice"}:          nil,
+		{UnverifiedUser: "tenant-key"}:                        credentials.ErrForbidden,
+		{Key: "other-key", UnverifiedUser: "tenant-key"}:      credentials.ErrForbidden,
+		{RemoteAddr: "10.0.0.1:1234", UnverifiedUser: "root"}: credentials.ErrForbidden,
+	} {
+		req := credentials.Request{TTL: time.Hour, Identity: &id}
+		_, _, err := credentials.GetIceConfig([]*stnrv1.StunnerConfig{&staticAuthConfig}, req, opts)
+		if status == nil {
+			assert.NoError(t, err, "identity: %s", id.String())
+		} else {
+			assert.ErrorIs(t, err, status, "identity: %s", id.String())
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/l7mp/stunner/pkg/logger"
+
+	"github.com/l7mp/stunner-auth-service/internal/handler"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+	"github.com/l7mp/stunner-auth-service/pkg/server"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+func TestValidatePublicAddr(t *testing.T) {
+	for _, addr := range []string{"1.2.3.4", "2001:db8::1", "turn.example.com", "turn.example.com.",
+		"localhost", "a-b.c"} {
+		assert.NoError(t, credentials.ValidatePublicAddr(addr), "valid address %q", addr)
+	}
+	for _, addr := range []string{".", "1.2.3.4:3478", "turn..example.com", "-turn.example.com",
+		"turn_1.example.com", "turn.example.com/x", "evil.com?transport=tcp"} {
+		assert.Error(t, credentials.ValidatePublicAddr(addr), "invalid address %q", addr)
+	}
+}
+
+var publicAddrTestCases = []struct {
+	name   string
+	policy *handler.PublicAddrPolicy
+	params string
+	user   string
+	status int
+	addr   string
+	audit  string
+}{
+	{
+		name:   "public-addr - no policy",
+		params: "public-addr=5.6.7.8",
+		status: http.StatusOK,
+		addr:   "5.6.7.8",
+	},
+	{
+		name:   "public-addr - no policy, hostname",
+		params: "public-addr=turn.example.com",
+		status: http.StatusOK,
+		addr:   "turn.example.com",
+	},
+	{
+		name:   "public-addr - invalid",
+		params: "public-addr=evil.com%3Ftransport%3Dtcp",
+		status: http.StatusBadRequest,
+		audit:  "status=400",
+	},
+	{
+		name:   "public-addr - restricted",
+		policy: &handler.PublicAddrPolicy{},
+		params: "public-addr=5.6.7.8",
+		status: http.StatusForbidden,
+		audit:  "status=403",
+	},
+	{
+		name:   "public-addr - CIDR allowed",
+		policy: &handler.PublicAddrPolicy{CIDRs: []netip.Prefix{netip.MustParsePrefix("5.6.7.0/24")}},
+		params: "public-addr=5.6.7.8",
+		status: http.StatusOK,
+		addr:   "5.6.7.8",
+		audit:  "status=200",
+	},
+	{
+		name:   "public-addr - CIDR not allowed",
+		policy: &handler.PublicAddrPolicy{CIDRs: []netip.Prefix{netip.MustParsePrefix("5.6.7.0/24")}},
+		params: "public-addr=5.6.8.8",
+		status: http.StatusForbidden,
+		audit:  "status=403",
+	},
+	{
+		name:   "public-addr - domain allowed",
+		policy: &handler.PublicAddrPolicy{Domains: []string{"example.com"}},
+		params: "public-addr=turn.Example.com",
+		status: http.StatusOK,
+		addr:   "turn.Example.com",
+		audit:  "status=200",
+	},
+	{
+		name:   "public-addr - domain not allowed",
+		policy: &handler.PublicAddrPolicy{Domains: []string{"example.com"}},
+		params: "public-addr=turn.badexample.com",
+		status: http.StatusForbidden,
+		audit:  "status=403",
+	},
+	{
+		name:   "public-addr - key allowed",
+		policy: &handler.PublicAddrPolicy{Keys: []string{"secret-key"}},
+		params: "public-addr=5.6.7.8&key=secret-key",
+		status: http.StatusOK,
+		addr:   "5.6.7.8",
+		audit:  "key=true",
+	},
+	{
+		name:   "public-addr - key not allowed",
+		policy: &handler.PublicAddrPolicy{Keys: []string{"secret-key"}},
+		params: "public-addr=5.6.7.8&key=other-key",
+		status: http.StatusForbidden,
+		audit:  "status=403",
+	},
+	{
+		// the basic auth username is not verified, so it does not authorize the override
+		name:   "public-addr - basic auth user",
+		policy: &handler.PublicAddrPolicy{Keys: []string{"secret-key"}},
+		params: "public-addr=5.6.7.8",
+		user:   "admin",
+		status: http.StatusForbidden,
+		audit:  `unverified-user="admin"`,
+	},
+	{
+		name:   "public-addr - restricted, no override",
+		policy: &handler.PublicAddrPolicy{},
+		params: "namespace=testnamespace&gateway=testgateway&listener=udp",
+		status: http.StatusOK,
+		addr:   "1.2.3.4",
+	},
+}
+
+func TestPublicAddrPolicy(t *testing.T) {
+	loggerFactory := logger.NewLoggerFactory(authTestLoglevel)
+
+	for _, tc := range publicAddrTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			audit := &bytes.Buffer{}
+			var auditLog logging.LeveledLogger = logging.NewDefaultLeveledLoggerForScope("audit",
+				logging.LogLevelInfo, audit)
+			// without a policy, overrides are recorded in the handler log
+			log, opts := auditLog, []handler.Option{}
+			if tc.policy != nil {
+				p := *tc.policy
+				p.Audit = auditLog
+				log = loggerFactory.NewLogger("auth-svc")
+				opts = append(opts, handler.WithPublicAddrPolicy(p))
+			}
+			h, err := handler.NewHandler(nil, log, opts...)
+			assert.NoError(t, err, "create handler")
+			h.SetConfig(staticAuthConfig.Admin.Name, &staticAuthConfig)
+			serv := server.ServerInterfaceWrapper{Handler: h}
+
+			req := httptest.NewRequest("GET", "http://example.com/ice?service=turn&"+tc.params, nil)
+			if tc.user != "" {
+				req.SetBasicAuth(tc.user, "dummy")
+			}
+			w := httptest.NewRecorder()
+			serv.GetIceAuth(w, req)
+
+			assert.Equal(t, tc.status, w.Code, "HTTP status")
+			if tc.audit != "" {
+				assert.Contains(t, audit.String(), tc.audit, "audit log")
+			} else {
+				assert.NotContains(t, audit.String(), "public address override", "audit log")
+			}
+			assert.NotContains(t, audit.String(), "secret-key", "API key not logged")
+			if tc.status != http.StatusOK {
+				return
+			}
+
+			iceConfig := types.IceConfig{}
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &iceConfig), "decode")
+			assert.Equal(t, "turn:"+tc.addr+":3478?transport=udp",
+				(*(*iceConfig.IceServers)[0].Urls)[0], "URI")
+		})
+	}
+}
+
+func TestDualStackAddr(t *testing.T) {
+	assert.Equal(t, "1.2.3.4,2001:db8::1", credentials.DualStackAddr([]string{"", "lb.example.com",
+		"2001:db8::1", "1.2.3.4", "5.6.7.8", "2001:db8::2"}), "dual-stack")
+	assert.Equal(t, "2001:db8::1", credentials.DualStackAddr([]string{"2001:db8::1"}), "IPv6")
+	assert.Equal(t, "lb.example.com", credentials.DualStackAddr([]string{"", "lb.example.com"}), "hostname")
+	assert.Equal(t, "", credentials.DualStackAddr(nil), "empty")
+	assert.Equal(t, []string{"1.2.3.4", "2001:db8::1"}, credentials.SplitPublicAddr(" 1.2.3.4, 2001:db8::1,,1.2.3.4"),
+		"split")
+}
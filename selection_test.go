@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+	a12n "github.com/l7mp/stunner/pkg/authentication"
+
+	"github.com/l7mp/stunner-auth-service/internal/handler"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+const (
+	staticConfigName    = "testnamespace/stunnerd-static"
+	ephemeralConfigName = "testnamespace/stunnerd-ephemeral"
+)
+
+func selectionTestCase(name, params, username string) turnAuthTestCase {
+	return turnAuthTestCase{
+		name:   name,
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig, &ephemeralAuthConfig},
+		params: params,
+		status: 200,
+		tester: func(t *testing.T, turnAuthToken *types.TurnAuthenticationToken, authHandler a12n.AuthHandler) {
+			assert.NotNil(t, turnAuthToken.Username, "username nil")
+			if username == "user1" {
+				assert.Equal(t, "user1", *turnAuthToken.Username, "static config selected")
+			} else {
+				assert.NotEqual(t, "user1", *turnAuthToken.Username, "ephemeral config selected")
+			}
+		},
+	}
+}
+
+var turnSelectionTestCases = []turnAuthTestCase{
+	selectionTestCase("selection - default", "service=turn", "ephemeral"),
+	selectionTestCase("selection - first", "service=turn&selection=first", "ephemeral"),
+	selectionTestCase("selection - priority", "service=turn&selection=priority", "user1"),
+	selectionTestCase("selection - round-robin 1", "service=turn&selection=round-robin", "ephemeral"),
+	selectionTestCase("selection - round-robin 2", "service=turn&selection=round-robin", "user1"),
+	selectionTestCase("selection - round-robin 3", "service=turn&selection=round-robin", "ephemeral"),
+	selectionTestCase("selection - weighted 1", "service=turn&selection=weighted", "user1"),
+	selectionTestCase("selection - weighted 2", "service=turn&selection=weighted", "ephemeral"),
+	selectionTestCase("selection - weighted 3", "service=turn&selection=weighted", "user1"),
+	{
+		name:   "selection - invalid policy",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig, &ephemeralAuthConfig},
+		params: "service=turn&selection=dummy",
+		status: http.StatusBadRequest,
+		tester: func(t *testing.T, turnAuthToken *types.TurnAuthenticationToken, authHandler a12n.AuthHandler) {},
+	},
+}
+
+func TestTURNSelection(t *testing.T) {
+	rnd, n := []float64{0.5, 0.05, 0.99}, 0
+	testTURNAuth(t, turnSelectionTestCases, handler.WithSelector(&credentials.Selector{
+		Priorities: []string{"dummy", staticConfigName},
+		Weights:    map[string]int{ephemeralConfigName: 1, staticConfigName: 9},
+		Rand: func() float64 {
+			r := rnd[n%len(rnd)]
+			n++
+			return r
+		},
+	}))
+}
+
+func TestTURNSelectionMutator(t *testing.T) {
+	// a response mutator that rebuilds the ICE servers and the URI lists
+	rebuild := credentials.ResponseMutatorFunc(func(_ *credentials.Request, iceConfig *types.IceConfig) error {
+		servers := []types.IceAuthenticationToken{}
+		for _, s := range *iceConfig.IceServers {
+			uris := append([]string{}, *s.Urls...)
+			servers = append(servers, types.IceAuthenticationToken{
+				Username: s.Username, Credential: s.Credential, Urls: &uris})
+		}
+		iceConfig.IceServers = &servers
+		return nil
+	})
+
+	configs := []*stnrv1.StunnerConfig{&ephemeralAuthConfig, &staticAuthConfig}
+	opts := credentials.Options{
+		ResponseMutators: []credentials.ResponseMutator{rebuild},
+		Selector:         &credentials.Selector{Priorities: []string{staticConfigName}},
+	}
+	req := credentials.Request{Selection: credentials.SelectPriority}
+	token, diags, err := credentials.GetTurnAuthToken(configs, req, opts)
+	assert.NoError(t, err, "TURN auth token")
+	assert.Equal(t, "user1", *token.Username, "static config selected")
+	candidates := ""
+	for _, d := range diags {
+		if strings.Contains(d.Message, "multiple TURN servers available") {
+			candidates = d.Message
+		}
+	}
+	assert.Contains(t, candidates, ephemeralConfigName+", "+staticConfigName, "candidates named")
+}
+
+func TestTURNSelectionHash(t *testing.T) {
+	candidates := []string{"ns/gw-1", "ns/gw-2", "ns/gw-3", "ns/gw-4"}
+	reversed := []string{"ns/gw-4", "ns/gw-3", "ns/gw-2", "ns/gw-1"}
+	s := &credentials.Selector{Policy: credentials.SelectHash}
+
+	assigned := map[string]int{}
+	for _, user := range []string{"alice", "bob", "carol", "dave", "eve", "frank", "grace", "heidi"} {
+		i := s.Select("", user, candidates)
+		assert.Equal(t, i, s.Select("", user, candidates), "stable for %s", user)
+		assert.Equal(t, candidates[i], reversed[s.Select("", user, reversed)],
+			"independent of candidate order for %s", user)
+		assigned[candidates[i]]++
+
+		// removing another candidate does not move the user
+		for j := range candidates {
+			if j == i {
+				continue
+			}
+			rest := append(append([]string{}, candidates[:j]...), candidates[j+1:]...)
+			assert.Equal(t, candidates[i], rest[s.Select("", user, rest)],
+				"stable for %s after removing %s", user, candidates[j])
+		}
+	}
+	assert.Greater(t, len(assigned), 1, "users spread over candidates")
+
+	// through the API: the same user always gets the same TURN server
+	var first *string
+	tester := func(t *testing.T, turnAuthToken *types.TurnAuthenticationToken, authHandler a12n.AuthHandler) {
+		assert.NotNil(t, turnAuthToken.Uris, "URIs nil")
+		if first == nil {
+			first = &(*turnAuthToken.Uris)[0]
+			return
+		}
+		assert.Equal(t, *first, (*turnAuthToken.Uris)[0], "same TURN server selected")
+	}
+	testCases := []turnAuthTestCase{}
+	for _, name := range []string{"selection - hash 1", "selection - hash 2", "selection - hash 3"} {
+		testCases = append(testCases, turnAuthTestCase{
+			name:   name,
+			config: []*stnrv1.StunnerConfig{&staticAuthConfig, &ephemeralAuthConfig},
+			params: "service=turn&username=alice&selection=hash&ttl=3600",
+			status: 200,
+			tester: tester,
+		})
+	}
+	testTURNAuth(t, testCases)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+	a12n "github.com/l7mp/stunner/pkg/authentication"
+
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+func TestNameSelector(t *testing.T) {
+	for _, tc := range []struct {
+		selector string
+		matches  []string
+		misses   []string
+	}{
+		{"", []string{"prod", "test"}, []string{}},
+		{"prod", []string{"prod"}, []string{"prod-1", "test"}},
+		{"prod, test", []string{"prod", "test"}, []string{"dev"}},
+		{"prod-*", []string{"prod-1", "prod-eu"}, []string{"prod", "test-1"}},
+		{"!internal-*", []string{"prod", "internal"}, []string{"internal-1"}},
+		{"prod-*,!prod-test", []string{"prod-1"}, []string{"prod-test", "dev"}},
+		{"*-[0-9]", []string{"prod-1", "dev-2"}, []string{"prod-a"}},
+	} {
+		s, err := credentials.NewNameSelector(tc.selector)
+		assert.NoError(t, err, "selector %q", tc.selector)
+		for _, n := range tc.matches {
+			assert.True(t, s.Matches(n), "selector %q should match %q", tc.selector, n)
+		}
+		for _, n := range tc.misses {
+			assert.False(t, s.Matches(n), "selector %q should not match %q", tc.selector, n)
+		}
+	}
+
+	for _, selector := range []string{"[", "prod,!", "a-[z"} {
+		_, err := credentials.NewNameSelector(selector)
+		assert.Error(t, err, "invalid selector %q", selector)
+	}
+}
+
+var iceSelectorTestCases = []iceAuthTestCase{
+	{
+		name:   "selector - listener by name alone",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&listener=udp,tls",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:1.2.3.4:3478?transport=udp",
+				"turns:127.0.0.1:3479?transport=tcp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "selector - gateway across namespaces",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&gateway=testgateway",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:1.2.3.4:3478?transport=udp",
+				"turn:1.2.3.4:3478?transport=tcp",
+				"turns:127.0.0.1:3479?transport=udp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "selector - namespace glob",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&namespace=dummy*",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{"turn:1.2.3.4:3478?transport=tcp"},
+				*(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "selector - negation",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&gateway=!dummy*&listener=!*tls",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:1.2.3.4:3478?transport=udp",
+				"turn:1.2.3.4:3478?transport=tcp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "selector - glob and negation",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&namespace=*namespace,!dummy*&gateway=test*",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:1.2.3.4:3478?transport=udp",
+				"turns:127.0.0.1:3479?transport=udp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "selector - no match",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&gateway=prod-*",
+		status: 404,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	},
+	{
+		name:   "selector - invalid pattern",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&listener=%5Budp",
+		status: 400,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	},
+}
+
+func TestICESelector(t *testing.T) { testICE(t, iceSelectorTestCases) }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/l7mp/stunner-auth-service/internal/admin"
+	"github.com/l7mp/stunner-auth-service/internal/selftest"
+)
+
+// runSelfTest runs the "selftest" command: it runs a self-test on the admin API of a running
+// authentication service and prints the results. Returns the exit code.
+func runSelfTest(args []string) int {
+	fs := flag.NewFlagSet("authd selftest", flag.ContinueOnError)
+	adminURL := fs.String("admin-url", "http://127.0.0.1:8089", "Base URL of the admin HTTP API of the authentication service")
+	adminTokenFile := fs.String("admin-token-file", "", "Path of a file holding the admin API token")
+	namespace := fs.String("namespace", "", "Test only the Gateways in the given namespace")
+	gateway := fs.String("gateway", "", "Test only the given Gateway (requires --namespace)")
+	listener := fs.String("listener", "", "Test only the given listener (requires --namespace and --gateway)")
+	username := fs.String("username", "", "Username to request the credentials for")
+	params := fs.StringToString("param", map[string]string{}, "Additional getIceAuth query parameters in the form <name>=<value>")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	query := url.Values{}
+	for k, v := range *params {
+		query.Set(k, v)
+	}
+	for k, v := range map[string]string{"namespace": *namespace, "gateway": *gateway,
+		"listener": *listener, "username": *username} {
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+
+	token := ""
+	if *adminTokenFile != "" {
+		tokens, err := admin.LoadTokens(*adminTokenFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not load admin API token: %s\n", err.Error())
+			return 2
+		}
+		token = tokens[0]
+	}
+
+	report, err := selftest.Fetch(context.Background(), strings.TrimSuffix(*adminURL, "/"), token, query)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Self-test failed: %s\n", err.Error())
+		return 1
+	}
+
+	fmt.Printf("Credential request %q: HTTP status %d\n", report.Query, report.Status)
+	if report.Error != "" {
+		fmt.Printf("  error: %s\n", strings.TrimSpace(report.Error))
+	}
+	for _, r := range report.Results {
+		fmt.Printf("%s: %s\n", r.URI, r.Outcome)
+		fmt.Printf("  username: %s, reachable: %t", r.Username, r.Reachable)
+		if r.Realm != "" {
+			fmt.Printf(", realm: %s", r.Realm)
+		}
+		if r.RealmMismatch {
+			fmt.Printf(" (expected: %s)", r.ExpectedRealm)
+		}
+		if r.RelayedAddress != "" {
+			fmt.Printf(", relayed address: %s", r.RelayedAddress)
+		}
+		fmt.Println()
+		if r.Error != "" {
+			fmt.Printf("  error: %s\n", r.Error)
+		}
+	}
+
+	if !report.Success {
+		return 1
+	}
+	return 0
+}
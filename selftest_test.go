@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/l7mp/stunner"
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+	"github.com/l7mp/stunner/pkg/logger"
+
+	"github.com/l7mp/stunner-auth-service/internal/admin"
+	"github.com/l7mp/stunner-auth-service/internal/handler"
+	"github.com/l7mp/stunner-auth-service/internal/selftest"
+	"github.com/l7mp/stunner-auth-service/pkg/authtest"
+	"github.com/l7mp/stunner-auth-service/pkg/server"
+)
+
+func TestSelfTest(t *testing.T) {
+	loggerFactory := logger.NewLoggerFactory(authTestLoglevel)
+
+	// start a stunnerd with a UDP and a TCP listener on localhost
+	udpPort, tcpPort := closedPorts(t)
+	stunnerConfig := testConfig(authtest.NewConfig("testnamespace/stunnerd-selftest",
+		authtest.StaticAuth("user1", "pass1"),
+		authtest.Listener("testnamespace/testgateway/udp", "turn-udp", "127.0.0.1", udpPort, "127.0.0.1", udpPort),
+		authtest.Listener("testnamespace/testgateway/tcp", "turn-tcp", "127.0.0.1", tcpPort, "127.0.0.1", tcpPort),
+	))
+	s := stunner.NewStunner(stunner.Options{LogLevel: authTestLoglevel})
+	defer s.Close()
+	assert.NoError(t, s.Reconcile(&stunnerConfig), "start stunnerd")
+
+	h, err := handler.NewHandler(nil, loggerFactory.NewLogger("auth-svc"))
+	assert.NoError(t, err, "create handler")
+	router := server.HandlerWithOptions(h, server.GorillaServerOptions{})
+	st := selftest.New(router, h.Configs, time.Second, loggerFactory)
+	a := admin.New(loggerFactory.NewLogger("admin"), st)
+
+	run := func(t *testing.T, patch func(c *stnrv1.StunnerConfig), query string) selftest.Report {
+		c := stunnerConfig.DeepCopy()
+		if patch != nil {
+			patch(c)
+		}
+		h.Reset()
+		h.SetConfig(c.Admin.Name, c)
+
+		w := adminRequest(a, "GET", "/selftest?"+query, "")
+		assert.Equal(t, http.StatusOK, w.Code, "HTTP status")
+		report := selftest.Report{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &report), "decode")
+		return report
+	}
+
+	t.Run("success", func(t *testing.T) {
+		report := run(t, nil, "username=selftest")
+		assert.Equal(t, http.StatusOK, report.Status, "credential request status")
+		assert.True(t, report.Success, "success")
+		assert.Len(t, report.Results, 2, "results")
+		for _, r := range report.Results {
+			assert.Equal(t, selftest.OutcomeSuccess, r.Outcome, "%s outcome", r.URI)
+			assert.True(t, r.Reachable, "%s reachable", r.URI)
+			assert.Equal(t, "user1", r.Username, "%s username", r.URI)
+			assert.Equal(t, stnrv1.DefaultRealm, r.Realm, "%s realm", r.URI)
+			assert.False(t, r.RealmMismatch, "%s realm mismatch", r.URI)
+			assert.NotEmpty(t, r.RelayedAddress, "%s relayed address", r.URI)
+		}
+		assert.Equal(t, "turn:127.0.0.1:"+strconv.Itoa(udpPort)+"?transport=udp", report.Results[0].URI, "URI order")
+	})
+
+	t.Run("listener filter", func(t *testing.T) {
+		report := run(t, nil, "namespace=testnamespace&gateway=testgateway&listener=tcp")
+		assert.True(t, report.Success, "success")
+		assert.Len(t, report.Results, 1, "results")
+		assert.Equal(t, "turn:127.0.0.1:"+strconv.Itoa(tcpPort)+"?transport=tcp", report.Results[0].URI, "URI")
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		report := run(t, func(c *stnrv1.StunnerConfig) {
+			c.Auth = authtest.StaticAuth("user1", "wrong-pass")
+		}, "")
+		assert.False(t, report.Success, "success")
+		assert.Len(t, report.Results, 2, "results")
+		for _, r := range report.Results {
+			assert.Equal(t, selftest.OutcomeUnauthorized, r.Outcome, "%s outcome", r.URI)
+			assert.Equal(t, 400, r.ErrorCode, "%s error code", r.URI)
+		}
+	})
+
+	t.Run("realm mismatch", func(t *testing.T) {
+		report := run(t, func(c *stnrv1.StunnerConfig) {
+			c.Auth.Realm = "other.example.com"
+		}, "")
+		assert.Len(t, report.Results, 2, "results")
+		for _, r := range report.Results {
+			assert.True(t, r.RealmMismatch, "%s realm mismatch", r.URI)
+			assert.Equal(t, stnrv1.DefaultRealm, r.Realm, "%s realm", r.URI)
+			assert.Equal(t, "other.example.com", r.ExpectedRealm, "%s expected realm", r.URI)
+		}
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		udpDown, tcpDown := closedPorts(t)
+		report := run(t, func(c *stnrv1.StunnerConfig) {
+			c.Listeners[0].PublicPort = udpDown
+			c.Listeners[1].PublicPort = tcpDown
+		}, "")
+		assert.False(t, report.Success, "success")
+		assert.Len(t, report.Results, 2, "results")
+		for _, r := range report.Results {
+			assert.Equal(t, selftest.OutcomeUnreachable, r.Outcome, "%s outcome", r.URI)
+			assert.False(t, r.Reachable, "%s reachable", r.URI)
+			assert.NotEmpty(t, r.Error, "%s error", r.URI)
+		}
+	})
+
+	t.Run("no listener", func(t *testing.T) {
+		report := run(t, nil, "namespace=dummynamespace")
+		assert.False(t, report.Success, "success")
+		assert.Equal(t, http.StatusNotFound, report.Status, "credential request status")
+		assert.NotEmpty(t, report.Error, "error")
+		assert.Empty(t, report.Results, "results")
+	})
+}
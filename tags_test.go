@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+	a12n "github.com/l7mp/stunner/pkg/authentication"
+	"github.com/l7mp/stunner/pkg/logger"
+
+	"github.com/l7mp/stunner-auth-service/internal/enricher"
+	"github.com/l7mp/stunner-auth-service/internal/handler"
+	"github.com/l7mp/stunner-auth-service/internal/tags"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+const testTags = `
+testnamespace:
+  region: eu
+testnamespace/testgateway:
+  tier: premium
+testnamespace/testgateway/dtls:
+  tier: standard
+dummynamespace:
+  region: us
+`
+
+var iceTagTestCases = []iceAuthTestCase{
+	{
+		name:   "tags - equality",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&selector=tier%3Dpremium,region%3Deu",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{"turn:1.2.3.4:3478?transport=udp"},
+				*(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "tags - set-based",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&selector=tier+in+(premium,standard)",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:1.2.3.4:3478?transport=udp",
+				"turns:127.0.0.1:3479?transport=udp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "tags - inequality and missing tags",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&selector=region!%3Dus,!tier",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{"turns:127.0.0.1:3479?transport=tcp"},
+				*(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "tags - with name selectors",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&selector=region&listener=tcp,udp",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:1.2.3.4:3478?transport=udp",
+				"turn:1.2.3.4:3478?transport=tcp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "tags - no match",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&selector=tier%3Dgold",
+		status: 404,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	},
+	{
+		name:   "tags - invalid selector",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&selector=tier%3D%3D%3D",
+		status: 400,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	},
+}
+
+func TestTagFile(t *testing.T) {
+	loggerFactory := logger.NewLoggerFactory(authTestLoglevel)
+	file := filepath.Join(t.TempDir(), "tags.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte(testTags), 0o600), "write tags")
+
+	f, err := tags.NewFile(file, loggerFactory.NewLogger("tags"))
+	assert.NoError(t, err, "load tags")
+
+	testICE(t, iceTagTestCases, handler.WithTagger(f))
+
+	// reload
+	udp := &staticAuthConfig.Listeners[0]
+	assert.NoError(t, os.WriteFile(file, []byte("testnamespace/testgateway/udp: {tier: gold}\n"), 0o600), "write tags")
+	assert.NoError(t, f.Reload(), "reload")
+	assert.Equal(t, map[string]string{"tier": "gold"}, f.Tags(udp), "reloaded tags")
+
+	// invalid tags are not loaded
+	for _, content := range []string{
+		"a/b/c/d: {tier: gold}\n",
+		"a//c: {tier: gold}\n",
+		"testnamespace: {'bad tag': gold}\n",
+		"testnamespace: {tier: 'bad value'}\n",
+	} {
+		assert.NoError(t, os.WriteFile(file, []byte(content), 0o600), "write tags")
+		assert.Error(t, f.Reload(), "invalid tags %q", content)
+	}
+	assert.Equal(t, map[string]string{"tier": "gold"}, f.Tags(udp), "previous tags kept")
+}
+
+func TestTagAnnotations(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	loggerFactory := logger.NewLoggerFactory(authTestLoglevel)
+
+	dynClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{enricher.GatewayResource: "GatewayList"})
+	for _, gw := range []struct {
+		namespace, name string
+		annotations     map[string]string
+	}{
+		{"testnamespace", "testgateway", map[string]string{
+			tags.AnnotationPrefix + "tier":   "premium",
+			tags.AnnotationPrefix + "region": "eu",
+			tags.AnnotationPrefix + "zone":   "invalid value!",
+			"other.example.com/tier":         "ignored",
+		}},
+		{"dummynamespace", "testgateway", map[string]string{tags.AnnotationPrefix + "tier": "standard"}},
+	} {
+		obj := testGateway(gw.namespace, gw.name, "1.1.1.1", nil)
+		obj.SetAnnotations(gw.annotations)
+		_, err := dynClient.Resource(enricher.GatewayResource).Namespace(gw.namespace).Create(ctx, obj,
+			metav1.CreateOptions{})
+		assert.NoError(t, err, "create Gateway")
+	}
+
+	a, err := tags.NewAnnotations(enricher.NewGatewayInformerFactory(dynClient), loggerFactory.NewLogger("tags"))
+	assert.NoError(t, err, "create tagger")
+	assert.NoError(t, a.Start(ctx), "start")
+
+	runICE(t, []iceAuthTestCase{
+		{
+			name:   "tags - from annotations",
+			config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+			params: "service=turn&selector=tier%3Dpremium",
+			status: 200,
+			tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+				assert.Equal(t, []string{
+					"turn:1.2.3.4:3478?transport=udp",
+					"turns:127.0.0.1:3479?transport=udp",
+				}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+			},
+		},
+		{
+			name:   "tags - other annotations ignored",
+			config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+			params: "service=turn&selector=tier",
+			status: 200,
+			tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+				assert.Equal(t, []string{
+					"turn:1.2.3.4:3478?transport=udp",
+					"turn:1.2.3.4:3478?transport=tcp",
+					"turns:127.0.0.1:3479?transport=udp",
+				}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+			},
+		},
+		{
+			name:   "tags - invalid annotations ignored",
+			config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+			params: "service=turn&selector=zone",
+			status: http.StatusNotFound,
+			tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+		},
+	}, handler.WithTagger(a))
+
+	// the tag file overrides the annotations
+	file := filepath.Join(t.TempDir(), "tags.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte("testnamespace/testgateway/dtls: {tier: standard}\n"), 0o600), "write tags")
+	f, err := tags.NewFile(file, loggerFactory.NewLogger("tags"))
+	assert.NoError(t, err, "load tags")
+
+	runICE(t, []iceAuthTestCase{
+		{
+			name:   "tags - file overrides annotations",
+			config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+			params: "service=turn&selector=tier%3Dstandard",
+			status: 200,
+			tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+				assert.Equal(t, []string{
+					"turn:1.2.3.4:3478?transport=tcp",
+					"turns:127.0.0.1:3479?transport=udp",
+				}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+			},
+		},
+	}, handler.WithTagger(a), handler.WithTagger(f))
+}
@@ -1,15 +1,15 @@
 package main
 
 import (
-	"encoding/base64"
 	"os"
 
 	"github.com/go-logr/logr"
 	"github.com/go-logr/zapr"
-	"github.com/l7mp/stunner"
 	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+
+	"github.com/l7mp/stunner-auth-service/pkg/authtest"
 )
 
 //nolint:unused
@@ -25,13 +25,6 @@ const (
 	testCDSAddr = ":63487"
 )
 
-//nolint:unused
-var (
-	certPem, keyPem, _ = stunner.GenerateSelfSignedKey()
-	certPem64          = base64.StdEncoding.EncodeToString(certPem)
-	keyPem64           = base64.StdEncoding.EncodeToString(keyPem)
-)
-
 //nolint:unused
 func setupLogger() logr.Logger {
 	zapConfig := zap.NewProductionEncoderConfig()
@@ -44,112 +37,13 @@ func setupLogger() logr.Logger {
 }
 
 //nolint:unused
-var staticAuthConfig = stnrv1.StunnerConfig{
-	ApiVersion: "v1",
-	Admin: stnrv1.AdminConfig{
-		Name:     "testnamespace/stunnerd-static",
-		LogLevel: authTestLoglevel,
-	},
-	Auth: stnrv1.AuthConfig{
-		Type:  "static",
-		Realm: "",
-		Credentials: map[string]string{
-			"username": "user1",
-			"password": "pass1",
-		}},
-	Listeners: []stnrv1.ListenerConfig{
-		{
-			Name:       "testnamespace/testgateway/udp",
-			Protocol:   "turn-udp",
-			PublicAddr: "1.2.3.4",
-			PublicPort: 3478,
-			Addr:       "127.0.0.1",
-			Port:       23478,
-			Routes:     []string{},
-		}, {
-			Name:       "dummynamespace/testgateway/tcp",
-			Protocol:   "turn-tcp",
-			PublicAddr: "1.2.3.4",
-			PublicPort: 3478,
-			Addr:       "127.0.0.1",
-			Port:       3478,
-			Routes:     []string{},
-		}, {
-			Name:       "testnamespace/dummygateway/tls",
-			Protocol:   "turn-tls",
-			PublicAddr: "",
-			PublicPort: 0,
-			Addr:       "127.0.0.1",
-			Port:       3479,
-			Cert:       certPem64,
-			Key:        keyPem64,
-			Routes:     []string{},
-		}, {
-			Name:       "testnamespace/testgateway/dtls",
-			Protocol:   "turn-dtls",
-			PublicAddr: "",
-			PublicPort: 0,
-			Addr:       "127.0.0.1",
-			Port:       3479,
-			Cert:       certPem64,
-			Key:        keyPem64,
-			Routes:     []string{},
-		},
-	},
-	Clusters: []stnrv1.ClusterConfig{},
-}
+var (
+	staticAuthConfig    = testConfig(authtest.StaticAuthConfig())
+	ephemeralAuthConfig = testConfig(authtest.EphemeralAuthConfig())
+)
 
 //nolint:unused
-var ephemeralAuthConfig = stnrv1.StunnerConfig{
-	ApiVersion: "v1",
-	Admin: stnrv1.AdminConfig{
-		Name:     "testnamespace/stunnerd-ephemeral",
-		LogLevel: authTestLoglevel,
-	},
-	Auth: stnrv1.AuthConfig{
-		Type:  "ephemeral",
-		Realm: "",
-		Credentials: map[string]string{
-			"secret": "my-secret",
-		}},
-	Listeners: []stnrv1.ListenerConfig{
-		{
-			Name:       "testnamespace/testgateway/udp-2",
-			Protocol:   "turn-udp",
-			PublicAddr: "1.2.3.5",
-			PublicPort: 3478,
-			Addr:       "127.0.0.2",
-			Port:       23478,
-			Routes:     []string{},
-		}, {
-			Name:       "dummynamespace/testgateway/tcp-2",
-			Protocol:   "turn-tcp",
-			PublicAddr: "1.2.3.5",
-			PublicPort: 3478,
-			Addr:       "127.0.0.2",
-			Port:       3478,
-			Routes:     []string{},
-		}, {
-			Name:       "testnamespace/dummygateway/tls-2",
-			Protocol:   "turn-tls",
-			PublicAddr: "",
-			PublicPort: 0,
-			Addr:       "127.0.0.2",
-			Port:       3479,
-			Cert:       certPem64,
-			Key:        keyPem64,
-			Routes:     []string{},
-		}, {
-			Name:       "testnamespace/testgateway/dtls-2",
-			Protocol:   "turn-dtls",
-			PublicAddr: "",
-			PublicPort: 0,
-			Addr:       "127.0.0.2",
-			Port:       3479,
-			Cert:       certPem64,
-			Key:        keyPem64,
-			Routes:     []string{},
-		},
-	},
-	Clusters: []stnrv1.ClusterConfig{},
+func testConfig(c *stnrv1.StunnerConfig) stnrv1.StunnerConfig {
+	c.Admin.LogLevel = authTestLoglevel
+	return *c
 }
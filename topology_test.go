@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+	a12n "github.com/l7mp/stunner/pkg/authentication"
+	"github.com/l7mp/stunner/pkg/logger"
+
+	"github.com/l7mp/stunner-auth-service/internal/handler"
+	"github.com/l7mp/stunner-auth-service/internal/topology"
+	"github.com/l7mp/stunner-auth-service/pkg/authtest"
+	"github.com/l7mp/stunner-auth-service/pkg/server"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+var apAuthConfig = testConfig(authtest.NewConfig("testnamespace/stunnerd-static-ap",
+	authtest.StaticAuth("user3", "pass3"),
+	authtest.Listener("othernamespace/othergateway/udp", "turn-udp", "9.9.9.9", 3478, "127.0.0.5", 23478),
+))
+
+var testTopologySpec = topology.Spec{
+	Regions: []topology.Region{
+		{Name: "eu", CIDRs: []string{"10.1.0.0/16"}, Countries: []string{"DE"}, Gateways: []string{"testnamespace"}},
+		{Name: "us", CIDRs: []string{"10.2.0.0/16"}, Gateways: []string{"dummynamespace/testgateway"}},
+		{Name: "ap", CIDRs: []string{"10.3.0.0/16", "10.1.3.0/24"}, Gateways: []string{"othernamespace"}},
+	},
+}
+
+func newTestTopology(t *testing.T, spec topology.Spec) *topology.Topology {
+	loggerFactory := logger.NewLoggerFactory(authTestLoglevel)
+	topo, err := topology.NewFromSpec(spec, loggerFactory.NewLogger("topology"))
+	assert.NoError(t, err, "create topology")
+	return topo
+}
+
+var iceTopologyTestCases = []iceAuthTestCase{
+	{
+		name:   "topology - region listeners first",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&client-ip=10.2.0.1",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			iceServers := *iceConfig.IceServers
+			assert.Len(t, iceServers, 1, "ICE servers len")
+			uris := *iceServers[0].Urls
+			assert.Len(t, uris, 4, "URI len")
+			assert.Equal(t, "turn:1.2.3.4:3478?transport=tcp", uris[0], "region URI first")
+		},
+	},
+	{
+		name:   "topology - region servers first",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig, &ephemeralAuthConfig, &apAuthConfig},
+		params: "service=turn&client-ip=10.3.0.1",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			iceServers := *iceConfig.IceServers
+			assert.Len(t, iceServers, 3, "ICE servers len")
+			assert.Equal(t, "user3", *iceServers[0].Username, "region server first")
+		},
+	},
+	{
+		name:   "topology - unknown region",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig, &ephemeralAuthConfig, &apAuthConfig},
+		params: "service=turn&client-ip=192.168.0.1",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			iceServers := *iceConfig.IceServers
+			assert.Len(t, iceServers, 3, "ICE servers len")
+			assert.NotEqual(t, "user3", *iceServers[0].Username, "config order retained")
+		},
+	},
+	{
+		name:   "topology - invalid client IP",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&client-ip=dummy",
+		status: http.StatusBadRequest,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	},
+}
+
+var iceTopologyFilterTestCases = []iceAuthTestCase{
+	{
+		name:   "topology - filter region listeners",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig, &apAuthConfig},
+		params: "service=turn&client-ip=10.1.0.1",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			iceServers := *iceConfig.IceServers
+			assert.Len(t, iceServers, 1, "ICE servers len")
+			uris := *iceServers[0].Urls
+			assert.Len(t, uris, 3, "URI len")
+			assert.NotContains(t, uris, "turn:1.2.3.4:3478?transport=tcp", "TCP URI filtered")
+		},
+	},
+	{
+		name:   "topology - longest prefix match",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig, &apAuthConfig},
+		params: "service=turn&client-ip=10.1.3.1",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			iceServers := *iceConfig.IceServers
+			assert.Len(t, iceServers, 1, "ICE servers len")
+			assert.Equal(t, []string{"turn:9.9.9.9:3478?transport=udp"}, *iceServers[0].Urls, "URIs")
+		},
+	},
+}
+
+var turnTopologyTestCases = []turnAuthTestCase{
+	{
+		name:   "topology - region server selected",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig, &ephemeralAuthConfig, &apAuthConfig},
+		params: "service=turn&client-ip=10.3.0.1",
+		status: 200,
+		tester: func(t *testing.T, turnAuthToken *types.TurnAuthenticationToken, authHandler a12n.AuthHandler) {
+			assert.Equal(t, "user3", *turnAuthToken.Username, "username")
+			assert.Equal(t, []string{"turn:9.9.9.9:3478?transport=udp"}, *turnAuthToken.Uris, "URIs")
+		},
+	},
+}
+
+func TestICETopology(t *testing.T) {
+	topo := newTestTopology(t, testTopologySpec)
+	testICE(t, iceTopologyTestCases, handler.WithListenerFilter(topo), handler.WithListenerScorer(topo))
+
+	spec := testTopologySpec
+	spec.Mode = topology.ModeFilter
+	topo = newTestTopology(t, spec)
+	testICE(t, iceTopologyFilterTestCases, handler.WithListenerFilter(topo), handler.WithListenerScorer(topo))
+}
+
+func TestTURNTopology(t *testing.T) {
+	topo := newTestTopology(t, testTopologySpec)
+	testTURNAuth(t, turnTopologyTestCases, handler.WithListenerFilter(topo), handler.WithListenerScorer(topo))
+}
+
+func TestTopologyForwardedFor(t *testing.T) {
+	topo := newTestTopology(t, testTopologySpec)
+	loggerFactory := logger.NewLoggerFactory(authTestLoglevel)
+	h, err := handler.NewHandler(nil, loggerFactory.NewLogger("auth-svc"),
+		handler.WithListenerScorer(topo),
+		handler.WithTrustedProxies(netip.MustParsePrefix("192.0.2.0/24"), netip.MustParsePrefix("10.9.0.0/16")))
+	assert.NoError(t, err, "create handler")
+	for _, c := range []*stnrv1.StunnerConfig{&staticAuthConfig, &ephemeralAuthConfig, &apAuthConfig} {
+		h.SetConfig(c.Admin.Name, c)
+	}
+	router := server.Handler(h)
+
+	for _, tc := range []struct {
+		remoteAddr, forwardedFor, username string
+	}{
+		// trusted proxy chain: the last untrusted hop is the client
+		{"192.0.2.1:1234", "10.3.0.1, 10.9.0.1", "user3"},
+		// spoofed header from an untrusted caller is ignored
+		{"198.51.100.1:1234", "10.3.0.1", ""},
+		// the client itself is the caller
+		{"10.3.0.7:1234", "", "user3"},
+	} {
+		req := httptest.NewRequest("GET", "http://example.com/?service=turn", nil)
+		req.RemoteAddr = tc.remoteAddr
+		if tc.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code, "HTTP status")
+		token := types.TurnAuthenticationToken{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &token), "decode")
+		if tc.username != "" {
+			assert.Equal(t, tc.username, *token.Username, "username for %s / %s", tc.remoteAddr, tc.forwardedFor)
+		} else {
+			assert.NotEqual(t, "user3", *token.Username, "username for %s / %s", tc.remoteAddr, tc.forwardedFor)
+		}
+	}
+}
+
+func TestTopologyGeoIP(t *testing.T) {
+	writer, err := mmdbwriter.New(mmdbwriter.Options{
+		DatabaseType:            "GeoIP2-Country",
+		IncludeReservedNetworks: true,
+	})
+	assert.NoError(t, err, "create GeoIP database")
+	for cidr, country := range map[string][2]string{
+		"203.0.113.0/24":  {"DE", "EU"},
+		"198.51.100.0/24": {"FR", "EU"},
+		"192.0.2.0/24":    {"JP", "AS"},
+	} {
+		_, network, err := net.ParseCIDR(cidr)
+		assert.NoError(t, err, "parse CIDR")
+		assert.NoError(t, writer.Insert(network, mmdbtype.Map{
+			"country":   mmdbtype.Map{"iso_code": mmdbtype.String(country[0])},
+			"continent": mmdbtype.Map{"code": mmdbtype.String(country[1])},
+		}), "insert network")
+	}
+
+	file := filepath.Join(t.TempDir(), "country.mmdb")
+	f, err := os.Create(file)
+	assert.NoError(t, err, "create file")
+	_, err = writer.WriteTo(f)
+	assert.NoError(t, err, "write GeoIP database")
+	assert.NoError(t, f.Close(), "close file")
+
+	spec := testTopologySpec
+	spec.GeoIPDatabase = file
+	spec.Regions = append([]topology.Region{}, spec.Regions...)
+	spec.Regions[2].Continents = []string{"as"}
+	topo := newTestTopology(t, spec)
+	defer topo.Close() //nolint:errcheck
+
+	assert.Equal(t, "eu", topo.Region("203.0.113.10"), "country")
+	assert.Equal(t, "", topo.Region("198.51.100.10"), "unmapped country")
+	assert.Equal(t, "ap", topo.Region("192.0.2.10"), "continent")
+	assert.Equal(t, "us", topo.Region("10.2.1.1"), "CIDR")
+	assert.Equal(t, "", topo.Region("127.0.0.1"), "unknown")
+}
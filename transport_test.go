@@ -0,0 +1,148 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+	a12n "github.com/l7mp/stunner/pkg/authentication"
+
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+// tls443Patch moves the TLS listener to public port 443
+func tls443Patch(c *stnrv1.StunnerConfig) { c.Listeners[2].PublicPort = 443 }
+
+var iceTransportTestCases = []iceAuthTestCase{
+	{
+		name:   "transport - single",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&transport=udp",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{"turn:1.2.3.4:3478?transport=udp"},
+				*(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "transport - repeated",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&transport=udp&transport=tls",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:1.2.3.4:3478?transport=udp",
+				"turns:127.0.0.1:3479?transport=tcp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "transport - comma-separated",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&transport=TCP,dtls",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:1.2.3.4:3478?transport=tcp",
+				"turns:127.0.0.1:3479?transport=udp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "transport - no match",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&namespace=dummynamespace&transport=udp",
+		status: 404,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	},
+	{
+		name:   "transport - invalid",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&transport=sctp",
+		status: 400,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	},
+	{
+		name:   "profile - browser",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&profile=browser",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:1.2.3.4:3478?transport=udp",
+				"turn:1.2.3.4:3478?transport=tcp",
+				"turns:127.0.0.1:3479?transport=tcp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "profile - pion",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&profile=pion",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:1.2.3.4:3478?transport=udp",
+				"turns:127.0.0.1:3479?transport=udp",
+				"turn:1.2.3.4:3478?transport=tcp",
+				"turns:127.0.0.1:3479?transport=tcp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "profile - restrictive network",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		patch:  tls443Patch,
+		params: "service=turn&profile=restrictive-network",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turns:127.0.0.1:443?transport=tcp",
+				"turn:1.2.3.4:3478?transport=tcp",
+				"turn:1.2.3.4:3478?transport=udp",
+				"turns:127.0.0.1:3479?transport=udp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "profile - mobile",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		patch:  tls443Patch,
+		params: "service=turn&profile=mobile",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:1.2.3.4:3478?transport=udp",
+				"turns:127.0.0.1:443?transport=tcp",
+				"turn:1.2.3.4:3478?transport=tcp",
+			}, *(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "profile - with transport filter",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&profile=browser&transport=tls,dtls",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{"turns:127.0.0.1:3479?transport=tcp"},
+				*(*iceConfig.IceServers)[0].Urls, "URIs")
+		},
+	},
+	{
+		name:   "profile - transport not supported",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&profile=browser&transport=dtls",
+		status: 404,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	},
+	{
+		name:   "profile - invalid",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&profile=dummy",
+		status: 400,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	},
+}
+
+func TestICETransport(t *testing.T) { testICE(t, iceTransportTestCases) }
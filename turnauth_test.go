@@ -438,13 +438,83 @@ var turnAuthTestCases = []turnAuthTestCase{
 			assert.Contains(t, uris, "turns:1.3.5.7:3479?transport=udp", "DTLS URI")
 		},
 	},
+	// IPv6 and dual-stack
+	{
+		name:   "IPv6 public address from request",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&namespace=testnamespace&gateway=testgateway&listener=udp&public-addr=2001:db8::2",
+		status: 200,
+		tester: func(t *testing.T, turnAuthToken *types.TurnAuthenticationToken, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{"turn:[2001:db8::2]:3478?transport=udp"}, *turnAuthToken.Uris, "URIs")
+		},
+	},
+	{
+		name:          "dual-stack public address from environment",
+		config:        []*stnrv1.StunnerConfig{&staticAuthConfig},
+		envPublicAddr: "5.6.7.8,2001:db8::3",
+		params:        "service=turn&namespace=testnamespace&gateway=testgateway&listener=udp",
+		status:        200,
+		tester: func(t *testing.T, turnAuthToken *types.TurnAuthenticationToken, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:5.6.7.8:3478?transport=udp",
+				"turn:[2001:db8::3]:3478?transport=udp",
+			}, *turnAuthToken.Uris, "URIs")
+		},
+	},
+	{
+		name:          "dual-stack public address from environment - IPv4 only",
+		config:        []*stnrv1.StunnerConfig{&staticAuthConfig},
+		envPublicAddr: "5.6.7.8,2001:db8::3",
+		params:        "service=turn&namespace=testnamespace&gateway=testgateway&listener=udp&addressFamily=ipv4",
+		status:        200,
+		tester: func(t *testing.T, turnAuthToken *types.TurnAuthenticationToken, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{"turn:5.6.7.8:3478?transport=udp"}, *turnAuthToken.Uris, "URIs")
+		},
+	},
+	{
+		name:   "transport filter",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&transport=tcp&transport=tls",
+		status: 200,
+		tester: func(t *testing.T, turnAuthToken *types.TurnAuthenticationToken, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:1.2.3.4:3478?transport=tcp",
+				"turns:127.0.0.1:3479?transport=tcp",
+			}, *turnAuthToken.Uris, "URIs")
+		},
+	},
+	{
+		name:   "listener selector across gateways",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&listener=*tls",
+		status: 200,
+		tester: func(t *testing.T, turnAuthToken *types.TurnAuthenticationToken, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turns:127.0.0.1:3479?transport=tcp",
+				"turns:127.0.0.1:3479?transport=udp",
+			}, *turnAuthToken.Uris, "URIs")
+		},
+	},
+	{
+		name:   "browser client profile",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&profile=browser",
+		status: 200,
+		tester: func(t *testing.T, turnAuthToken *types.TurnAuthenticationToken, authHandler a12n.AuthHandler) {
+			assert.Equal(t, []string{
+				"turn:1.2.3.4:3478?transport=udp",
+				"turn:1.2.3.4:3478?transport=tcp",
+				"turns:127.0.0.1:3479?transport=tcp",
+			}, *turnAuthToken.Uris, "URIs")
+		},
+	},
 }
 
 func TestTURNAuth(t *testing.T)    { testTURNAuth(t, turnAuthTestCases) }
 func TestTURNAuthCDS(t *testing.T) { testTurnAuthCDS(t, turnAuthTestCases) }
 
 // test with manually injected configs
-func testTURNAuth(t *testing.T, tests []turnAuthTestCase) {
+func testTURNAuth(t *testing.T, tests []turnAuthTestCase, opts ...handler.Option) {
 	// <setup>
 	lim := test.TimeOut(time.Second * 120)
 	defer lim.Stop()
@@ -456,7 +526,7 @@ func testTURNAuth(t *testing.T, tests []turnAuthTestCase) {
 	log := loggerFactory.NewLogger("auth-test")
 
 	// we don't Start() the handler so a nil channel should not be a problem
-	handler, err := handler.NewHandler(nil, loggerFactory.NewLogger("auth-svc"))
+	handler, err := handler.NewHandler(nil, loggerFactory.NewLogger("auth-svc"), opts...)
 	assert.NoError(t, err, "create handler")
 
 	serv := server.ServerInterfaceWrapper{Handler: handler}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+	"github.com/l7mp/stunner/pkg/logger"
+
+	"github.com/l7mp/stunner-auth-service/internal/handler"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+	"github.com/l7mp/stunner-auth-service/pkg/server"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+func TestClassifyAddress(t *testing.T) {
+	for addr, class := range map[string]credentials.AddressClass{
+		"":                           credentials.AddressUnspecified,
+		"__node_address_placeholder": credentials.AddressUnspecified,
+		"0.0.0.0":                    credentials.AddressUnspecified,
+		"::":                         credentials.AddressUnspecified,
+		"127.0.0.1":                  credentials.AddressLoopback,
+		"::1":                        credentials.AddressLoopback,
+		"169.254.10.1":               credentials.AddressLinkLocal,
+		"fe80::1":                    credentials.AddressLinkLocal,
+		"10.1.2.3":                   credentials.AddressPrivate,
+		"172.16.0.1":                 credentials.AddressPrivate,
+		"192.168.1.1":                credentials.AddressPrivate,
+		"::ffff:192.168.1.1":         credentials.AddressPrivate,
+		"1.2.3.4":                    "",
+		"172.32.0.1":                 "",
+		"turn.example.com":           "",
+	} {
+		assert.Equal(t, class, credentials.ClassifyAddress(addr), "class of %q", addr)
+	}
+
+	_, err := credentials.NewUnroutablePolicy(map[string]string{"multicast": "drop"})
+	assert.Error(t, err, "unknown class")
+	_, err = credentials.NewUnroutablePolicy(map[string]string{"private": "dummy"})
+	assert.Error(t, err, "unknown action")
+	p, err := credentials.NewUnroutablePolicy(map[string]string{"Private": "Flag"})
+	assert.NoError(t, err, "policy")
+	assert.Equal(t, credentials.UnroutablePolicy{credentials.AddressPrivate: credentials.UnroutableFlag}, p,
+		"policy")
+
+	assert.NoError(t, credentials.ValidateFallbackAddr("1.2.3.4"), "IP fallback address")
+	assert.NoError(t, credentials.ValidateFallbackAddr("turn.example.com"), "hostname fallback address")
+	for _, addr := range []string{"", "1.2.3.4,5.6.7.8", "1.2.3.4:3478", "-dummy", "0.0.0.0", "127.0.0.1",
+		"169.254.10.1", "10.1.2.3", "__node_address_placeholder"} {
+		assert.Error(t, credentials.ValidateFallbackAddr(addr), "invalid fallback address %q", addr)
+	}
+}
+
+var unroutableTestCases = []struct {
+	name     string
+	policy   map[string]string
+	fallback string
+	patch    func(c *stnrv1.StunnerConfig)
+	params   string
+	status   int
+	uris     []string
+	header   []string
+}{
+	{
+		name:   "unroutable - no policy",
+		patch:  func(c *stnrv1.StunnerConfig) { c.Listeners[0].PublicAddr, c.Listeners[0].Addr = "", "" },
+		params: "service=turn&namespace=testnamespace&gateway=testgateway&listener=udp",
+		status: 200,
+		uris:   []string{"turn:0.0.0.0:3478?transport=udp"},
+	},
+	{
+		name:   "unroutable - drop unspecified, flag loopback",
+		policy: map[string]string{"unspecified": "drop", "loopback": "flag"},
+		patch: func(c *stnrv1.StunnerConfig) {
+			c.Listeners[0].PublicAddr, c.Listeners[0].Addr = "", ""
+			c.Listeners[1].PublicAddr, c.Listeners[1].Addr = "", "0.0.0.0"
+		},
+		params: "service=turn",
+		status: 200,
+		uris:   []string{"turns:127.0.0.1:3479?transport=tcp", "turns:127.0.0.1:3479?transport=udp"},
+		header: []string{
+			"testnamespace/testgateway/udp;addr=0.0.0.0;class=unspecified;action=drop",
+			"dummynamespace/testgateway/tcp;addr=0.0.0.0;class=unspecified;action=drop",
+			"testnamespace/dummygateway/tls;addr=127.0.0.1;class=loopback;action=flag",
+			"testnamespace/testgateway/dtls;addr=127.0.0.1;class=loopback;action=flag",
+		},
+	},
+	{
+		name:     "unroutable - fallback",
+		policy:   map[string]string{"unspecified": "fallback"},
+		fallback: "5.6.7.8",
+		patch: func(c *stnrv1.StunnerConfig) {
+			c.Listeners[0].PublicAddr, c.Listeners[0].Addr = "", "__node_address_placeholder"
+		},
+		params: "service=turn&namespace=testnamespace",
+		status: 200,
+		uris: []string{"turn:5.6.7.8:3478?transport=udp", "turns:127.0.0.1:3479?transport=tcp",
+			"turns:127.0.0.1:3479?transport=udp"},
+		header: []string{"testnamespace/testgateway/udp;addr=0.0.0.0;class=unspecified;action=fallback"},
+	},
+	{
+		name:   "unroutable - private and link-local",
+		policy: map[string]string{"private": "flag", "link-local": "drop", "loopback": "allow"},
+		patch: func(c *stnrv1.StunnerConfig) {
+			c.Listeners[0].PublicAddr = "10.0.0.1"
+			c.Listeners[2].PublicAddr = "169.254.0.1"
+		},
+		params: "service=turn",
+		status: 200,
+		uris: []string{"turn:10.0.0.1:3478?transport=udp", "turn:1.2.3.4:3478?transport=tcp",
+			"turns:127.0.0.1:3479?transport=udp"},
+		header: []string{
+			"testnamespace/testgateway/udp;addr=10.0.0.1;class=private;action=flag",
+			"testnamespace/dummygateway/tls;addr=169.254.0.1;class=link-local;action=drop",
+		},
+	},
+	{
+		name:   "unroutable - all dropped",
+		policy: map[string]string{"loopback": "drop"},
+		params: "service=turn&namespace=testnamespace&gateway=dummygateway",
+		status: http.StatusNotFound,
+		header: []string{"testnamespace/dummygateway/tls;addr=127.0.0.1;class=loopback;action=drop"},
+	},
+	{
+		name:   "unroutable - fallback without fallback address drops",
+		policy: map[string]string{"loopback": "fallback"},
+		params: "service=turn&namespace=testnamespace&gateway=dummygateway",
+		status: http.StatusNotFound,
+		header: []string{"testnamespace/dummygateway/tls;addr=127.0.0.1;class=loopback;action=drop"},
+	},
+}
+
+func TestUnroutable(t *testing.T) {
+	loggerFactory := logger.NewLoggerFactory(authTestLoglevel)
+
+	for _, tc := range unroutableTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := credentials.NewUnroutablePolicy(tc.policy)
+			assert.NoError(t, err, "policy")
+			h, err := handler.NewHandler(nil, loggerFactory.NewLogger("auth-svc"),
+				handler.WithUnroutablePolicy(p, tc.fallback))
+			assert.NoError(t, err, "create handler")
+			c := staticAuthConfig.DeepCopy()
+			if tc.patch != nil {
+				tc.patch(c)
+			}
+			h.SetConfig(c.Admin.Name, c)
+			serv := server.ServerInterfaceWrapper{Handler: h}
+
+			req := httptest.NewRequest("GET", "http://example.com/ice?"+tc.params, nil)
+			w := httptest.NewRecorder()
+			serv.GetIceAuth(w, req)
+
+			assert.Equal(t, tc.status, w.Code, "HTTP status")
+			assert.Equal(t, tc.header, w.Header().Values(handler.UnroutableHeader), "header")
+			if tc.status != 200 {
+				return
+			}
+			iceConfig := types.IceConfig{}
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &iceConfig), "decode")
+			assert.Equal(t, tc.uris, *(*iceConfig.IceServers)[0].Urls, "URIs")
+
+			// the header is set for the TURN REST API too
+			req = httptest.NewRequest("GET", "http://example.com/?"+tc.params+"&key=dummy", nil)
+			w = httptest.NewRecorder()
+			serv.GetTurnAuth(w, req)
+			assert.Equal(t, http.StatusOK, w.Code, "HTTP status")
+			assert.Equal(t, tc.header, w.Header().Values(handler.UnroutableHeader), "TURN API header")
+		})
+	}
+}
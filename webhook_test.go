@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+
+	stnrv1 "github.com/l7mp/stunner/pkg/apis/v1"
+	a12n "github.com/l7mp/stunner/pkg/authentication"
+	"github.com/l7mp/stunner/pkg/logger"
+
+	"github.com/l7mp/stunner-auth-service/internal/handler"
+	"github.com/l7mp/stunner-auth-service/internal/webhook"
+	"github.com/l7mp/stunner-auth-service/pkg/credentials"
+	"github.com/l7mp/stunner-auth-service/pkg/server"
+	"github.com/l7mp/stunner-auth-service/pkg/types"
+)
+
+// webhookStandIn is a local stand-in for an external authorization webhook
+type webhookStandIn struct {
+	*httptest.Server
+	reviews []webhook.Review
+	lock    sync.Mutex
+}
+
+func newWebhookStandIn() *webhookStandIn {
+	s := &webhookStandIn{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		review := webhook.Review{}
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.lock.Lock()
+		s.reviews = append(s.reviews, review)
+		s.lock.Unlock()
+
+		resp := webhook.Response{Allowed: true}
+		switch review.Request.Username {
+		case "denied":
+			resp = webhook.Response{Allowed: false, Reason: "user blocked"}
+		case "slow":
+			time.Sleep(200 * time.Millisecond)
+		case "negative-ttl":
+			ttl := -1
+			resp.TTL = &ttl
+		case "override":
+			user, ns, gw, l := "overridden", "testnamespace", "testgateway", "udp-2"
+			resp.Username, resp.Namespace, resp.Gateway, resp.Listener = &user, &ns, &gw, &l
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	return s
+}
+
+func (s *webhookStandIn) Reviews() []webhook.Review {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]webhook.Review{}, s.reviews...)
+}
+
+func newTestWebhook(t *testing.T, config webhook.Config) *webhook.Webhook {
+	loggerFactory := logger.NewLoggerFactory(authTestLoglevel)
+	wh, err := webhook.New(config, loggerFactory.NewLogger("webhook"))
+	assert.NoError(t, err, "create webhook")
+	return wh
+}
+
+var iceWebhookTestCases = []iceAuthTestCase{
+	{
+		name:   "webhook - allowed",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&username=dummy&key=my-key",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			iceServers := *iceConfig.IceServers
+			assert.Len(t, iceServers, 1, "ICE servers len")
+			assert.Len(t, *iceServers[0].Urls, 4, "URI len")
+		},
+	},
+	{
+		name:   "webhook - denied",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&username=denied",
+		status: http.StatusForbidden,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	},
+	{
+		name:   "webhook - username and filters overridden",
+		config: []*stnrv1.StunnerConfig{&ephemeralAuthConfig},
+		params: "service=turn&username=override",
+		status: 200,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			iceServers := *iceConfig.IceServers
+			assert.Len(t, iceServers, 1, "ICE servers len")
+			assert.Regexp(t, regexp.MustCompile(`^\d+:overridden$`), *iceServers[0].Username, "username ok")
+			assert.Equal(t, []string{"turn:1.2.3.5:3478?transport=udp"}, *iceServers[0].Urls, "URIs")
+		},
+	},
+}
+
+func TestICEWebhook(t *testing.T) {
+	s := newWebhookStandIn()
+	defer s.Close()
+
+	wh := newTestWebhook(t, webhook.Config{URL: s.URL})
+	runICE(t, iceWebhookTestCases, handler.WithWebhook(wh))
+
+	reviews := s.Reviews()
+	assert.Len(t, reviews, 3, "reviews")
+	assert.Equal(t, "dummy", reviews[0].Request.Username, "username")
+	assert.NotNil(t, reviews[0].Identity, "identity")
+	assert.Equal(t, "my-key", reviews[0].Identity.Key, "key")
+	assert.Equal(t, []string{"dummynamespace/testgateway", "testnamespace/dummygateway",
+		"testnamespace/testgateway"}, reviews[0].Candidates, "candidates")
+}
+
+func TestICEWebhookFailure(t *testing.T) {
+	s := newWebhookStandIn()
+	defer s.Close()
+
+	testCases := []iceAuthTestCase{{
+		name:   "webhook - timeout",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&username=slow",
+		status: http.StatusServiceUnavailable,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	}, {
+		name:   "webhook - negative TTL",
+		config: []*stnrv1.StunnerConfig{&staticAuthConfig},
+		params: "service=turn&username=negative-ttl",
+		status: http.StatusServiceUnavailable,
+		tester: func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {},
+	}}
+
+	// fail closed
+	wh := newTestWebhook(t, webhook.Config{URL: s.URL, Timeout: 50 * time.Millisecond})
+	runICE(t, testCases, handler.WithWebhook(wh))
+
+	// fail open
+	for i := range testCases {
+		testCases[i].status = 200
+		testCases[i].tester = func(t *testing.T, iceConfig *types.IceConfig, authHandler a12n.AuthHandler) {
+			assert.Len(t, *iceConfig.IceServers, 1, "ICE servers len")
+		}
+	}
+	wh = newTestWebhook(t, webhook.Config{URL: s.URL, Timeout: 50 * time.Millisecond, FailOpen: true})
+	runICE(t, testCases, handler.WithWebhook(wh))
+}
+
+func TestICEWebhookCache(t *testing.T) {
+	s := newWebhookStandIn()
+	defer s.Close()
+
+	wh := newTestWebhook(t, webhook.Config{URL: s.URL, CacheTTL: time.Minute})
+	runICE(t, []iceAuthTestCase{iceWebhookTestCases[0], iceWebhookTestCases[0],
+		iceWebhookTestCases[1], iceWebhookTestCases[1]}, handler.WithWebhook(wh))
+
+	assert.Len(t, s.Reviews(), 2, "reviews")
+
+	// the port of the remote address is not part of the cache key
+	review := func(remoteAddr string) {
+		req := credentials.Request{Username: "dummy", Identity: &credentials.Identity{
+			UnverifiedUser: "user1", RemoteAddr: remoteAddr}}
+		assert.NoError(t, wh.Authorize(context.Background(), &req, nil), "authorize")
+	}
+	review("10.0.0.1:40000")
+	review("10.0.0.1:40001")
+	assert.Len(t, s.Reviews(), 3, "reviews")
+	assert.Equal(t, "user1", s.Reviews()[2].Identity.UnverifiedUser, "unverified user")
+	assert.Equal(t, "10.0.0.1:40000", s.Reviews()[2].Identity.RemoteAddr, "remote address")
+	review("10.0.0.2:40000")
+	assert.Len(t, s.Reviews(), 4, "reviews")
+}
+
+func TestAPIKeyNotLogged(t *testing.T) {
+	s := newWebhookStandIn()
+	defer s.Close()
+
+	buf := &bytes.Buffer{}
+	loggerFactory := &logging.DefaultLoggerFactory{Writer: buf, DefaultLogLevel: logging.LogLevelTrace}
+	wh, err := webhook.New(webhook.Config{URL: s.URL}, loggerFactory.NewLogger("webhook"))
+	assert.NoError(t, err, "create webhook")
+	h, err := handler.NewHandler(nil, loggerFactory.NewLogger("auth-svc"), handler.WithWebhook(wh))
+	assert.NoError(t, err, "create handler")
+	h.SetConfig(staticAuthConfig.Admin.Name, &staticAuthConfig)
+	serv := server.ServerInterfaceWrapper{Handler: h}
+
+	w := httptest.NewRecorder()
+	serv.GetIceAuth(w, httptest.NewRequest("GET", "http://example.com/ice?service=turn&key=secret-api-key", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "ICE: HTTP status")
+	w = httptest.NewRecorder()
+	serv.GetTurnAuth(w, httptest.NewRequest("GET", "http://example.com/?service=turn&key=secret-api-key", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "TURN: HTTP status")
+
+	assert.Len(t, s.Reviews(), 2, "reviews")
+	assert.Equal(t, "secret-api-key", s.Reviews()[0].Identity.Key, "key sent to the webhook")
+	assert.Contains(t, buf.String(), "Calling authorization webhook", "webhook logs")
+	assert.Contains(t, buf.String(), "serving TURN auth token request", "handler logs")
+	assert.NotContains(t, buf.String(), "secret-api-key", "key not logged")
+}